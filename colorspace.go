@@ -0,0 +1,193 @@
+// This file provides images whose pixels are stored in a color space other
+// than RGB.
+
+package netpbm
+
+import (
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/spakin/netpbm/npcolor"
+)
+
+// A SpaceM is an in-memory image whose At method returns npcolor.SpaceM
+// values.  Its three samples per pixel represent coordinates in a color space
+// such as HSV or L*a*b*.  SpaceM images are stored in PAM files with the
+// color space's tuple type (e.g., "HSV" or "LAB").
+type SpaceM struct {
+	// Pix holds the image's pixels, three samples per pixel.  Samples
+	// are 8-bit if the maximum value is less than 256 and 16-bit
+	// big-endian otherwise.  The pixel at (x, y) starts at
+	// Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*3*bytesPerSample].
+	Pix []uint8
+	// Stride is the Pix stride (in bytes) between vertically adjacent
+	// pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+	// Model is the image's color model.
+	Model npcolor.SpaceMModel
+}
+
+// ColorModel returns the SpaceM image's color model.
+func (p *SpaceM) ColorModel() color.Model { return p.Model }
+
+// Bounds returns the domain for which At can return non-zero color.  The
+// bounds do not necessarily contain the point (0, 0).
+func (p *SpaceM) Bounds() image.Rectangle { return p.Rect }
+
+// At returns the color of the pixel at (x, y) as a color.Color.
+// At(Bounds().Min.X, Bounds().Min.Y) returns the upper-left pixel of the grid.
+// At(Bounds().Max.X-1, Bounds().Max.Y-1) returns the lower-right one.
+func (p *SpaceM) At(x, y int) color.Color {
+	return p.SpaceMAt(x, y)
+}
+
+// SpaceMAt returns the color of the pixel at (x, y) as an npcolor.SpaceM.
+func (p *SpaceM) SpaceMAt(x, y int) npcolor.SpaceM {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return npcolor.SpaceM{Space: p.Model.Space}
+	}
+	i := p.PixOffset(x, y)
+	c := npcolor.SpaceM{M: p.Model.M, Space: p.Model.Space}
+	if p.Model.M < 256 {
+		for j := range c.C {
+			c.C[j] = uint16(p.Pix[i+j])
+		}
+	} else {
+		for j := range c.C {
+			c.C[j] = uint16(p.Pix[i+2*j])<<8 | uint16(p.Pix[i+2*j+1])
+		}
+	}
+	return c
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to
+// the pixel at (x, y).
+func (p *SpaceM) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*3*p.Model.BytesPerSample()
+}
+
+// Set sets the pixel at (x, y) to a given color, expressed as a color.Color.
+func (p *SpaceM) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	i := p.PixOffset(x, y)
+	c1 := p.Model.Convert(c).(npcolor.SpaceM)
+	if p.Model.M < 256 {
+		for j, v := range c1.C {
+			p.Pix[i+j] = uint8(v)
+		}
+	} else {
+		for j, v := range c1.C {
+			p.Pix[i+2*j] = uint8(v >> 8)
+			p.Pix[i+2*j+1] = uint8(v)
+		}
+	}
+}
+
+// SetSpaceM sets the pixel at (x, y) to a given color, expressed as an
+// npcolor.SpaceM.
+func (p *SpaceM) SetSpaceM(x, y int, c npcolor.SpaceM) {
+	p.Set(x, y, c)
+}
+
+// RGBA64At returns the color of the pixel at (x, y) as a color.RGBA64.
+func (p *SpaceM) RGBA64At(x, y int) color.RGBA64 {
+	r, g, b, a := p.SpaceMAt(x, y).RGBA()
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
+
+// SetRGBA64 sets the pixel at (x, y) to a given color, expressed as a
+// color.RGBA64.
+func (p *SpaceM) SetRGBA64(x, y int, c color.RGBA64) {
+	p.Set(x, y, c)
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *SpaceM) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to
+	// be inside either r1 or r2 if the intersection is empty. Without
+	// explicitly checking for this, the Pix[i:] expression below can
+	// panic.
+	if r.Empty() {
+		return &SpaceM{Model: p.Model}
+	}
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+	return &SpaceM{
+		Pix:    p.Pix[i:],
+		Stride: p.Stride,
+		Rect:   r,
+		Model:  p.Model,
+	}
+}
+
+// Opaque scans the entire image and reports whether it is fully opaque.
+func (p *SpaceM) Opaque() bool {
+	return true
+}
+
+// MaxValue returns the maximum value allowed on any sample.
+func (p *SpaceM) MaxValue() uint16 {
+	return p.Model.M
+}
+
+// Format identifies the image as a PAM image.
+func (p *SpaceM) Format() Format {
+	return PAM
+}
+
+// HasAlpha indicates that there is no alpha channel.
+func (p *SpaceM) HasAlpha() bool {
+	return false
+}
+
+// ToRGB converts the image to RGB, returning an *RGBM if the maximum value is
+// less than 256 or an *RGBM64 otherwise.  The result has the same maximum
+// value as p.
+func (p *SpaceM) ToRGB() Image {
+	nimg := imageFromModel(p.Rect, npcolor.NewRGBModel(p.Model.M))
+	copyPixels(nimg, p)
+	return nimg
+}
+
+// NewSpaceM returns a new SpaceM with the given bounds, color space, and
+// maximum sample value.
+func NewSpaceM(r image.Rectangle, s npcolor.Space, m uint16) *SpaceM {
+	return imageFromModel(r, npcolor.SpaceMModel{Space: s, M: m}).(*SpaceM)
+}
+
+// ConvertToSpace converts an image to color space s.  The result has the
+// same maximum value as img if img is a Netpbm image with integer samples
+// other than a PBM image, 255 if img is a PBM image, and 65535 otherwise.
+// Alpha channels are discarded.
+func ConvertToSpace(img image.Image, s npcolor.Space) *SpaceM {
+	maxVal := uint16(0xffff)
+	if model, ok := img.ColorModel().(npcolor.Model); ok && model.BytesPerSample() <= 2 {
+		maxVal = model.MaxValue()
+	}
+	switch img.(type) {
+	case *BW, *PackedBW:
+		maxVal = 255
+	}
+	nimg := NewSpaceM(img.Bounds(), s, maxVal)
+	copyPixels(nimg, img)
+	return nimg
+}
+
+// encodeSpaceData writes image data as samples in color space s.
+func encodeSpaceData(w io.Writer, img image.Image, opts *EncodeOptions, s npcolor.Space) error {
+	// Convert each row of pixels to samples.
+	rect := img.Bounds()
+	cm := npcolor.SpaceMModel{Space: s, M: opts.MaxValue}
+	return writeRaster(w, img, opts, 3, false, func(y int, row []uint16) {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := cm.Convert(img.At(x, y)).(npcolor.SpaceM)
+			copy(row[(x-rect.Min.X)*3:], c.C[:])
+		}
+	})
+}
@@ -0,0 +1,126 @@
+// Test images stored in non-RGB color spaces.
+
+package netpbm
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spakin/netpbm/npcolor"
+)
+
+// spaceSource returns a small color image with the given maximum value.
+func spaceSource(m uint16) Image {
+	img := imageFromModel(image.Rect(0, 0, 4, 3), npcolor.NewRGBModel(m))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 4; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x * 80), uint8(y * 120), uint8((x + y) * 40), 255})
+		}
+	}
+	return img
+}
+
+// TestSpaceRoundTrip confirms that images converted to each color space can
+// be written to and read from PAM files without loss.
+func TestSpaceRoundTrip(t *testing.T) {
+	for s := npcolor.SpaceHSV; s <= npcolor.SpaceLab; s++ {
+		for _, m := range []uint16{255, 1000, 65535} {
+			// Convert the image to color space s.
+			src := spaceSource(m)
+			img := ConvertToSpace(src, s)
+			if img.MaxValue() != m || img.Model.Space != s {
+				t.Fatalf("Expected maxval %d and space %d but saw %d and %d", m, s, img.MaxValue(), img.Model.Space)
+			}
+
+			// Write the image as a PAM file.
+			var w bytes.Buffer
+			if err := Encode(&w, img, nil); err != nil {
+				t.Fatal(err)
+			}
+			if tt := "TUPLTYPE " + s.TupleType() + "\n"; !strings.Contains(w.String(), tt) {
+				t.Fatalf("Expected header to contain %q", tt)
+			}
+			data := w.Bytes()
+
+			// Read the header and the image and compare.
+			cfg, err := DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.ColorModel != img.Model {
+				t.Fatalf("Expected color model %v but saw %v", img.Model, cfg.ColorModel)
+			}
+			img2, err := Decode(bytes.NewReader(data), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(img, img2) {
+				t.Fatalf("Expected %v but saw %v", img, img2)
+			}
+
+			// Converting back to RGB should produce an image of the
+			// original type.
+			if rgb := img.ToRGB(); reflect.TypeOf(rgb) != reflect.TypeOf(src) || rgb.MaxValue() != m {
+				t.Fatalf("Expected a %T with maxval %d but saw a %T with maxval %d", src, m, rgb, rgb.MaxValue())
+			}
+		}
+	}
+}
+
+// TestEncodeSpace confirms that Encode converts images to a color space
+// named by the TupleType option.
+func TestEncodeSpace(t *testing.T) {
+	// Prepare a standard-library image and the same image converted to
+	// L*a*b*.
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 4; x++ {
+			nrgba.SetNRGBA(x, y, color.NRGBA{uint8(x * 80), uint8(y * 120), 50, 255})
+		}
+	}
+	exp := ConvertToSpace(nrgba, npcolor.SpaceLab)
+
+	// Encode the original image as L*a*b* and decode it.
+	var w bytes.Buffer
+	err := Encode(&w, nrgba, &EncodeOptions{Format: PAM, MaxValue: 65535, TupleType: "LAB"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := Decode(&w, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(img, exp) {
+		t.Fatalf("Expected %v but saw %v", exp, img)
+	}
+
+	// Minimizing should preserve the color space.
+	w.Reset()
+	if err = Encode(&w, exp, &EncodeOptions{Minimize: true}); err != nil {
+		t.Fatal(err)
+	}
+	img, err = Decode(&w, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(img, exp) {
+		t.Fatalf("Expected %v but saw %v", exp, img)
+	}
+
+	// Encoding as PPM should convert back to RGB.
+	w.Reset()
+	if err = Encode(&w, exp, &EncodeOptions{Format: PPM}); err != nil {
+		t.Fatal(err)
+	}
+	img, err = Decode(&w, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(img, exp.ToRGB()) {
+		t.Fatalf("Expected %v but saw %v", exp.ToRGB(), img)
+	}
+}
@@ -0,0 +1,141 @@
+// This file provides compositing of translucent images over an opaque
+// background.
+
+package netpbm
+
+import (
+	"image"
+	"image/color"
+	"strings"
+
+	"github.com/spakin/netpbm/npcolor"
+)
+
+// A BlendSpace specifies the color space in which translucent pixels are
+// composited over a background.
+type BlendSpace int
+
+// These are the color spaces in which Flatten can composite.
+const (
+	// GammaBlend blends gamma-adjusted samples directly, as most
+	// software does.
+	GammaBlend BlendSpace = iota
+
+	// LinearBlend converts samples to linear light using the image's
+	// transfer function, which Flatten takes to be the ITU-R BT.709
+	// transfer function that the Netpbm specification prescribes,
+	// blends them, and converts the result back.
+	LinearBlend
+)
+
+// A flattenedImage presents an image as though it were composited over an
+// opaque background.
+type flattenedImage struct {
+	image.Image
+	bg    [3]uint32        // Alpha-premultiplied 16-bit background color
+	bgLin [3]float64       // Background color in linear light
+	space BlendSpace       // Color space in which to blend colors
+	tf    npcolor.Transfer // Transfer function of the image's samples
+}
+
+// newFlattenedImage returns a view of img, whose samples are encoded with
+// transfer function tf, composited over bg.  A translucent bg is first
+// composited over black.
+func newFlattenedImage(img image.Image, bg color.Color, space BlendSpace, tf npcolor.Transfer) *flattenedImage {
+	f := &flattenedImage{Image: img, space: space, tf: tf}
+	f.bg[0], f.bg[1], f.bg[2], _ = bg.RGBA()
+	for i, v := range f.bg {
+		f.bgLin[i] = tf.ToLinear(float64(v) / 0xffff)
+	}
+	return f
+}
+
+// ColorModel returns the flattened image's color model.
+func (f *flattenedImage) ColorModel() color.Model {
+	return color.RGBA64Model
+}
+
+// Opaque reports that a flattened image is fully opaque.
+func (f *flattenedImage) Opaque() bool {
+	return true
+}
+
+// At returns the color of the pixel at (x, y) composited over the
+// background.  Opaque colors are returned unmodified.
+func (f *flattenedImage) At(x, y int) color.Color {
+	c := f.Image.At(x, y)
+	r, g, b, a := c.RGBA()
+	if a == 0xffff {
+		return c
+	}
+	var out [3]uint32
+	t := 0xffff - a
+	switch f.space {
+	case LinearBlend:
+		// Blend unpremultiplied colors in linear light.
+		n := npcolor.RGBAM64Model{M: 0xffff}.Convert(c).(npcolor.RGBAM64)
+		af := float64(a) / 0xffff
+		for i, v := range [...]uint16{n.R, n.G, n.B} {
+			l := f.tf.ToLinear(float64(v)/0xffff)*af + f.bgLin[i]*(1-af)
+			out[i] = uint32(f.tf.FromLinear(l)*0xffff + 0.5)
+		}
+	default:
+		// Add the background, weighted by transparency, to the
+		// premultiplied color.
+		for i, v := range [...]uint32{r, g, b} {
+			out[i] = v + (f.bg[i]*t+0xffff/2)/0xffff
+		}
+	}
+	return color.RGBA64{R: uint16(out[0]), G: uint16(out[1]), B: uint16(out[2]), A: 0xffff}
+}
+
+// Flatten composites an image over an opaque background color, blending in
+// the given color space, and returns the result as a new, opaque Netpbm
+// image.  A translucent background is first composited over black.  The
+// result is a GrayM or GrayM32 if both img and bg are grayscale and an RGBM
+// or RGBM64 otherwise.  Its maximum value is img's if img is a Netpbm image,
+// 65535 if img has a 16-bit color model, and 255 otherwise.  Flatten assumes
+// that img's samples are encoded with the BT.709 transfer function, while
+// Decode and Encode honor their options' Transfer fields.
+func Flatten(img image.Image, bg color.Color, space BlendSpace) Image {
+	return flatten(img, bg, space, npcolor.BT709)
+}
+
+// flatten implements Flatten for an image whose samples are encoded with
+// transfer function tf.
+func flatten(img image.Image, bg color.Color, space BlendSpace, tf npcolor.Transfer) Image {
+	// Determine the maximum value of the flattened image.
+	var maxVal uint16 = 255
+	switch m := img.ColorModel(); {
+	case m == color.RGBA64Model, m == color.NRGBA64Model, m == color.Gray16Model, m == color.Alpha16Model:
+		maxVal = 0xffff
+	}
+	if nimg, ok := img.(Image); ok {
+		maxVal = nimg.MaxValue()
+	}
+
+	// Allocate an image of the appropriate type.
+	r, g, b, _ := bg.RGBA()
+	gray := r == g && g == b && !strings.HasPrefix(inferTupleType(img.ColorModel()), "RGB")
+	rect := img.Bounds()
+	var flat Image
+	switch {
+	case gray && maxVal < 256:
+		flat = NewGrayM(rect, uint8(maxVal))
+	case gray:
+		flat = NewGrayM32(rect, maxVal)
+	case maxVal < 256:
+		flat = NewRGBM(rect, uint8(maxVal))
+	default:
+		flat = NewRGBM64(rect, maxVal)
+	}
+
+	// Composite each pixel over the background.
+	f := newFlattenedImage(img, bg, space, tf)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			flat.Set(x, y, f.At(x, y))
+		}
+	}
+	return flat
+}
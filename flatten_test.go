@@ -0,0 +1,124 @@
+// Test compositing over a background.
+
+package netpbm
+
+import (
+	"bytes"
+	"compress/flate"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/spakin/netpbm/npcolor"
+)
+
+// TestFlattenGamma confirms that Flatten composites gamma-adjusted samples
+// directly.
+func TestFlattenGamma(t *testing.T) {
+	img := NewRGBAM(image.Rect(0, 0, 3, 1), 255)
+	img.SetRGBAM(0, 0, npcolor.RGBAM{R: 10, G: 20, B: 30, A: 255, M: 255})
+	img.SetRGBAM(1, 0, npcolor.RGBAM{R: 10, G: 20, B: 30, A: 0, M: 255})
+	img.SetRGBAM(2, 0, npcolor.RGBAM{R: 255, G: 0, B: 100, A: 51, M: 255})
+	flat, ok := Flatten(img, color.RGBA{R: 0, G: 0, B: 255, A: 255}, GammaBlend).(*RGBM)
+	if !ok {
+		t.Fatalf("Expected an *RGBM but saw %T", flat)
+	}
+	for x, e := range []npcolor.RGBM{
+		{R: 10, G: 20, B: 30, M: 255}, // Opaque: unchanged
+		{R: 0, G: 0, B: 255, M: 255},  // Transparent: background
+		{R: 51, G: 0, B: 224, M: 255}, // 20% foreground, 80% background
+	} {
+		if c := flat.RGBMAt(x, 0); c != e {
+			t.Fatalf("Expected %v at (%d, 0) but saw %v", e, x, c)
+		}
+	}
+}
+
+// TestFlattenLinear confirms that Flatten can composite in linear light.
+func TestFlattenLinear(t *testing.T) {
+	img := NewGrayAM48(image.Rect(0, 0, 1, 1), 1000)
+	img.SetGrayAM48(0, 0, npcolor.GrayAM48{Y: 1000, A: 500, M: 1000})
+	flat, ok := Flatten(img, color.Black, LinearBlend).(*GrayM32)
+	if !ok {
+		t.Fatalf("Expected a *GrayM32 but saw %T", flat)
+	}
+
+	// Half of linear white is brighter than half of gamma-adjusted white.
+	e := uint16(math.Round((1.099*math.Pow(0.5, 0.45) - 0.099) * 1000))
+	if c := flat.GrayM32At(0, 0); c.Y != e {
+		t.Fatalf("Expected Y=%d but saw %v", e, c)
+	}
+	flat = Flatten(img, color.Black, GammaBlend).(*GrayM32)
+	if c := flat.GrayM32At(0, 0); c.Y != 500 {
+		t.Fatalf("Expected Y=500 but saw %v", c)
+	}
+}
+
+// TestFlattenColorBackground confirms that flattening a grayscale image over
+// a colored background produces a color image.
+func TestFlattenColorBackground(t *testing.T) {
+	img := pamImageFromString(t, pamRawGrayAlpha)
+	if _, ok := Flatten(img, color.Gray{Y: 200}, GammaBlend).(*GrayM); !ok {
+		t.Fatal("Flattening over gray did not produce a *GrayM")
+	}
+	if _, ok := Flatten(img, color.RGBA{R: 200, A: 255}, GammaBlend).(*RGBM); !ok {
+		t.Fatal("Flattening over red did not produce an *RGBM")
+	}
+}
+
+// TestEncodeBackground confirms that Encode composites translucent pixels
+// over a background only when the output format lacks alpha.
+func TestEncodeBackground(t *testing.T) {
+	img := pamImageFromString(t, pamRawColorAlpha)
+	bg := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+
+	// Encoding to PPM should match encoding the flattened image.
+	var w1, w2 bytes.Buffer
+	if err := Encode(&w1, img, &EncodeOptions{Format: PPM, Background: bg}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Encode(&w2, Flatten(img, bg, GammaBlend), &EncodeOptions{Format: PPM}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w1.Bytes(), w2.Bytes()) {
+		t.Fatal("Encoding with a background differs from encoding a flattened image")
+	}
+
+	// Encoding to PAM with alpha should ignore the background.
+	w1.Reset()
+	w2.Reset()
+	if err := Encode(&w1, img, &EncodeOptions{Format: PAM, Background: bg}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Encode(&w2, img, &EncodeOptions{Format: PAM}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w1.Bytes(), w2.Bytes()) {
+		t.Fatal("Encoding to PAM with alpha did not ignore the background")
+	}
+}
+
+// TestDecodeBackground confirms that Decode with a PNM target composites
+// translucent images over a background.
+func TestDecodeBackground(t *testing.T) {
+	r := flate.NewReader(bytes.NewBufferString(pamRawColorAlpha))
+	defer r.Close()
+	bg := color.RGBA{R: 0, G: 128, B: 0, A: 255}
+	img, err := Decode(r, &DecodeOptions{Target: PNM, Background: bg, Blend: LinearBlend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.HasAlpha() {
+		t.Fatal("Decoded image unexpectedly has an alpha channel")
+	}
+	flat := Flatten(pamImageFromString(t, pamRawColorAlpha), bg, LinearBlend)
+	rect := img.Bounds()
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if c1, c2 := img.At(x, y), flat.At(x, y); c1 != c2 {
+				t.Fatalf("Expected %v at (%d, %d) but saw %v", c2, x, y, c1)
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+//go:build ignore
+// +build ignore
+
+// This program generates npcolor's table of color names from an X11 rgb.txt
+// file.  Run it from the npcolor directory as
+//
+//	go run ../helpers/namegen.go /usr/share/X11/rgb.txt
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	// Open the rgb.txt file.
+	if len(os.Args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s <rgb.txt>\n", os.Args[0])
+		os.Exit(1)
+	}
+	fd, err := os.Open(os.Args[1])
+	if err != nil {
+		panic(err)
+	}
+	defer fd.Close()
+
+	// Emit a Go declaration of the color table.
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, `// This file lists the color names defined by X11's rgb.txt.
+//
+// This is a generated file.  DO NOT EDIT.  Run "go generate" instead, which
+// reads /usr/share/X11/rgb.txt and therefore requires X11's rgb.txt to be
+// installed on the host.
+
+package npcolor
+
+// colorNames maps color names to 8-bit red, green, and blue values, in the
+// order in which they appear in rgb.txt.
+var colorNames = []namedColor{`)
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		// Skip blank lines and comments.
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '!' || line[0] == '#' {
+			continue
+		}
+
+		// Parse the red, green, and blue values and the name.
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			panic(fmt.Sprintf("Malformed line %q", line))
+		}
+		var rgb [3]uint64
+		for i := range rgb {
+			rgb[i], err = strconv.ParseUint(fields[i], 10, 8)
+			if err != nil {
+				panic(err)
+			}
+		}
+		name := strings.Join(fields[3:], " ")
+		fmt.Fprintf(&buf, "\t{%q, %d, %d, %d},\n", name, rgb[0], rgb[1], rgb[2])
+	}
+	if err = scanner.Err(); err != nil {
+		panic(err)
+	}
+	fmt.Fprintln(&buf, "}")
+
+	// Format and write the table.
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		panic(err)
+	}
+	if err = ioutil.WriteFile("rgbnames.go", src, 0666); err != nil {
+		panic(err)
+	}
+}
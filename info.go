@@ -0,0 +1,117 @@
+// This file provides decoding that reports how an image file was encoded.
+
+package netpbm
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+)
+
+// DecodeWithInfo reads a Netpbm image from r and returns it as an Image.
+// Unlike Decode, it also returns a set of EncodeOptions describing the file's
+// format, maximum value, raster encoding (plain or raw), PAM tuple type, and
+// header comments in the order they appeared.  If the file is not laid out as
+// Encode would lay it out, the options additionally record the exact text of
+// the header in HeaderText and the whitespace and comments surrounding each
+// sample of a plain raster in Layout.Separators.  Passing the image and the
+// options to Encode therefore reproduces the file byte for byte, provided
+// that opts does not alter the image (e.g., by discarding a PAM image's alpha
+// channel, as a Target of PNM does).  The whitespace and comments following
+// the final sample of a plain raster are considered part of the raster and
+// are consumed.  Pass in a bufio.Reader if you intend to read data following
+// the image.  DecodeWithInfo does not support opts.Scale.
+func DecodeWithInfo(r io.Reader, opts *DecodeOptions) (Image, EncodeOptions, error) {
+	// Provide default options.
+	o, err := defaultDecodeOptions(opts)
+	if err != nil {
+		return nil, EncodeOptions{}, err
+	}
+	if o.Scale > 1 {
+		return nil, EncodeOptions{}, errors.New("DecodeWithInfo does not support scaling")
+	}
+
+	// Parse the header.
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	nr := newNetpbmReader(br)
+	nr.record = new(bytes.Buffer)
+	header, err := nr.GetHeader()
+	if err != nil {
+		return nil, EncodeOptions{}, err
+	}
+	text := nr.record.String()
+	nr.record = nil
+	if err = checkPlainPAM(header, o.AllowPlainPAM); err != nil {
+		return nil, EncodeOptions{}, err
+	}
+	info, err := headerEncodeOptions(header)
+	if err != nil {
+		return nil, EncodeOptions{}, err
+	}
+
+	// Retain the header's text if EncodeHeader would format it
+	// differently.
+	var def bytes.Buffer
+	if err = EncodeHeader(&def, header); err != nil || def.String() != text {
+		info.HeaderText = text
+	}
+
+	// Read the entire raster.
+	rr, err := newRasterReader(nr, header)
+	if err != nil {
+		return nil, EncodeOptions{}, err
+	}
+	rect := image.Rect(0, 0, header.Width, header.Height)
+	img, err := imageFromHeader(header, rect, nil)
+	if err != nil {
+		return nil, EncodeOptions{}, err
+	}
+	rr.RecordLayout()
+	if err = readRegion(rr, img, rect); err != nil {
+		return nil, EncodeOptions{}, err
+	}
+	info.Layout.Separators, err = rr.FinishLayout()
+	if err != nil {
+		return nil, EncodeOptions{}, err
+	}
+
+	// Convert the image to the requested format.
+	img, err = convertToTarget(img, &o)
+	if err != nil {
+		return nil, EncodeOptions{}, err
+	}
+	o.storeMetadata(header.Comments)
+	return img, info, nil
+}
+
+// headerEncodeOptions returns the EncodeOptions that produce a given header.
+func headerEncodeOptions(header Header) (EncodeOptions, error) {
+	info := EncodeOptions{
+		MaxValue: uint16(header.Maxval),
+		Plain:    header.Plain,
+	}
+	if len(header.Comments) > 0 {
+		info.Comments = append([]string(nil), header.Comments...)
+	}
+	switch header.Magic {
+	case "P1", "P4":
+		info.Format = PBM
+	case "P2", "P5":
+		info.Format = PGM
+	case "P3", "P6":
+		info.Format = PPM
+	case "P7":
+		info.Format = PAM
+		info.TupleType = header.TupleType
+		info.AllowPlainPAM = header.Plain
+	default:
+		return EncodeOptions{}, fmt.Errorf("Unrecognized magic sequence %q", header.Magic)
+	}
+	return info, nil
+}
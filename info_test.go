@@ -0,0 +1,174 @@
+// Test decoding with encoding information.
+
+package netpbm
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"reflect"
+	"testing"
+)
+
+// roundTripInfo decodes a file with DecodeWithInfo, re-encodes it with the
+// returned options, and confirms that the result is identical to the
+// original.
+func roundTripInfo(t *testing.T, name string, data []byte) {
+	img, info, err := DecodeWithInfo(bytes.NewReader(data), &DecodeOptions{
+		Target:        PAM,
+		AllowPlainPAM: true,
+	})
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	var w bytes.Buffer
+	if err = Encode(&w, img, &info); err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	if !bytes.Equal(data, w.Bytes()) {
+		t.Fatalf("%s: Round trip of %d bytes produced %d different bytes", name, len(data), w.Len())
+	}
+}
+
+// TestDecodeWithInfoCorpus confirms that a corpus of images round-trips
+// losslessly through DecodeWithInfo and Encode.  The corpus comprises the raw
+// test images and each test image re-encoded in a variety of flavors.
+func TestDecodeWithInfoCorpus(t *testing.T) {
+	imgStrs := []struct {
+		name   string
+		imgStr string
+	}{
+		{"pbmRaw", pbmRaw},
+		{"pbmPlain", pbmPlain},
+		{"pgmRaw", pgmRaw},
+		{"pgmPlain", pgmPlain},
+		{"ppmRaw", ppmRaw},
+		{"ppmPlain", ppmPlain},
+		{"pamRawColor", pamRawColor},
+		{"pamRawColorAlpha", pamRawColorAlpha},
+		{"pamRawGray", pamRawGray},
+		{"pamRawGrayAlpha", pamRawGrayAlpha},
+	}
+	for _, is := range imgStrs {
+		// Every test image should round-trip, including the plain
+		// ones with their irregular header and raster layouts.
+		data := decompressString(t, is.imgStr)
+		roundTripInfo(t, is.name, data)
+
+		// Re-encode each image in both plain and raw format, with
+		// and without comments, and with a variety of maximum
+		// values.
+		img, err := Decode(bytes.NewReader(data), &DecodeOptions{Target: PAM})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, plain := range []bool{false, true} {
+			for _, maxVal := range []uint16{0, 1, 100, 255, 256, 1000, 65535} {
+				opts := &EncodeOptions{
+					Plain:    plain,
+					MaxValue: maxVal,
+					Comments: []string{"First comment", "", "  Indented comment "},
+				}
+				var w bytes.Buffer
+				if err = Encode(&w, img, opts); err != nil {
+					t.Fatal(err)
+				}
+				name := fmt.Sprintf("%s(plain=%v, maxval=%d)", is.name, plain, maxVal)
+				roundTripInfo(t, name, w.Bytes())
+
+				// Also encode the image as a PAM file.
+				w.Reset()
+				opts.Format = PAM
+				opts.AllowPlainPAM = plain
+				if err = Encode(&w, img, opts); err != nil {
+					t.Fatal(err)
+				}
+				roundTripInfo(t, name+" as PAM", w.Bytes())
+			}
+		}
+	}
+}
+
+// TestDecodeWithInfoOptions confirms that DecodeWithInfo reports the options
+// with which an image was encoded.
+func TestDecodeWithInfoOptions(t *testing.T) {
+	img := pamImageFromString(t, pamRawGrayAlpha)
+	opts := EncodeOptions{
+		Format:    PAM,
+		MaxValue:  4095,
+		Plain:     false,
+		TupleType: "GRAYSCALE_ALPHA",
+		Comments:  []string{"Comment 1", "Comment 2"},
+	}
+	var w bytes.Buffer
+	if err := Encode(&w, img, &opts); err != nil {
+		t.Fatal(err)
+	}
+	_, info, err := DecodeWithInfo(&w, &DecodeOptions{Target: PAM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(info, opts) {
+		t.Fatalf("Expected %#v but received %#v", opts, info)
+	}
+}
+
+// TestDecodeWithInfoLayout confirms that irregular header and raster layouts
+// round-trip losslessly and that regular ones are not recorded.
+func TestDecodeWithInfoLayout(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data string
+	}{
+		{"plain PGM", "P2\t# Comment after a tab\r\n5 #Between dimensions\n  2\n#Final comment\n00012\n 0 3 \t 7 8 9\n\n# In the raster\n010 2\t3 4 5  \n# After the raster"},
+		{"plain PBM", "P1 3\n2\n  010\n1 1 1"},
+		{"plain PGM without a final newline", "P2 2 1 255 7 30"},
+		{"raw PPM", "P6\n#\n1   1\n\n255\t\x01\x02\x03"},
+		{"plain PAM", "P7\nWIDTH  2\n# Comment\nHEIGHT 1\nDEPTH 1\nMAXVAL 9\nTUPLTYPE GRAYSCALE\nPLAIN\nENDHDR\n  7\n\n8\n\n"},
+	} {
+		roundTripInfo(t, tc.name, []byte(tc.data))
+	}
+
+	// A file laid out as Encode would lay it out should not record its
+	// layout.
+	var w bytes.Buffer
+	img := NewGrayM(image.Rect(0, 0, 50, 3), 255)
+	if err := Encode(&w, img, &EncodeOptions{Plain: true, Comments: []string{"Hello"}}); err != nil {
+		t.Fatal(err)
+	}
+	_, info, err := DecodeWithInfo(&w, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.HeaderText != "" || info.Layout.Separators != nil {
+		t.Fatalf("Expected no layout but saw %q and %v", info.HeaderText, info.Layout.Separators)
+	}
+}
+
+// TestDecodeWithInfoEdited confirms that edited options discard a recorded
+// header but retain a recorded raster layout.
+func TestDecodeWithInfoEdited(t *testing.T) {
+	data := "P2\n# Old comment\n3   1 9\n 1\n2 3\n"
+	img, info, err := DecodeWithInfo(bytes.NewReader([]byte(data)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.Comments = []string{"New comment"}
+	var w bytes.Buffer
+	if err = Encode(&w, img, &info); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "P2\n# New comment\n3 1\n9\n 1\n2 3\n", w.String(); a != e {
+		t.Fatalf("Expected %q but saw %q", e, a)
+	}
+
+	// Separators that don't match the image should be ignored.
+	w.Reset()
+	info.Layout.Separators = info.Layout.Separators[1:]
+	if err = Encode(&w, img, &info); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "P2\n# New comment\n3 1\n9\n1 2 3\n", w.String(); a != e {
+		t.Fatalf("Expected %q but saw %q", e, a)
+	}
+}
@@ -0,0 +1,197 @@
+// This file provides structured metadata stored in header comments.
+
+package netpbm
+
+import (
+	"strings"
+)
+
+// A MetadataField is a single key/value pair or, if Key is empty, a comment
+// that does not represent a key/value pair.
+type MetadataField struct {
+	Key   string // Key, or "" for an ordinary comment
+	Value string // Value associated with Key, or the text of an ordinary comment
+}
+
+// Metadata represents key/value pairs stored in a Netpbm header as comments
+// of the form "# key: value".  A key is a nonempty sequence of ASCII letters,
+// digits, hyphens, underscores, and periods.  Backslashes, line feeds, and
+// carriage returns in a value are written as "\\", "\n", and "\r",
+// respectively, so a value can contain any text.  Comments not of the
+// key/value form, including those whose value contains a backslash not part
+// of one of those escapes, are preserved in their original position relative
+// to the key/value pairs.  Keys are case-sensitive.
+type Metadata struct {
+	Fields []MetadataField // Key/value pairs and ordinary comments, in header order
+}
+
+// validMetadataKey reports whether a string can be used as a metadata key.
+func validMetadataKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, c := range key {
+		switch {
+		case c >= 'a' && c <= 'z':
+		case c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// escapeMetadataValue escapes backslashes, line feeds, and carriage returns
+// in a metadata value.
+func escapeMetadataValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`).Replace(v)
+}
+
+// unescapeMetadataValue reverses the effect of escapeMetadataValue.  It
+// returns false if v contains a backslash that escapeMetadataValue could not
+// have produced, in which case v is not a metadata value.
+func unescapeMetadataValue(v string) (string, bool) {
+	if !strings.Contains(v, `\`) {
+		return v, true
+	}
+	var sb strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] != '\\' {
+			sb.WriteByte(v[i])
+			continue
+		}
+		if i == len(v)-1 {
+			return "", false
+		}
+		switch v[i+1] {
+		case '\\':
+			sb.WriteByte('\\')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		default:
+			return "", false
+		}
+		i++
+	}
+	return sb.String(), true
+}
+
+// ParseMetadata parses a list of header comments, as returned by
+// DecodeWithComments, into Metadata.
+func ParseMetadata(comments []string) *Metadata {
+	md := &Metadata{Fields: make([]MetadataField, 0, len(comments))}
+	for _, cmt := range comments {
+		kv := strings.SplitN(cmt, ": ", 2)
+		if len(kv) == 2 && validMetadataKey(kv[0]) {
+			if v, ok := unescapeMetadataValue(kv[1]); ok {
+				md.Fields = append(md.Fields, MetadataField{Key: kv[0], Value: v})
+				continue
+			}
+		}
+		md.Fields = append(md.Fields, MetadataField{Value: cmt})
+	}
+	return md
+}
+
+// Comments returns the metadata as a list of header comments, suitable for
+// EncodeOptions.Comments.  Ordinary comments are returned verbatim.  Fields
+// whose key is not valid are omitted.
+func (md *Metadata) Comments() []string {
+	comments := make([]string, 0, len(md.Fields))
+	for _, f := range md.Fields {
+		switch {
+		case f.Key == "":
+			comments = append(comments, f.Value)
+		case validMetadataKey(f.Key):
+			comments = append(comments, f.Key+": "+escapeMetadataValue(f.Value))
+		}
+	}
+	return comments
+}
+
+// mergeComments parses a list of header comments as metadata, sets each of
+// md's key/value pairs in the result, appends md's ordinary comments, and
+// returns the resulting list of comments.
+func (md *Metadata) mergeComments(comments []string) []string {
+	merged := ParseMetadata(comments)
+	for _, f := range md.Fields {
+		if f.Key == "" {
+			merged.Fields = append(merged.Fields, f)
+		} else {
+			merged.Set(f.Key, f.Value)
+		}
+	}
+	return merged.Comments()
+}
+
+// Get returns the value associated with the first occurrence of a key and a
+// success code.
+func (md *Metadata) Get(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	for _, f := range md.Fields {
+		if f.Key == key {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set associates a value with a key.  It replaces the first occurrence of the
+// key and deletes any others or, if the key is not present, appends a new
+// field.  Set returns false and does nothing if key is not a valid metadata
+// key.
+func (md *Metadata) Set(key, value string) bool {
+	if !validMetadataKey(key) {
+		return false
+	}
+	found := false
+	fields := md.Fields[:0]
+	for _, f := range md.Fields {
+		if f.Key == key {
+			if found {
+				continue
+			}
+			f.Value = value
+			found = true
+		}
+		fields = append(fields, f)
+	}
+	md.Fields = fields
+	if !found {
+		md.Fields = append(md.Fields, MetadataField{Key: key, Value: value})
+	}
+	return true
+}
+
+// Delete removes all occurrences of a key.
+func (md *Metadata) Delete(key string) {
+	if key == "" {
+		return
+	}
+	fields := md.Fields[:0]
+	for _, f := range md.Fields {
+		if f.Key != key {
+			fields = append(fields, f)
+		}
+	}
+	md.Fields = fields
+}
+
+// Keys returns the metadata's keys in the order in which they first appear.
+func (md *Metadata) Keys() []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, f := range md.Fields {
+		if f.Key != "" && !seen[f.Key] {
+			keys = append(keys, f.Key)
+			seen[f.Key] = true
+		}
+	}
+	return keys
+}
@@ -0,0 +1,113 @@
+// Test structured metadata stored in header comments.
+
+package netpbm
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// TestMetadataParse confirms that ParseMetadata distinguishes key/value pairs
+// from ordinary comments and that Comments reverses ParseMetadata.
+func TestMetadataParse(t *testing.T) {
+	comments := []string{
+		"Created by a test",
+		"timestamp: 2024-05-01T12:34:56Z",
+		"not a key: because of the space",
+		"device: Camera\\nModel \\\\2\\\\",
+		"exposure:1/60",
+	}
+	md := ParseMetadata(comments)
+	expected := []MetadataField{
+		{Value: "Created by a test"},
+		{Key: "timestamp", Value: "2024-05-01T12:34:56Z"},
+		{Value: "not a key: because of the space"},
+		{Key: "device", Value: "Camera\nModel \\2\\"},
+		{Value: "exposure:1/60"},
+	}
+	if !reflect.DeepEqual(md.Fields, expected) {
+		t.Fatalf("Expected %q but received %q", expected, md.Fields)
+	}
+	if cmts := md.Comments(); !reflect.DeepEqual(cmts, comments) {
+		t.Fatalf("Expected %q but received %q", comments, cmts)
+	}
+}
+
+// TestMetadataBackslashes confirms that comments containing backslashes that
+// are not metadata escapes survive a round trip unmodified.
+func TestMetadataBackslashes(t *testing.T) {
+	comments := []string{
+		`source: C:\scans\bin\img.pgm`,
+		`trailing: backslash\`,
+		`escaped: C:\\scans`,
+	}
+	md := ParseMetadata(comments)
+	if cmts := md.Comments(); !reflect.DeepEqual(cmts, comments) {
+		t.Fatalf("Expected %q but received %q", comments, cmts)
+	}
+	if _, ok := md.Get("source"); ok {
+		t.Fatal("A comment with an invalid escape was parsed as a key/value pair")
+	}
+	if v, ok := md.Get("escaped"); !ok || v != `C:\scans` {
+		t.Fatalf("Expected %q but received %q", `C:\scans`, v)
+	}
+}
+
+// TestMetadataEdit confirms that metadata can be queried and modified.
+func TestMetadataEdit(t *testing.T) {
+	md := ParseMetadata([]string{"a: 1", "Hello", "b: 2", "a: 3"})
+	if v, ok := md.Get("a"); !ok || v != "1" {
+		t.Fatalf("Expected \"1\" but received %q", v)
+	}
+	if keys := md.Keys(); !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Fatalf("Expected keys [a b] but received %q", keys)
+	}
+	md.Set("a", "4")
+	md.Set("c", "5")
+	md.Delete("b")
+	if md.Set("bad key", "6") {
+		t.Fatal("Setting an invalid key unexpectedly succeeded")
+	}
+	expected := []string{"a: 4", "Hello", "c: 5"}
+	if cmts := md.Comments(); !reflect.DeepEqual(cmts, expected) {
+		t.Fatalf("Expected %q but received %q", expected, cmts)
+	}
+}
+
+// TestMetadataEncodeDecode confirms that metadata survives an encode/decode
+// round trip in each Netpbm format.
+func TestMetadataEncodeDecode(t *testing.T) {
+	var md Metadata
+	md.Set("software", "netpbm test")
+	md.Set("notes", "Line 1\r\nLine 2")
+	md.Fields = append(md.Fields, MetadataField{Value: "An ordinary comment"})
+	for _, tc := range []struct {
+		imgStr string
+		eFmt   Format // Format in which to encode the image
+	}{
+		{pbmRaw, PBM},
+		{pgmRaw, PGM},
+		{ppmRaw, PPM},
+		{pamRawColorAlpha, PAM},
+	} {
+		img := pamImageFromString(t, tc.imgStr)
+		var w bytes.Buffer
+		err := Encode(&w, img, &EncodeOptions{
+			Format:   tc.eFmt,
+			Comments: []string{"First"},
+			Metadata: &md,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var dmd Metadata
+		if _, err = Decode(&w, &DecodeOptions{Target: PAM, Metadata: &dmd}); err != nil {
+			t.Fatal(err)
+		}
+		expected := append([]MetadataField{{Value: "First"}}, md.Fields...)
+		if !reflect.DeepEqual(dmd.Fields, expected) {
+			t.Fatalf("%s: Expected %q but received %q", tc.eFmt, expected, dmd.Fields)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// This file provides selection of the smallest lossless encoding of an
+// image.
+
+package netpbm
+
+import (
+	"image"
+	"image/color"
+)
+
+// An imageSummary records the properties of an image that determine its
+// minimal encoding.
+type imageSummary struct {
+	srcMax uint32 // Maximum value of the samples examined
+	maxVal uint32 // Smallest maximum value that represents all samples exactly
+	gray   bool   // true=R, G, and B are equal in every pixel
+	opaque bool   // true=A is srcMax in every pixel
+	bw     bool   // true=every R, G, and B is either 0 or srcMax
+}
+
+// gcd returns the greatest common divisor of two integers.
+func gcd(a, b uint32) uint32 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// newImageSummary returns an imageSummary for samples whose maximum value is
+// m.
+func newImageSummary(m uint32) *imageSummary {
+	return &imageSummary{
+		srcMax: m,
+		maxVal: 1,
+		gray:   true,
+		opaque: true,
+		bw:     true,
+	}
+}
+
+// addSample updates the smallest maximum value to account for a single
+// sample.  A maximum value m represents a sample s exactly if s*m/srcMax is
+// an integer.  The smallest such m is srcMax/gcd(s, srcMax), so the smallest
+// m that represents all samples exactly is the least common multiple of those
+// values.
+func (is *imageSummary) addSample(s uint32) {
+	if (s*is.maxVal)%is.srcMax == 0 {
+		return
+	}
+	d := is.srcMax / gcd(s, is.srcMax)
+	is.maxVal = is.maxVal / gcd(is.maxVal, d) * d
+}
+
+// addPixel updates the summary to account for a single pixel.
+func (is *imageSummary) addPixel(r, g, b, a uint32) {
+	if r != g || g != b {
+		is.gray = false
+	}
+	if a != is.srcMax {
+		is.opaque = false
+	}
+	for _, s := range [...]uint32{r, g, b} {
+		if s != 0 && s != is.srcMax {
+			is.bw = false
+		}
+	}
+	is.addSample(r)
+	is.addSample(g)
+	is.addSample(b)
+	is.addSample(a)
+}
+
+// summarizeNative summarizes a Netpbm image by examining its samples
+// directly.
+func summarizeNative(img Image, nChan int) *imageSummary {
+	is := newImageSummary(uint32(img.MaxValue()))
+	wd := 1
+	if img.MaxValue() >= 256 {
+		wd = 2
+	}
+	pix := pixSlice(img)
+	rect := img.Bounds()
+	var smp [4]uint32
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		i := img.PixOffset(rect.Min.X, y)
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			for c := 0; c < nChan; c++ {
+				if wd == 1 {
+					smp[c] = uint32(pix[i])
+				} else {
+					smp[c] = uint32(pix[i])<<8 | uint32(pix[i+1])
+				}
+				i += wd
+			}
+			switch nChan {
+			case 1:
+				is.addPixel(smp[0], smp[0], smp[0], is.srcMax)
+			case 2:
+				is.addPixel(smp[0], smp[0], smp[0], smp[1])
+			case 3:
+				is.addPixel(smp[0], smp[1], smp[2], is.srcMax)
+			default:
+				is.addPixel(smp[0], smp[1], smp[2], smp[3])
+			}
+		}
+	}
+	return is
+}
+
+// summarizeImage summarizes an arbitrary image by examining its
+// non-alpha-premultiplied 16-bit colors.
+func summarizeImage(img image.Image) *imageSummary {
+	is := newImageSummary(0xffff)
+	rect := img.Bounds()
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			is.addPixel(uint32(c.R), uint32(c.G), uint32(c.B), uint32(c.A))
+		}
+	}
+	return is
+}
+
+// Analyze scans an image and returns the EncodeOptions that represent it
+// losslessly in the fewest bytes.  The Format and TupleType are those of PBM
+// if the image contains only opaque black and white pixels, PGM if the image
+// is opaque and every pixel's red, green, and blue components are equal, PPM
+// if the image is otherwise opaque, or PAM with a GRAYSCALE_ALPHA or
+// RGB_ALPHA tuple type if the image contains translucent pixels.  A SpaceM
+// image is always represented as PAM with its own tuple type and maximum
+// value.  The MaxValue is the smallest maximum value that represents every
+// sample exactly.  For a Netpbm image, "exactly" refers to the image's own
+// samples.  For any other image, it refers to the image's colors as 16-bit,
+// non-alpha-premultiplied values.  The remaining fields of the returned
+// EncodeOptions are left at their zero values.
+func Analyze(img image.Image) EncodeOptions {
+	// Summarize the image, using the original samples where possible.
+	var is *imageSummary
+	switch img := img.(type) {
+	case *BW, *PackedBW:
+		return EncodeOptions{Format: PBM, MaxValue: 1, TupleType: "BLACKANDWHITE"}
+	case *GrayM, *GrayM32:
+		is = summarizeNative(img.(Image), 1)
+	case *GrayAM, *GrayAM48:
+		is = summarizeNative(img.(Image), 2)
+	case *RGBM, *RGBM64:
+		is = summarizeNative(img.(Image), 3)
+	case *RGBAM, *RGBAM64:
+		is = summarizeNative(img.(Image), 4)
+	case *SpaceM:
+		return EncodeOptions{Format: PAM, MaxValue: img.MaxValue(), TupleType: img.Model.TupleType()}
+	default:
+		is = summarizeImage(img)
+	}
+
+	// Select the format and tuple type.
+	opts := EncodeOptions{MaxValue: uint16(is.maxVal)}
+	switch {
+	case is.opaque && is.gray && is.bw:
+		opts.Format = PBM
+		opts.MaxValue = 1
+		opts.TupleType = "BLACKANDWHITE"
+	case is.opaque && is.gray:
+		opts.Format = PGM
+		opts.TupleType = "GRAYSCALE"
+	case is.opaque:
+		opts.Format = PPM
+		opts.TupleType = "RGB"
+	case is.gray:
+		opts.Format = PAM
+		opts.TupleType = "GRAYSCALE_ALPHA"
+	default:
+		opts.Format = PAM
+		opts.TupleType = "RGB_ALPHA"
+	}
+	return opts
+}
@@ -0,0 +1,104 @@
+// Test selection of the smallest lossless encoding.
+
+package netpbm
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/spakin/netpbm/npcolor"
+)
+
+// minimizeAndCompare encodes an image with Minimize set, confirms that the
+// result has the expected format and maximum value, and confirms that the
+// decoded image's colors match the original image's colors exactly.
+func minimizeAndCompare(t *testing.T, img image.Image, eFmt Format, eMax uint16) {
+	// Analyze the image.
+	opts := Analyze(img)
+	if opts.Format != eFmt || opts.MaxValue != eMax {
+		t.Fatalf("Expected %s with maximum value %d but Analyze chose %s with maximum value %d",
+			eFmt, eMax, opts.Format, opts.MaxValue)
+	}
+
+	// Encode the image, and ensure it decodes to the expected format.
+	var w bytes.Buffer
+	if err := Encode(&w, img, &EncodeOptions{Minimize: true}); err != nil {
+		t.Fatal(err)
+	}
+	img2, info, err := DecodeWithInfo(&w, &DecodeOptions{Target: PAM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Format != eFmt || (eFmt != PBM && info.MaxValue != eMax) {
+		t.Fatalf("Expected %s with maximum value %d but Encode wrote %s with maximum value %d",
+			eFmt, eMax, info.Format, info.MaxValue)
+	}
+
+	// Compare the two images' colors.
+	rect := img.Bounds()
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c1 := color.NRGBA64Model.Convert(img.At(x, y))
+			c2 := color.NRGBA64Model.Convert(img2.At(x-rect.Min.X, y-rect.Min.Y))
+			if c1 != c2 {
+				t.Fatalf("Expected %v at (%d, %d) but saw %v", c1, x, y, c2)
+			}
+		}
+	}
+}
+
+// TestMinimizeFormat confirms that Analyze selects the simplest format that
+// represents an image.
+func TestMinimizeFormat(t *testing.T) {
+	// A PPM image containing only black and white should become PBM.
+	bw := imageFromString(t, pbmRaw, PBM).(*BW)
+	minimizeAndCompare(t, bw.PromoteToGrayM(255).PromoteToRGBM(), PBM, 1)
+
+	// A PPM image containing only grays should become PGM.
+	gray := imageFromString(t, pgmRaw, PGM).(*GrayM)
+	minimizeAndCompare(t, gray.PromoteToRGBM(), PGM, 255)
+
+	// A PAM image with an opaque alpha channel should become PPM.
+	rgb := imageFromString(t, ppmRaw, PPM).(*RGBM)
+	rgba := NewRGBAM(rgb.Bounds(), 255)
+	copyPixels(rgba, rgb)
+	minimizeAndCompare(t, rgba, PPM, 255)
+
+	// A PAM image with a translucent alpha channel should remain PAM.
+	minimizeAndCompare(t, pamImageFromString(t, pamRawColorAlpha), PAM, 255)
+	minimizeAndCompare(t, pamImageFromString(t, pamRawGrayAlpha), PAM, 255)
+}
+
+// TestMinimizeMaxValue confirms that Analyze selects the smallest maximum
+// value that represents every sample exactly.
+func TestMinimizeMaxValue(t *testing.T) {
+	// Samples that are multiples of 85/255 need a maximum value of 3.
+	gray := NewGrayM(image.Rect(0, 0, 4, 1), 255)
+	for x := 0; x < 4; x++ {
+		gray.SetGrayM(x, 0, npcolor.GrayM{Y: uint8(x * 85), M: 255})
+	}
+	minimizeAndCompare(t, gray, PGM, 3)
+
+	// Adding a sample of 51/255 requires a maximum value of lcm(3, 5).
+	gray.SetGrayM(1, 0, npcolor.GrayM{Y: 51, M: 255})
+	minimizeAndCompare(t, gray, PGM, 15)
+
+	// Samples of 0/1000, 250/1000, and 1000/1000 need a maximum value of
+	// 4.
+	rgb := NewRGBM64(image.Rect(0, 0, 2, 1), 1000)
+	rgb.SetRGBM64(0, 0, npcolor.RGBM64{R: 0, G: 250, B: 1000, M: 1000})
+	rgb.SetRGBM64(1, 0, npcolor.RGBM64{R: 1000, G: 0, B: 250, M: 1000})
+	minimizeAndCompare(t, rgb, PPM, 4)
+
+	// A non-Netpbm image is analyzed in terms of 16-bit colors.
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	nrgba.SetNRGBA(0, 0, color.NRGBA{R: 255, G: 0, B: 0, A: 255})
+	nrgba.SetNRGBA(1, 0, color.NRGBA{R: 0, G: 51, B: 0, A: 255})
+	nrgba.SetNRGBA(0, 1, color.NRGBA{R: 0, G: 0, B: 255, A: 255})
+	nrgba.SetNRGBA(1, 1, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	minimizeAndCompare(t, nrgba, PPM, 5)
+	nrgba.SetNRGBA(1, 1, color.NRGBA{R: 255, G: 255, B: 255, A: 85})
+	minimizeAndCompare(t, nrgba, PAM, 15)
+}
@@ -0,0 +1,100 @@
+// This file provides zero-copy access to raw Netpbm files by mapping them
+// into memory.
+
+//go:build linux
+// +build linux
+
+package netpbm
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"syscall"
+)
+
+// A MappedImage is an Image whose pixel data alias a memory-mapped Netpbm
+// file.  The embedded Image is a *GrayM, *GrayM32, *GrayAM, *GrayAM48, *RGBM,
+// *RGBM64, *RGBAM, or *RGBAM64, depending on the file's format and maximum
+// value.  The image must not be accessed after Close is called.
+type MappedImage struct {
+	Image        // Image whose Pix field aliases the mapping
+	data  []byte // The entire memory-mapped file
+}
+
+// Mmap maps a raw (binary) PGM, PPM, or PAM file read-only into memory and
+// returns an image whose Pix field aliases the mapped raster data.  Writing
+// to the image's pixels causes a segmentation fault; use MmapWritable to
+// modify the file in place.  Plain (ASCII) files and PBM files cannot be
+// mapped because their layout in the file differs from their layout in
+// memory.
+func Mmap(path string) (*MappedImage, error) {
+	return mmapFile(path, false)
+}
+
+// MmapWritable is like Mmap but maps the file read-write.  Changes made to
+// the image's pixels are written back to the file.
+func MmapWritable(path string) (*MappedImage, error) {
+	return mmapFile(path, true)
+}
+
+// mmapFile is a helper function for Mmap and MmapWritable that maps a file
+// either read-only or read-write.
+func mmapFile(path string, writable bool) (*MappedImage, error) {
+	// Open the file.
+	flags := os.O_RDONLY
+	prot := syscall.PROT_READ
+	if writable {
+		flags = os.O_RDWR
+		prot |= syscall.PROT_WRITE
+	}
+	f, err := os.OpenFile(path, flags, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // The mapping outlives the file descriptor.
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+	if size == 0 {
+		return nil, errors.New("Cannot map an empty file")
+	}
+	if int64(int(size)) != size {
+		return nil, errors.New("File is too large to map into memory")
+	}
+
+	// Map the entire file into memory.
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), prot, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, &os.PathError{Op: "mmap", Path: path, Err: err}
+	}
+
+	// Parse the header and wrap the raster in an image.
+	nr := newNetpbmReader(bufio.NewReader(bytes.NewReader(data)))
+	header, err := nr.GetHeader()
+	if err != nil {
+		syscall.Munmap(data)
+		return nil, err
+	}
+	img, err := rawImageFromHeader(header, data[header.RasterOffset:])
+	if err != nil {
+		syscall.Munmap(data)
+		return nil, err
+	}
+	return &MappedImage{Image: img, data: data}, nil
+}
+
+// Close unmaps the file.  Neither the image nor any slice of its pixel data
+// may be used after Close returns.
+func (mi *MappedImage) Close() error {
+	if mi.data == nil {
+		return errors.New("Image is not mapped")
+	}
+	err := syscall.Munmap(mi.data)
+	mi.data = nil
+	mi.Image = nil
+	return err
+}
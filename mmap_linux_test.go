@@ -0,0 +1,93 @@
+// Test memory-mapped Netpbm files.
+
+//go:build linux
+// +build linux
+
+package netpbm
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/spakin/netpbm/npcolor"
+)
+
+// writeDecompressed decompresses a test image to a temporary file whose name
+// ends in suffix and returns the file's name.  The caller is responsible for
+// removing the file.
+func writeDecompressed(t *testing.T, imgStr, suffix string) string {
+	r := flate.NewReader(bytes.NewBufferString(imgStr))
+	defer r.Close()
+	f, err := ioutil.TempFile("", "netpbm-*"+suffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = io.Copy(f, r); err != nil {
+		os.Remove(f.Name())
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+// TestMmapPPM confirms that a memory-mapped PPM file contains the same pixels
+// as a decoded PPM file.
+func TestMmapPPM(t *testing.T) {
+	img := imageFromString(t, ppmRaw, PPM)
+	fname := writeDecompressed(t, ppmRaw, ".ppm")
+	defer os.Remove(fname)
+	mimg, err := Mmap(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mimg.Close()
+	if _, ok := mimg.Image.(*RGBM); !ok {
+		t.Fatalf("Expected an *RGBM but received a %T", mimg.Image)
+	}
+	compareImageMetadata(t, img.(Image), mimg.Image)
+	if !bytes.Equal(img.(*RGBM).Pix, mimg.Image.(*RGBM).Pix) {
+		t.Fatal("Memory-mapped pixels differ from decoded pixels")
+	}
+}
+
+// TestMmapWritable confirms that modifying a writable memory-mapped image
+// modifies the underlying file.
+func TestMmapWritable(t *testing.T) {
+	fname := writeDecompressed(t, pgmRaw, ".pgm")
+	defer os.Remove(fname)
+	mimg, err := MmapWritable(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray := mimg.Image.(*GrayM)
+	gray.SetGrayM(3, 5, npcolor.GrayM{Y: 123, M: 255})
+	if err = mimg.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := Decode(f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if y := img.(*GrayM).GrayMAt(3, 5).Y; y != 123 {
+		t.Fatalf("Expected 123 but read %d", y)
+	}
+}
+
+// TestMmapPBM confirms that mapping a PBM file is rejected.
+func TestMmapPBM(t *testing.T) {
+	fname := writeDecompressed(t, pbmRaw, ".pbm")
+	defer os.Remove(fname)
+	if mimg, err := Mmap(fname); err == nil {
+		mimg.Close()
+		t.Fatal("Mapping a PBM file unexpectedly succeeded")
+	}
+}
@@ -3,7 +3,9 @@ Package netpbm implements image decoders and encoders for the Netpbm image
 formats: PBM (black and white), PGM (grayscale), PPM (color), and PAM (black
 and white, grayscale, or color, as indicated by the image header).  Both "raw"
 (binary) and "plain" (ASCII) files can be read and written.  Both 8-bit and
-16-bit color channels are supported.
+16-bit color channels are supported.  The package additionally supports the
+Portable Float Map (PFM) format, whose grayscale or color channels are 32-bit
+floating-point numbers.
 
 The netpbm package is fully compatible with the image package in the standard
 library but additionally reproduces the Netpbm library's ability to promote
@@ -18,6 +20,7 @@ package netpbm
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"image"
@@ -32,8 +35,10 @@ import (
 // A netpbmReader extends bufio.Reader with the ability to read bytes
 // and numbers while skipping over comments.
 type netpbmReader struct {
-	*bufio.Reader       // Inherit Read, UnreadByte, etc.
-	err           error // Sticky error state
+	*bufio.Reader               // Inherit Read, UnreadByte, etc.
+	err           error         // Sticky error state
+	consumed      int64         // Number of bytes consumed by GetNextByteAsRune, GetLineAsKeyValue, and GetNextInt
+	record        *bytes.Buffer // If non-nil, receives a copy of each byte counted by consumed
 }
 
 // newNetpbmReader allocates, initializes, and returns a new netpbmReader.
@@ -57,6 +62,10 @@ func (nr *netpbmReader) GetNextByteAsRune() rune {
 	if nr.err != nil {
 		return 0
 	}
+	nr.consumed++
+	if nr.record != nil {
+		nr.record.WriteByte(b)
+	}
 	return rune(b)
 }
 
@@ -69,19 +78,30 @@ func (nr *netpbmReader) GetLineAsKeyValue() []string {
 	}
 	var s string
 	s, nr.err = nr.ReadString('\n')
+	nr.consumed += int64(len(s))
+	if nr.record != nil {
+		nr.record.WriteString(s)
+	}
 	if nr.err != nil {
 		return nil
 	}
 
 	// Split the string into a key and a value.  As a special case "#"
-	// counts as a key, and everything following it is a comment.
+	// counts as a key, and everything following it is a comment.  As in
+	// GetIntsAndComments, comments discard up to one whitespace character
+	// following the "#" and the final carriage return and/or line feed.
+	s = strings.TrimLeftFunc(s, unicode.IsSpace)
+	if strings.HasPrefix(s, "#") {
+		cmt := strings.TrimRight(s[1:], "\r\n")
+		if cmt != "" && unicode.IsSpace(rune(cmt[0])) {
+			cmt = cmt[1:]
+		}
+		return []string{"#", cmt}
+	}
 	s = strings.TrimSpace(s)
 	if s == "" {
 		return nil
 	}
-	if s[0] == '#' {
-		return []string{"#", strings.TrimSpace(s[1:])}
-	}
 	fs := strings.SplitN(s, " ", 2)
 	if len(fs) == 1 {
 		return []string{fs[0], ""}
@@ -119,6 +139,10 @@ func (nr *netpbmReader) GetNextInt() int {
 	if nr.err != nil {
 		return -1
 	}
+	nr.consumed--
+	if nr.record != nil {
+		nr.record.Truncate(nr.record.Len() - 1)
+	}
 	return value
 }
 
@@ -235,15 +259,20 @@ func (nr *netpbmReader) GetASCIIData(maxVal int, data []uint8) bool {
 	return true
 }
 
-// A netpbmHeader encapsulates the components of an image header.
-type netpbmHeader struct {
-	Magic     string   // Two-character magic value (e.g., "P6" for PPM)
-	Width     int      // Image width in pixels
-	Height    int      // Image height in pixels
-	Depth     int      // Image pixel depth in bytes
-	Maxval    int      // Maximum channel value (0-65535)
-	TupleType string   // Image tuple type ("RGB_ALPHA", etc.)
-	Comments  []string // Aggregated list of comment lines
+// A Header encapsulates the components of an image header.  Depth and
+// TupleType are meaningful only for PAM images, although DecodeHeader also
+// sets Depth for PBM, PGM, and PPM images.  Plain and RasterOffset are set by
+// DecodeHeader and ignored by EncodeHeader.
+type Header struct {
+	Magic        string   // Two-character magic value (e.g., "P6" for PPM)
+	Width        int      // Image width in pixels
+	Height       int      // Image height in pixels
+	Depth        int      // Number of samples per pixel
+	Maxval       int      // Maximum channel value (1-65535)
+	TupleType    string   // Image tuple type ("RGB_ALPHA", etc.)
+	Comments     []string // Aggregated list of comment lines
+	Plain        bool     // true="plain" (ASCII) raster; false="raw" (binary) raster
+	RasterOffset int64    // Number of bytes preceding the raster
 }
 
 // getMagic is a helper function for GetNetpbmHeader that returns a Netpbm
@@ -266,15 +295,15 @@ func (nr *netpbmReader) getMagic(min, max rune) (string, bool) {
 }
 
 // GetNetpbmHeader parses the entire header (PBM, PGM, or PPM; raw or
-// plain) and returns it as a netpbmHeader (plus a success value).
-func (nr *netpbmReader) GetNetpbmHeader() (netpbmHeader, bool) {
-	var header netpbmHeader
+// plain) and returns it as a Header (plus a success value).
+func (nr *netpbmReader) GetNetpbmHeader() (Header, bool) {
+	var header Header
 
 	// Read the magic value and skip the following whitespace.
 	var ok bool
 	header.Magic, ok = nr.getMagic('1', '6')
 	if !ok {
-		return netpbmHeader{}, false
+		return Header{}, false
 	}
 
 	// PBM files (raw or plain) don't specify a maximum channel.  All other
@@ -282,9 +311,10 @@ func (nr *netpbmReader) GetNetpbmHeader() (netpbmHeader, bool) {
 	switch header.Magic {
 	case "P1", "P4":
 		header.Maxval = 1
+		header.Depth = 1
 		nums, comments, err := nr.GetIntsAndComments(2)
 		if err != nil {
-			return netpbmHeader{}, false
+			return Header{}, false
 		}
 		header.Width = nums[0]
 		header.Height = nums[1]
@@ -293,21 +323,186 @@ func (nr *netpbmReader) GetNetpbmHeader() (netpbmHeader, bool) {
 	default:
 		nums, comments, err := nr.GetIntsAndComments(3)
 		if err != nil {
-			return netpbmHeader{}, false
+			return Header{}, false
 		}
 		header.Width = nums[0]
 		header.Height = nums[1]
 		header.Maxval = nums[2]
 		header.Comments = comments
+		header.Depth = 1
+		if header.Magic == "P3" || header.Magic == "P6" {
+			header.Depth = 3
+		}
 	}
+	header.Plain = header.Magic[1] <= '3'
 	if nr.Err() != nil || header.Maxval < 1 || header.Maxval > 65535 {
-		return netpbmHeader{}, false
+		return Header{}, false
 	}
 
 	// Return the header and a success code.
 	return header, true
 }
 
+// GetHeader parses the header of any supported Netpbm format (PBM, PGM, PPM,
+// or PAM; raw or plain) and returns it as a Header.  The header's
+// RasterOffset is the number of bytes GetHeader consumed.
+func (nr *netpbmReader) GetHeader() (Header, error) {
+	// Peek at the magic number to determine the header syntax.
+	magic, err := nr.Peek(2)
+	if err != nil {
+		return Header{}, err
+	}
+	if magic[0] != 'P' {
+		return Header{}, errors.New("Not a Netpbm image")
+	}
+	var header Header
+	var ok bool
+	start := nr.consumed
+	switch magic[1] {
+	case '1', '2', '3', '4', '5', '6':
+		header, ok = nr.GetNetpbmHeader()
+	case '7':
+		header, ok = nr.GetPamHeader()
+	default:
+		return Header{}, fmt.Errorf("Unrecognized magic sequence %q", string(magic))
+	}
+	if !ok {
+		err = nr.Err()
+		if err == nil {
+			err = errors.New("Invalid Netpbm header")
+		}
+		return Header{}, err
+	}
+	header.RasterOffset = nr.consumed - start
+	return header, nil
+}
+
+// rawImageFromHeader returns an Image whose Pix field aliases data, which must
+// begin with a raw (binary) PGM, PPM, or PAM raster described by header.  PBM
+// rasters are not supported because they pack 8 pixels into each byte.
+func rawImageFromHeader(header Header, data []uint8) (Image, error) {
+	if header.Plain {
+		return nil, errors.New("Plain (ASCII) pixel data can't be accessed directly")
+	}
+	if header.Width < 0 || header.Height < 0 {
+		return nil, errors.New("Invalid image dimensions")
+	}
+	r := image.Rect(0, 0, header.Width, header.Height)
+	return imageFromHeader(header, r, data)
+}
+
+// headerTupleType maps a header's magic value and, for PAM images, tuple type
+// to a pamTupleType.
+func headerTupleType(header Header) (pamTupleType, error) {
+	switch header.Magic {
+	case "P1", "P4":
+		return pamBlackAndWhite, nil
+	case "P2", "P5":
+		return pamGrayscale, nil
+	case "P3", "P6":
+		return pamColor, nil
+	case "P7":
+		ttype, ok := ttToInt[header.TupleType]
+		if !ok {
+			return 0, fmt.Errorf("Unsupported tuple type %q", header.TupleType)
+		}
+		return pamStorageType(ttype), nil
+	default:
+		return 0, fmt.Errorf("Unrecognized magic sequence %q", header.Magic)
+	}
+}
+
+// imageFromModel returns a new, zeroed Image with bounds r whose pixels use
+// color model m, which must be an integer model.
+func imageFromModel(r image.Rectangle, m npcolor.Model) Image {
+	header := Header{
+		Magic:     "P7",
+		Depth:     m.Channels(),
+		Maxval:    int(m.MaxValue()),
+		TupleType: m.TupleType(),
+	}
+	img, err := imageFromHeader(header, r, nil)
+	if err != nil {
+		panic(err) // The header is always valid.
+	}
+	return img
+}
+
+// imageFromHeader returns an Image with bounds r whose pixel format is
+// described by header.  The Image's Pix field aliases data, which must be laid
+// out as in a raw (binary) PGM, PPM, or PAM raster of width r.Dx().  If data
+// is nil, imageFromHeader allocates a new, zeroed Pix slice.  As a special
+// case, a PBM header with nil data produces a *BW.
+func imageFromHeader(header Header, r image.Rectangle, data []uint8) (Image, error) {
+	ttype, err := headerTupleType(header)
+	if err != nil {
+		return nil, err
+	}
+
+	// Determine the number of bytes per pixel.
+	var bpp int
+	switch ttype {
+	case pamBlackAndWhite:
+		if data == nil {
+			return NewBW(r), nil
+		}
+		return nil, errors.New("Black-and-white pixel data can't be accessed directly")
+	case pamGrayscale:
+		bpp = 1
+	case pamGrayscaleAlpha:
+		bpp = 2
+	case pamColor:
+		bpp = 3
+	case pamColorAlpha:
+		bpp = 4
+	case pamColorSpace:
+		bpp = 3
+	default:
+		return nil, fmt.Errorf("Pixel data for tuple type %q can't be accessed directly", header.TupleType)
+	}
+	if header.Magic == "P7" && header.Depth != bpp {
+		return nil, fmt.Errorf("Tuple type %q requires a depth of %d, not %d", header.TupleType, bpp, header.Depth)
+	}
+	if header.Maxval >= 256 {
+		bpp *= 2
+	}
+
+	// Ensure the data are large enough to hold the entire raster.
+	size := int64(r.Dx()) * int64(r.Dy()) * int64(bpp)
+	if data == nil {
+		data = make([]uint8, size)
+	}
+	if size > int64(len(data)) {
+		return nil, fmt.Errorf("Raster requires %d bytes but only %d are available", size, len(data))
+	}
+	pix := data[:size:size]
+
+	// Wrap the raster in an image of the appropriate type.
+	stride := bpp * r.Dx()
+	m := header.Maxval
+	switch {
+	case ttype == pamColorSpace:
+		s, _ := npcolor.SpaceFromTupleType(header.TupleType)
+		return &SpaceM{pix, stride, r, npcolor.SpaceMModel{Space: s, M: uint16(m)}}, nil
+	case ttype == pamGrayscale && m < 256:
+		return &GrayM{pix, stride, r, npcolor.GrayMModel{M: uint8(m)}}, nil
+	case ttype == pamGrayscale:
+		return &GrayM32{pix, stride, r, npcolor.GrayM32Model{M: uint16(m)}}, nil
+	case ttype == pamGrayscaleAlpha && m < 256:
+		return &GrayAM{pix, stride, r, npcolor.GrayAMModel{M: uint8(m)}}, nil
+	case ttype == pamGrayscaleAlpha:
+		return &GrayAM48{pix, stride, r, npcolor.GrayAM48Model{M: uint16(m)}}, nil
+	case ttype == pamColor && m < 256:
+		return &RGBM{pix, stride, r, npcolor.RGBMModel{M: uint8(m)}}, nil
+	case ttype == pamColor:
+		return &RGBM64{pix, stride, r, npcolor.RGBM64Model{M: uint16(m)}}, nil
+	case ttype == pamColorAlpha && m < 256:
+		return &RGBAM{pix, stride, r, npcolor.RGBAMModel{M: uint8(m)}}, nil
+	default:
+		return &RGBAM64{pix, stride, r, npcolor.RGBAM64Model{M: uint16(m)}}, nil
+	}
+}
+
 // An Image extends image.Image to include a few extra methods.
 type Image interface {
 	image.Image                             // At, Bounds, and ColorModel
@@ -330,6 +525,7 @@ const (
 	PGM               // Portable Gray Map (grayscale)
 	PPM               // Portable Pixel Map (color)
 	PAM               // Portable Arbitrary Map (B&W, grayscale, or color with optional alpha)
+	PFM               // Portable Float Map (floating-point grayscale or color)
 )
 
 // String outputs the name of a Netpbm format.
@@ -345,6 +541,8 @@ func (f Format) String() string {
 		return "PPM"
 	case PAM:
 		return "PAM"
+	case PFM:
+		return "PFM"
 	default:
 		return fmt.Sprintf("%%!s(netpbm.Format=%d)", f)
 	}
@@ -352,9 +550,18 @@ func (f Format) String() string {
 
 // DecodeOptions represents a list of options for decoding a Netpbm file.
 type DecodeOptions struct {
-	Target      Format // Netpbm format to return
-	Exact       bool   // true=allow only Target; false=promote lesser formats
-	PBMMaxValue uint16 // Maximum channel value to use when promoting a PBM image (0=default)
+	Target        Format           // Netpbm format to return
+	Exact         bool             // true=allow only Target; false=promote lesser formats
+	PBMMaxValue   uint16           // Maximum channel value to use when promoting a PBM image (0=default)
+	Scale         int              // Factor by which to reduce the image's width and height (0 or 1=full size; not supported for PFM)
+	Metadata      *Metadata        // If non-nil, receives the metadata parsed from the header comments
+	AllowPlainPAM bool             // true=accept nonstandard "plain" (ASCII) PAM files; false=reject them
+	Background    color.Color      // If non-nil, color over which to composite translucent pixels when Target is PNM
+	Blend         BlendSpace       // Color space in which to composite over Background and to average pixels when Scale > 1
+	FloatMaxValue uint16           // Maximum channel value to use when converting a PFM image to another format (0=65535)
+	ToneMap       ToneMap          // Mapping of PFM samples to integers when converting a PFM image to another format
+	Transfer      npcolor.Transfer // Transfer function of the file's samples (nil=linear for PFM, BT.709 otherwise)
+	ImageTransfer npcolor.Transfer // Transfer function of the returned image's samples (nil=same as Transfer)
 }
 
 // DecodeConfigWithComments returns image metadata without decoding the entire
@@ -389,6 +596,10 @@ func DecodeConfigWithComments(r io.Reader) (image.Config, []string, error) {
 	case '7':
 		// PAM
 		return decodeConfigPAMWithComments(rr)
+	case 'F', 'f':
+		// PFM, which does not support comments
+		cfg, err := decodeConfigPFM(rr)
+		return cfg, nil, err
 	default:
 		// None of the above
 		return image.Config{}, nil, fmt.Errorf("Unrecognized magic sequence %q", string(magic))
@@ -402,6 +613,107 @@ func DecodeConfig(r io.Reader) (image.Config, error) {
 	return cfg, err
 }
 
+// DecodeHeader reads and parses the header of a Netpbm image of any format,
+// raw or plain.  Unlike DecodeConfig, it returns the header in its entirety,
+// including the number of bytes it occupies.  Pass in a bufio.Reader if you
+// intend to read data following the image header.
+func DecodeHeader(r io.Reader) (Header, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return newNetpbmReader(br).GetHeader()
+}
+
+// EncodeHeader writes a Netpbm header.  The header's Magic field determines
+// its syntax: PBM headers omit Maxval, and only PAM headers include Depth and
+// TupleType.  A PAM header with Plain set includes the nonstandard PLAIN
+// keyword (see EncodeOptions.AllowPlainPAM).  Comments are written
+// immediately after the magic value, one per line, with carriage returns and
+// line feeds replaced by spaces.
+func EncodeHeader(w io.Writer, h Header) error {
+	// Validate the header.
+	if len(h.Magic) != 2 || h.Magic[0] != 'P' || h.Magic[1] < '1' || h.Magic[1] > '7' {
+		return fmt.Errorf("Invalid magic value %q", h.Magic)
+	}
+	if h.Width < 0 || h.Height < 0 {
+		return fmt.Errorf("Invalid image dimensions %dx%d", h.Width, h.Height)
+	}
+	isPBM := h.Magic == "P1" || h.Magic == "P4"
+	if !isPBM && (h.Maxval < 1 || h.Maxval > 65535) {
+		return fmt.Errorf("Invalid maximum value %d", h.Maxval)
+	}
+
+	// Format the header into a buffer.
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, h.Magic)
+	for _, cmt := range h.Comments {
+		cmt = strings.Replace(cmt, "\n", " ", -1)
+		cmt = strings.Replace(cmt, "\r", " ", -1)
+		fmt.Fprintf(&buf, "# %s\n", cmt)
+	}
+	switch {
+	case h.Magic == "P7":
+		fmt.Fprintf(&buf, "WIDTH %d\n", h.Width)
+		fmt.Fprintf(&buf, "HEIGHT %d\n", h.Height)
+		fmt.Fprintf(&buf, "DEPTH %d\n", h.Depth)
+		fmt.Fprintf(&buf, "MAXVAL %d\n", h.Maxval)
+		if h.TupleType != "" {
+			fmt.Fprintf(&buf, "TUPLTYPE %s\n", h.TupleType)
+		}
+		if h.Plain {
+			fmt.Fprintf(&buf, "PLAIN\n")
+		}
+		fmt.Fprintf(&buf, "ENDHDR\n")
+	case isPBM:
+		fmt.Fprintf(&buf, "%d %d\n", h.Width, h.Height)
+	default:
+		fmt.Fprintf(&buf, "%d %d\n", h.Width, h.Height)
+		fmt.Fprintf(&buf, "%d\n", h.Maxval)
+	}
+
+	// Write the buffer.
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeHeader writes a header on behalf of Encode.  If opts.HeaderText is
+// exactly one header that parses to the same values as h, it is written
+// verbatim.  Otherwise, the header is formatted by EncodeHeader.
+func writeHeader(w io.Writer, h Header, opts *EncodeOptions) error {
+	if opts.HeaderText != "" && headerTextMatches(opts.HeaderText, h) {
+		_, err := io.WriteString(w, opts.HeaderText)
+		return err
+	}
+	return EncodeHeader(w, h)
+}
+
+// headerTextMatches reports whether text consists of exactly one header that
+// parses to the same values EncodeHeader would write for h.
+func headerTextMatches(text string, h Header) bool {
+	p, err := DecodeHeader(strings.NewReader(text))
+	switch {
+	case err != nil:
+		return false
+	case p.RasterOffset != int64(len(text)):
+		return false
+	case p.Magic != h.Magic || p.Width != h.Width || p.Height != h.Height:
+		return false
+	case p.Magic != "P1" && p.Magic != "P4" && p.Maxval != h.Maxval:
+		return false
+	case p.Magic == "P7" && (p.Depth != h.Depth || p.TupleType != h.TupleType || p.Plain != h.Plain):
+		return false
+	case len(p.Comments) != len(h.Comments):
+		return false
+	}
+	for i, cmt := range p.Comments {
+		if cmt != h.Comments[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // DecodeWithComments reads a Netpbm image from r and returns it as an Image.
 // Unlike Decode, it also returns any comments appearing in the file.  Pass in
 // a bufio.Reader if you intend to read data following the image.
@@ -420,17 +732,22 @@ func DecodeWithComments(r io.Reader, opts *DecodeOptions) (Image, []string, erro
 	}
 
 	// Provide default options.
-	var o DecodeOptions
-	if opts != nil {
-		o = *opts
-	}
-	if o.PBMMaxValue == 0 {
-		o.PBMMaxValue = 255
+	o, err := defaultDecodeOptions(opts)
+	if err != nil {
+		return nil, nil, err
 	}
-	if o.Exact && o.Target == PNM {
-		// PNM isn't its own format so it doesn't make sense to try to
-		// read exactly a PNM file.
-		return nil, nil, errors.New("Exact=true is incompatible with Target=PNM")
+
+	// Decode reduced-resolution images one band of rows at a time.
+	if o.Scale > 1 {
+		if magic[1] == 'F' || magic[1] == 'f' {
+			return nil, nil, errors.New("Scale is not supported for PFM images")
+		}
+		img, comments, err := decodeScaledWithComments(rr, &o)
+		if err != nil {
+			return nil, nil, err
+		}
+		o.storeMetadata(comments)
+		return img, comments, nil
 	}
 
 	// Invoke the decode function corresponding to the magic number.
@@ -475,13 +792,19 @@ func DecodeWithComments(r io.Reader, opts *DecodeOptions) (Image, []string, erro
 		img, comments, err = decodePPMWithComments(rr)
 	case '7':
 		// PAM
-		img, comments, err = decodePAMWithComments(rr)
+		img, comments, err = decodePAMWithComments(rr, o.AllowPlainPAM)
 		if err != nil {
 			return nil, nil, err
 		}
 		if o.Exact && img.(Image).Format() != o.Target {
 			return nil, nil, fmt.Errorf("%s-flavored PAM rejected by Decode options", img.(Image).Format())
 		}
+	case 'F', 'f':
+		// PFM
+		if o.Exact && o.Target != PFM {
+			return nil, nil, errors.New("PFM rejected by Decode options")
+		}
+		img, err = decodePFMImage(rr)
 	default:
 		// None of the above
 		return nil, nil, fmt.Errorf("Unrecognized magic sequence %q", string(magic))
@@ -490,30 +813,117 @@ func DecodeWithComments(r io.Reader, opts *DecodeOptions) (Image, []string, erro
 		return nil, nil, err
 	}
 
+	nimg, err := convertToTarget(img.(Image), &o)
+	if err != nil {
+		return nil, nil, err
+	}
+	o.storeMetadata(comments)
+	return nimg, comments, nil
+}
+
+// storeMetadata parses a list of header comments into the Metadata pointed to
+// by the decode options, if any.
+func (o *DecodeOptions) storeMetadata(comments []string) {
+	if o.Metadata != nil {
+		*o.Metadata = *ParseMetadata(comments)
+	}
+}
+
+// checkPlainPAM returns an error if a header describes a nonstandard plain
+// PAM file but plain PAM files are not allowed.
+func checkPlainPAM(header Header, allow bool) error {
+	if header.Magic == "P7" && header.Plain && !allow {
+		return errors.New("Plain PAM is not a standard Netpbm format; set AllowPlainPAM to read it anyway")
+	}
+	return nil
+}
+
+// defaultDecodeOptions returns a copy of opts, or a new set of DecodeOptions
+// if opts is nil, with default values filled in.
+func defaultDecodeOptions(opts *DecodeOptions) (DecodeOptions, error) {
+	var o DecodeOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.PBMMaxValue == 0 {
+		o.PBMMaxValue = 255
+	}
+	if o.Exact && o.Target == PNM {
+		// PNM isn't its own format so it doesn't make sense to try to
+		// read exactly a PNM file.
+		return o, errors.New("Exact=true is incompatible with Target=PNM")
+	}
+	if o.Scale < 0 {
+		return o, fmt.Errorf("Invalid scale factor %d", o.Scale)
+	}
+	return o, nil
+}
+
+// convertToTarget converts a decoded image to the format and transfer
+// function requested by a set of decode options.
+func convertToTarget(nimg Image, o *DecodeOptions) (Image, error) {
+	tf := defaultTransfer(o.Transfer, nimg.Format())
+	nimg, err := convertFormat(nimg, o, tf)
+	if err != nil {
+		return nil, err
+	}
+	if o.ImageTransfer != nil && !sameTransfer(o.ImageTransfer, tf) {
+		nimg = ConvertTransfer(nimg, tf, o.ImageTransfer)
+	}
+	return nimg, nil
+}
+
+// convertFormat converts a decoded image, whose samples are encoded with
+// transfer function tf, to the format requested by a set of decode options,
+// promoting the image or removing its alpha channel as necessary.
+func convertFormat(nimg Image, o *DecodeOptions, tf npcolor.Transfer) (Image, error) {
+	// Reject mismatched formats when mismatches are forbidden.
+	if o.Exact && nimg.Format() != o.Target {
+		return nil, fmt.Errorf("%s rejected by Decode options", nimg.Format())
+	}
+
 	// A PAM target accepts any image type as is.
-	nimg := img.(Image)
 	if o.Target == PAM {
-		return nimg, comments, nil
+		return nimg, nil
+	}
+
+	// A PFM target requires floating-point samples.
+	if o.Target == PFM {
+		if _, ok := nimg.(floatImage); ok {
+			return nimg, nil
+		}
+		return ToFloat(nimg), nil
+	}
+
+	// Other targets require integer samples.
+	if fimg, ok := nimg.(floatImage); ok && o.Target != PNM {
+		mVal := o.FloatMaxValue
+		if mVal == 0 {
+			mVal = 0xffff
+		}
+		nimg = fimg.Quantize(mVal, o.ToneMap)
 	}
 
 	// A PNM target accepts any images as is, except that it discards the
-	// alpha channel.
+	// alpha channel or composites the image over a background.
 	if o.Target == PNM {
 		if !nimg.HasAlpha() {
-			return nimg, comments, nil
+			return nimg, nil
+		}
+		if o.Background != nil {
+			return flatten(nimg, o.Background, o.Blend, tf), nil
 		}
 		var ok bool
 		nimg, ok = RemoveAlpha(nimg)
 		if ok {
-			return nimg, comments, nil
+			return nimg, nil
 		}
-		return nil, nil, errors.New("Failed to remove the alpha channel")
+		return nil, errors.New("Failed to remove the alpha channel")
 	}
 
-	// If requested, promote the image to a richer format.  We've already
-	// rejected the case of a mismatch when mismatches are forbidden.
+	// If requested, promote the image to a richer format.
 	if nimg.Format() > o.Target {
-		return nil, nil, fmt.Errorf("Cannot demote a %s image to a %s image", nimg.Format(), o.Target)
+		return nil, fmt.Errorf("Cannot demote a %s image to a %s image", nimg.Format(), o.Target)
 	}
 	for nimg.Format() < o.Target {
 		switch nimg.Format() {
@@ -534,7 +944,7 @@ func DecodeWithComments(r io.Reader, opts *DecodeOptions) (Image, []string, erro
 			panic("Attempted to promote a format other than PBM or PGM")
 		}
 	}
-	return nimg, comments, nil
+	return nimg, nil
 }
 
 // Decode reads a Netpbm image from r and returns it as an Image.  a
@@ -546,15 +956,34 @@ func Decode(r io.Reader, opts *DecodeOptions) (Image, error) {
 
 // EncodeOptions represents a list of options for writing a Netpbm file.
 type EncodeOptions struct {
-	Format    Format   // Netpbm format
-	MaxValue  uint16   // Maximum value for each color channel (ignored for PBM)
-	Plain     bool     // true="plain" (ASCII); false="raw" (binary)
-	TupleType string   // Image tuple type for a PAM image (RGB_ALPHA, etc.)
-	Comments  []string // Header comments, with no leading "#" or trailing newlines
+	Format        Format           // Netpbm format
+	MaxValue      uint16           // Maximum value for each color channel (ignored for PBM)
+	Plain         bool             // true="plain" (ASCII); false="raw" (binary)
+	TupleType     string           // Image tuple type for a PAM image (RGB_ALPHA, etc.)
+	Comments      []string         // Header comments, with no leading "#" or trailing newlines
+	Metadata      *Metadata        // Metadata to write as header comments following Comments
+	AllowPlainPAM bool             // true=allow nonstandard "plain" (ASCII) PAM files; false=reject Plain with PAM
+	Minimize      bool             // true=replace Format, MaxValue, and TupleType with those chosen by Analyze
+	Concurrency   int              // Number of goroutines that convert rows in parallel (0 or 1=one; negative=GOMAXPROCS)
+	Background    color.Color      // If non-nil, color over which to composite translucent pixels when writing a format without alpha
+	Blend         BlendSpace       // Color space in which to composite over Background
+	Layout        PlainLayout      // Arrangement of samples in plain output
+	ToneMap       ToneMap          // Mapping of floating-point samples to integers when writing a PFM-format image to another format
+	PFMScale      float32          // Scale factor to write to a PFM header, by which samples are divided (0=1)
+	PFMBigEndian  bool             // true=big-endian PFM samples; false=little-endian PFM samples
+	Transfer      npcolor.Transfer // Transfer function of the file's samples (nil=linear for PFM, BT.709 otherwise)
+	ImageTransfer npcolor.Transfer // Transfer function of img's samples (nil=same as Transfer)
+	Luma          npcolor.Luma     // Formula for converting color to grayscale when writing a format without color
+	HeaderText    string           // Exact header to write if it describes the same header Encode would otherwise write (see DecodeWithInfo)
 }
 
 // inferTupleType maps a color model to a tuple-type string.
 func inferTupleType(m color.Model) string {
+	// Images in a non-RGB color space know their tuple type.
+	if sm, ok := m.(npcolor.SpaceMModel); ok {
+		return sm.TupleType()
+	}
+
 	// Convert a dummy color to the given model and from that to
 	// red, green, blue, and alpha values.
 	c := m.Convert(dummyColor{})
@@ -596,6 +1025,22 @@ func tupleTypeToFormat(tt string) Format {
 // if not.  Given an opts.MaxValue of 0, use the image's MaxValue if img is a
 // Netpbm image or 255 if not.  Given a nil opts, assign Format as if it were
 // PNM and MaxValue as if it were 0.
+//
+// PAM does not define a plain (ASCII) encoding, so Encode rejects a Format of
+// PAM with Plain set unless AllowPlainPAM is also set.  In that case, Encode
+// adds a nonstandard PLAIN line to the PAM header.  Standard PAM decoders
+// reject such files instead of misinterpreting their rasters, and this
+// package's decoders accept them only if DecodeOptions.AllowPlainPAM is set.
+//
+// Formats without an alpha channel cannot represent translucent pixels.  By
+// default, Encode writes such pixels as though composited over black.  If
+// opts.Background is non-nil, Encode instead composites them over
+// opts.Background, as does Flatten.
+//
+// If opts.ImageTransfer differs from opts.Transfer, Encode converts img's
+// color channels from the former transfer function to the latter before
+// writing them, as does ConvertTransfer.  Compositing over opts.Background in
+// linear light uses opts.Transfer.
 func Encode(w io.Writer, img image.Image, opts *EncodeOptions) error {
 	// Start by copying opts if provided or initializing a new set of
 	// EncodeOptions if not.
@@ -604,6 +1049,17 @@ func Encode(w io.Writer, img image.Image, opts *EncodeOptions) error {
 		o = *opts
 	}
 
+	// If requested, select the smallest lossless encoding.
+	if o.Minimize {
+		a := Analyze(img)
+		o.Format, o.MaxValue, o.TupleType = a.Format, a.MaxValue, a.TupleType
+	}
+
+	// Append any metadata to the list of comments.
+	if o.Metadata != nil {
+		o.Comments = append(append([]string(nil), o.Comments...), o.Metadata.Comments()...)
+	}
+
 	// If TupleType is not specified, infer it from the image type.
 	if o.TupleType == "" {
 		o.TupleType = inferTupleType(img.ColorModel())
@@ -631,6 +1087,29 @@ func Encode(w io.Writer, img image.Image, opts *EncodeOptions) error {
 		}
 	}
 
+	// Re-encode the image's samples with the file's transfer function.
+	tf := defaultTransfer(o.Transfer, o.Format)
+	if o.ImageTransfer != nil && !sameTransfer(o.ImageTransfer, tf) {
+		img = ConvertTransfer(img, o.ImageTransfer, tf)
+	}
+
+	// Convert floating-point samples to integers if the output requires
+	// integers.
+	if fimg, ok := img.(floatImage); ok && o.Format != PFM {
+		img = fimg.Quantize(o.MaxValue, o.ToneMap)
+	}
+
+	// Composite translucent pixels over the background if the output
+	// has no alpha channel.
+	if o.Background != nil && (o.Format != PAM || !strings.HasSuffix(o.TupleType, "_ALPHA")) {
+		img = newFlattenedImage(img, o.Background, o.Blend, tf)
+	}
+
+	// Plain PAM is not a standard format, so write it only on request.
+	if o.Format == PAM && o.Plain && !o.AllowPlainPAM {
+		return errors.New("Plain PAM is not a standard Netpbm format; set AllowPlainPAM to write it anyway")
+	}
+
 	// Encode the image using the specified format and options.
 	switch o.Format {
 	case PPM:
@@ -641,73 +1120,70 @@ func Encode(w io.Writer, img image.Image, opts *EncodeOptions) error {
 		return encodePBM(w, img, &o)
 	case PAM:
 		return encodePAM(w, img, &o)
+	case PFM:
+		return encodePFM(w, img, &o)
 	default:
 		return fmt.Errorf("Invalid Netpbm format specified (%s)", o.Format)
 	}
 }
 
-// writePlainData writes numbers read from a channel as base-10 strings, at
-// most 70 characters per line.
-func writePlainData(w io.Writer, ch chan uint16) error {
-	var line string
-	for s := range ch {
-		word := fmt.Sprintf("%d ", s)
-		if len(line)+len(word) <= 70 {
-			line += word
-		} else {
-			lineBytes := []byte(line)
-			lineBytes[len(lineBytes)-1] = '\n'
-			_, err := w.Write(lineBytes)
-			if err != nil {
-				return err
-			}
-			line = word
-		}
+// A rowSampler stores the samples representing row y of an image into row.
+// A rowSampler must be safe to invoke concurrently on different rows.
+type rowSampler func(y int, row []uint16)
 
-	}
-	if line != "" {
-		lineBytes := []byte(line)
-		lineBytes[len(lineBytes)-1] = '\n'
-		_, err := w.Write(lineBytes)
-		if err != nil {
-			return err
+// writeRaster writes an image's raster, using a rowSampler to convert each
+// row of the image to samples.  depth is the number of samples per pixel,
+// and bits indicates that raw samples are packed 8 per byte (PBM).  Rows are
+// converted in parallel if opts.Concurrency calls for it.  Standard-library
+// images whose pixels writeRaster can read directly bypass sample.
+func writeRaster(w io.Writer, img image.Image, opts *EncodeOptions, depth int, bits bool, sample rowSampler) error {
+	// Prepare to write the raster.  Samples in a non-RGB color space
+	// must always be converted by sample.
+	if _, space := npcolor.SpaceFromTupleType(opts.TupleType); !space {
+		if fast := stdRowSampler(img, depth, bits, opts.MaxValue, opts.Luma); fast != nil {
+			sample = fast
 		}
 	}
-	return nil
-}
-
-// writeRawData writes numbers read from a channel as bytes, either uint8s (if
-// wd = 1) or uint16s (if wd = 2).
-func writeRawData(w io.Writer, ch chan uint16, wd int) error {
-	var err error
+	rect := img.Bounds()
+	header := Header{
+		Magic:  "P7",
+		Width:  rect.Dx(),
+		Height: rect.Dy(),
+		Depth:  depth,
+		Maxval: int(opts.MaxValue),
+		Plain:  opts.Plain,
+	}
+	switch {
+	case bits && opts.Plain:
+		header.Magic = "P1"
+	case bits:
+		header.Magic = "P4"
+	}
 	wb, ok := w.(*bufio.Writer)
 	if !ok {
 		wb = bufio.NewWriter(w)
 	}
-	switch wd {
-	case 1:
-		for s := range ch {
-			err = wb.WriteByte(uint8(s))
-			if err != nil {
-				return err
-			}
-		}
-	case 2:
-		for s := range ch {
-			err = wb.WriteByte(uint8(s >> 8))
-			if err != nil {
-				return err
-			}
-			err = wb.WriteByte(uint8(s))
-			if err != nil {
-				return err
-			}
+	rw := newRasterWriter(wb, header, opts.Layout)
+
+	// Convert and write each row in turn, either serially or in parallel.
+	// Images with no columns or only one row gain nothing from parallelism.
+	var err error
+	if workers := opts.workers(); workers > 1 && header.Height > 1 && header.Width > 0 {
+		err = writeRasterParallel(rw, header, rect.Min.Y, sample, workers)
+	} else {
+		row := make([]uint16, header.Width*depth)
+		for y := rect.Min.Y; y < rect.Max.Y && err == nil; y++ {
+			sample(y, row)
+			err = rw.WriteRow(row)
 		}
-	default:
-		panic("writeRawData was given an invalid byte width")
 	}
-	wb.Flush()
-	return nil
+	if err != nil {
+		return err
+	}
+	if err = rw.Close(); err != nil {
+		return err
+	}
+	return wb.Flush()
 }
 
 // RemoveAlpha removes the alpha channel from a Netpbm image.  It returns a new
@@ -715,61 +1191,87 @@ func writeRawData(w io.Writer, ch chan uint16, wd int) error {
 // channel, this is considered failure.
 func RemoveAlpha(img Image) (Image, bool) {
 	// Allocate a new image.
-	if !img.HasAlpha() {
+	model, ok := img.ColorModel().(npcolor.Model)
+	if !ok || !model.HasAlpha() {
 		return nil, false
 	}
-	var nimg Image
-	r := img.Bounds()
-	switch img.ColorModel().(type) {
-	case npcolor.RGBAMModel:
-		nimg = NewRGBM(r, uint8(img.MaxValue()))
-	case npcolor.RGBAM64Model:
-		nimg = NewRGBM64(r, img.MaxValue())
-	case npcolor.GrayAMModel:
-		nimg = NewGrayM(r, uint8(img.MaxValue()))
-	case npcolor.GrayAM48Model:
-		nimg = NewGrayM32(r, img.MaxValue())
-	default:
-		panic(fmt.Sprintf("Removing the alpha channel from a %s image is not yet implemented", img.Format()))
-	}
+	tt := strings.TrimSuffix(model.TupleType(), "_ALPHA")
+	nimg := imageFromModel(img.Bounds(), npcolor.NewModel(tt, model.MaxValue()))
 
 	// Copy the old image to the new pixel-by-pixel.
-	ul := r.Min
-	lr := r.Max
-	for j := ul.Y; j <= lr.Y; j++ {
-		for i := ul.X; i <= lr.X; i++ {
-			nimg.Set(i, j, img.At(i, j))
-		}
-	}
+	copyPixels(nimg, img)
 	return nimg, true
 }
 
+// Demote converts a color Netpbm image to grayscale, computing each gray
+// level from the red, green, and blue samples using luma formula l.  It
+// returns a new image and a success code.  If the input image is not a color
+// image, this is considered failure.  Demote preserves the image's maximum
+// value and alpha channel, if any.
+func Demote(img Image, l npcolor.Luma) (Image, bool) {
+	switch img := img.(type) {
+	case *RGBM:
+		return img.DemoteToGrayM(l), true
+	case *RGBM64:
+		return img.DemoteToGrayM32(l), true
+	case *RGBAM:
+		return img.DemoteToGrayAM(l), true
+	case *RGBAM64:
+		return img.DemoteToGrayAM48(l), true
+	default:
+		return nil, false
+	}
+}
+
 // AddAlpha adds an alpha channel to a Netpbm image.  It returns a new image
-// and a success code.  If the input image already has an alpha channel, this
-// is considered failure.
+// and a success code.  If the input image already has an alpha channel or is
+// not a grayscale or color image with integer samples, this is considered
+// failure.
 func AddAlpha(img Image) (Image, bool) {
 	// Allocate a new image.
-	if img.HasAlpha() {
+	model, ok := img.ColorModel().(npcolor.Model)
+	if !ok || model.HasAlpha() || model.BytesPerSample() > 2 {
 		return nil, false
 	}
-	var nimg Image
-	r := img.Bounds()
-	switch img.ColorModel().(type) {
-	case npcolor.RGBMModel:
-		nimg = NewRGBAM(r, uint8(img.MaxValue()))
-	case npcolor.RGBM64Model:
-		nimg = NewRGBAM64(r, img.MaxValue())
-	default:
-		panic(fmt.Sprintf("Adding an alpha channel to a %s image is not yet implemented", img.Format()))
+	amodel := npcolor.NewModel(model.TupleType()+"_ALPHA", model.MaxValue())
+	if amodel == nil {
+		return nil, false
 	}
+	nimg := imageFromModel(img.Bounds(), amodel)
 
 	// Copy the old image to the new pixel-by-pixel.
-	ul := r.Min
-	lr := r.Max
-	for j := ul.Y; j <= lr.Y; j++ {
-		for i := ul.X; i <= lr.X; i++ {
-			nimg.Set(i, j, img.At(i, j))
+	copyPixels(nimg, img)
+	return nimg, true
+}
+
+// copyPixels copies every pixel of one image to another.
+func copyPixels(dst Image, src image.Image) {
+	r := src.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			dst.Set(x, y, src.At(x, y))
 		}
 	}
-	return nimg, true
+}
+
+// scaleRGBA64 scales a channel value from [0, 0xffff] to [0, m], rounding
+// exactly as the npcolor models' Convert methods do.
+func scaleRGBA64(v, m uint32) uint32 {
+	return (v*m + 0xffff/2) / 0xffff
+}
+
+// unpremultiply converts an alpha-premultiplied color.RGBA64 to
+// non-alpha-premultiplied R, G, B, and A, exactly as color.NRGBA64Model does.
+func unpremultiply(c color.RGBA64) (r, g, b, a uint32) {
+	r, g, b, a = uint32(c.R), uint32(c.G), uint32(c.B), uint32(c.A)
+	switch a {
+	case 0xffff:
+		return
+	case 0:
+		return 0, 0, 0, 0
+	}
+	r = (r * 0xffff) / a
+	g = (g * 0xffff) / a
+	b = (b * 0xffff) / a
+	return
 }
@@ -288,3 +288,89 @@ func removeCompareAlpha(t *testing.T, imgStrA, imgStrNA string) {
 		}
 	}
 }
+
+// TestDecodeHeader confirms that DecodeHeader parses a header correctly and
+// that EncodeHeader reproduces it exactly.
+func TestDecodeHeader(t *testing.T) {
+	// Encode an image and decode its header.
+	img := imageFromString(t, pgmPlain, PGM)
+	var w bytes.Buffer
+	err := Encode(&w, img, &EncodeOptions{
+		Plain:    true,
+		Comments: []string{"First comment", "Second comment"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := w.Bytes()
+	header, err := DecodeHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Magic != "P2" || header.Width != 63 || header.Height != 65 ||
+		header.Maxval != 777 || header.Depth != 1 || !header.Plain {
+		t.Fatalf("Incorrectly parsed header %#v", header)
+	}
+	if len(header.Comments) != 2 || header.Comments[1] != "Second comment" {
+		t.Fatalf("Incorrectly parsed comments %q", header.Comments)
+	}
+
+	// Re-encode the header, and ensure it matches the original.
+	var hdr bytes.Buffer
+	if err = EncodeHeader(&hdr, header); err != nil {
+		t.Fatal(err)
+	}
+	if int64(hdr.Len()) != header.RasterOffset {
+		t.Fatalf("Expected a %d-byte header but wrote %d bytes", header.RasterOffset, hdr.Len())
+	}
+	if !bytes.Equal(hdr.Bytes(), data[:header.RasterOffset]) {
+		t.Fatalf("Expected header %q but wrote %q", data[:header.RasterOffset], hdr.Bytes())
+	}
+}
+
+// TestDecodeHeaderPAM confirms that DecodeHeader parses a PAM header
+// correctly.
+func TestDecodeHeaderPAM(t *testing.T) {
+	r := flate.NewReader(bytes.NewBufferString(pamRawColorAlpha))
+	defer r.Close()
+	br := bufio.NewReader(r)
+	header, err := DecodeHeader(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Magic != "P7" || header.Depth != 4 || header.TupleType != "RGB_ALPHA" || header.Plain {
+		t.Fatalf("Incorrectly parsed header %#v", header)
+	}
+
+	// Ensure the reader is positioned at the start of the raster.
+	n, err := br.Discard(1 << 20)
+	if err != nil && n != header.Width*header.Height*header.Depth {
+		t.Fatalf("Expected %d bytes of raster data but found %d",
+			header.Width*header.Height*header.Depth, n)
+	}
+}
+
+// TestSubImageModel confirms that a subimage, even an empty one, retains its
+// parent's color model.
+func TestSubImageModel(t *testing.T) {
+	r := image.Rect(0, 0, 4, 3)
+	for _, img := range []Image{
+		NewGrayM(r, 100),
+		NewGrayM32(r, 1000),
+		NewRGBM(r, 100),
+		NewRGBM64(r, 1000),
+		NewGrayAM(r, 100),
+		NewGrayAM48(r, 1000),
+		NewRGBAM(r, 100),
+		NewRGBAM64(r, 1000),
+	} {
+		sub := img.(interface {
+			SubImage(image.Rectangle) image.Image
+		})
+		for _, sr := range []image.Rectangle{image.Rect(1, 1, 3, 2), image.Rect(5, 5, 6, 6)} {
+			if cm := sub.SubImage(sr).ColorModel(); cm != img.ColorModel() {
+				t.Fatalf("%T: expected model %v for %v but saw %v", img, img.ColorModel(), sr, cm)
+			}
+		}
+	}
+}
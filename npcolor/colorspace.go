@@ -0,0 +1,563 @@
+// This file provides conversions between RGB and other color spaces.
+
+package npcolor
+
+import (
+	"image/color"
+	"math"
+)
+
+// rgbFloats returns the non-alpha-premultiplied red, green, and blue
+// channels of a color as fractions of their maximum values.
+func rgbFloats(c color.Color) (r, g, b float64) {
+	switch c.(type) {
+	case GrayM, GrayM32, RGBM, RGBM64:
+		return floats(c)
+	}
+	ri, gi, bi, _ := nrgba64(c)
+	return float64(ri) / 0xffff, float64(gi) / 0xffff, float64(bi) / 0xffff
+}
+
+// rgbaFromFloats converts red, green, and blue fractions to alpha-premultiplied
+// 16-bit R, G, B, and A, clamping each channel to [0.0, 1.0].
+func rgbaFromFloats(r, g, b float64) (uint32, uint32, uint32, uint32) {
+	return clamp16f(r), clamp16f(g), clamp16f(b), 0xffff
+}
+
+// clamp16f maps a float64 in [0.0, 1.0] to a uint32 in [0, 0xffff], clamping
+// out-of-range values.
+func clamp16f(v float64) uint32 {
+	switch {
+	case v >= 1:
+		return 0xffff
+	case v > 0:
+		return uint32(v*0xffff + 0.5)
+	default:
+		return 0
+	}
+}
+
+// HSV represents a color as hue, in degrees from 0 up to 360, and saturation
+// and value, each from 0.0 to 1.0.
+type HSV struct {
+	H, S, V float64
+}
+
+// RGBA converts an HSV to alpha-premultiplied R, G, B, and A.
+func (c HSV) RGBA() (r, g, b, a uint32) {
+	return rgbaFromFloats(hsvToRGB(c.H, c.S, c.V))
+}
+
+// HSVModel converts any color to an HSV.
+var HSVModel = color.ModelFunc(func(c color.Color) color.Color {
+	if _, ok := c.(HSV); ok {
+		return c
+	}
+	h, s, v := rgbToHSV(rgbFloats(c))
+	return HSV{H: h, S: s, V: v}
+})
+
+// hue returns the hue, in degrees, of a color with the given channels,
+// maximum channel, and chroma.
+func hue(r, g, b, max, chroma float64) float64 {
+	var h float64
+	switch {
+	case chroma == 0:
+		return 0
+	case max == r:
+		h = (g - b) / chroma
+	case max == g:
+		h = (b-r)/chroma + 2
+	default:
+		h = (r-g)/chroma + 4
+	}
+	h *= 60
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+// rgbFromHue returns the channels of a color with the given hue, chroma, and
+// amount m to add to each channel.
+func rgbFromHue(h, chroma, m float64) (r, g, b float64) {
+	h = math.Mod(h, 360)
+	if h < 0 {
+		h += 360
+	}
+	hp := h / 60
+	x := chroma * (1 - math.Abs(math.Mod(hp, 2)-1))
+	switch int(hp) {
+	case 0:
+		r, g, b = chroma, x, 0
+	case 1:
+		r, g, b = x, chroma, 0
+	case 2:
+		r, g, b = 0, chroma, x
+	case 3:
+		r, g, b = 0, x, chroma
+	case 4:
+		r, g, b = x, 0, chroma
+	default:
+		r, g, b = chroma, 0, x
+	}
+	return r + m, g + m, b + m
+}
+
+// rgbToHSV converts RGB fractions to HSV.
+func rgbToHSV(r, g, b float64) (h, s, v float64) {
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	chroma := max - min
+	if max > 0 {
+		s = chroma / max
+	}
+	return hue(r, g, b, max, chroma), s, max
+}
+
+// hsvToRGB converts HSV to RGB fractions.
+func hsvToRGB(h, s, v float64) (r, g, b float64) {
+	chroma := v * s
+	return rgbFromHue(h, chroma, v-chroma)
+}
+
+// HSL represents a color as hue, in degrees from 0 up to 360, and saturation
+// and lightness, each from 0.0 to 1.0.
+type HSL struct {
+	H, S, L float64
+}
+
+// RGBA converts an HSL to alpha-premultiplied R, G, B, and A.
+func (c HSL) RGBA() (r, g, b, a uint32) {
+	return rgbaFromFloats(hslToRGB(c.H, c.S, c.L))
+}
+
+// HSLModel converts any color to an HSL.
+var HSLModel = color.ModelFunc(func(c color.Color) color.Color {
+	if _, ok := c.(HSL); ok {
+		return c
+	}
+	h, s, l := rgbToHSL(rgbFloats(c))
+	return HSL{H: h, S: s, L: l}
+})
+
+// rgbToHSL converts RGB fractions to HSL.
+func rgbToHSL(r, g, b float64) (h, s, l float64) {
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	chroma := max - min
+	l = (max + min) / 2
+	if chroma > 0 {
+		s = chroma / (1 - math.Abs(2*l-1))
+	}
+	return hue(r, g, b, max, chroma), s, l
+}
+
+// hslToRGB converts HSL to RGB fractions.
+func hslToRGB(h, s, l float64) (r, g, b float64) {
+	chroma := (1 - math.Abs(2*l-1)) * s
+	return rgbFromHue(h, chroma, l-chroma/2)
+}
+
+// A YCbCrStandard specifies the luma coefficients that relate YCbCr to RGB.
+type YCbCrStandard int
+
+// These are the supported YCbCr standards.
+const (
+	YCbCrBT601 YCbCrStandard = iota // ITU-R BT.601 (Kr=0.299, Kb=0.114)
+	YCbCrBT709                      // ITU-R BT.709 (Kr=0.2126, Kb=0.0722)
+)
+
+// coefficients returns the red and blue luma coefficients of a YCbCr
+// standard.
+func (std YCbCrStandard) coefficients() (kr, kb float64) {
+	if std == YCbCrBT709 {
+		return 0.2126, 0.0722
+	}
+	return 0.299, 0.114
+}
+
+// YCbCr represents a color as luma, from 0.0 to 1.0, and blue-difference and
+// red-difference chroma, each from -0.5 to 0.5, computed from gamma-encoded
+// RGB channels using the coefficients of a given standard.
+type YCbCr struct {
+	Y, Cb, Cr float64
+	Std       YCbCrStandard
+}
+
+// RGBA converts a YCbCr to alpha-premultiplied R, G, B, and A.
+func (c YCbCr) RGBA() (r, g, b, a uint32) {
+	return rgbaFromFloats(ycbcrToRGB(c.Std, c.Y, c.Cb, c.Cr))
+}
+
+// YCbCrModel returns a model that converts any color to a YCbCr with the
+// given standard.
+func YCbCrModel(std YCbCrStandard) color.Model {
+	return color.ModelFunc(func(c color.Color) color.Color {
+		if ycc, ok := c.(YCbCr); ok && ycc.Std == std {
+			return c
+		}
+		r, g, b := rgbFloats(c)
+		y, cb, cr := rgbToYCbCr(std, r, g, b)
+		return YCbCr{Y: y, Cb: cb, Cr: cr, Std: std}
+	})
+}
+
+// rgbToYCbCr converts RGB fractions to YCbCr.
+func rgbToYCbCr(std YCbCrStandard, r, g, b float64) (y, cb, cr float64) {
+	kr, kb := std.coefficients()
+	y = kr*r + (1-kr-kb)*g + kb*b
+	cb = (b - y) / (2 * (1 - kb))
+	cr = (r - y) / (2 * (1 - kr))
+	return
+}
+
+// ycbcrToRGB converts YCbCr to RGB fractions.
+func ycbcrToRGB(std YCbCrStandard, y, cb, cr float64) (r, g, b float64) {
+	kr, kb := std.coefficients()
+	r = y + 2*(1-kr)*cr
+	b = y + 2*(1-kb)*cb
+	g = (y - kr*r - kb*b) / (1 - kr - kb)
+	return
+}
+
+// These are the CIE XYZ coordinates of the D65 white point.
+const (
+	whiteX = 0.95047
+	whiteY = 1.0
+	whiteZ = 1.08883
+)
+
+// XYZ represents a color in the CIE 1931 XYZ color space, with Y=1.0 for
+// D65 white.  Conversions assume that RGB channels are encoded with the sRGB
+// transfer function and sRGB primaries.
+type XYZ struct {
+	X, Y, Z float64
+}
+
+// RGBA converts an XYZ to alpha-premultiplied R, G, B, and A.
+func (c XYZ) RGBA() (r, g, b, a uint32) {
+	return rgbaFromFloats(xyzToRGB(c.X, c.Y, c.Z))
+}
+
+// XYZModel converts any color to an XYZ.
+var XYZModel = color.ModelFunc(func(c color.Color) color.Color {
+	if _, ok := c.(XYZ); ok {
+		return c
+	}
+	x, y, z := rgbToXYZ(rgbFloats(c))
+	return XYZ{X: x, Y: y, Z: z}
+})
+
+// rgbToXYZ converts sRGB fractions to XYZ.
+func rgbToXYZ(r, g, b float64) (x, y, z float64) {
+	r, g, b = SRGB.ToLinear(r), SRGB.ToLinear(g), SRGB.ToLinear(b)
+	x = 0.4124564*r + 0.3575761*g + 0.1804375*b
+	y = 0.2126729*r + 0.7151522*g + 0.0721750*b
+	z = 0.0193339*r + 0.1191920*g + 0.9503041*b
+	return
+}
+
+// xyzToRGB converts XYZ to sRGB fractions.
+func xyzToRGB(x, y, z float64) (r, g, b float64) {
+	r = 3.2404542*x - 1.5371385*y - 0.4985314*z
+	g = -0.9692660*x + 1.8760108*y + 0.0415560*z
+	b = 0.0556434*x - 0.2040259*y + 1.0572252*z
+	return SRGB.FromLinear(r), SRGB.FromLinear(g), SRGB.FromLinear(b)
+}
+
+// Lab represents a color in the CIE 1976 L*a*b* color space relative to the
+// D65 white point, with lightness L from 0 to 100 and chroma coordinates A
+// and B nominally from -128 to 127.  Conversions assume that RGB channels are
+// encoded with the sRGB transfer function and sRGB primaries.
+type Lab struct {
+	L, A, B float64
+}
+
+// RGBA converts a Lab to alpha-premultiplied R, G, B, and A.
+func (c Lab) RGBA() (r, g, b, a uint32) {
+	return rgbaFromFloats(xyzToRGB(labToXYZ(c.L, c.A, c.B)))
+}
+
+// LabModel converts any color to a Lab.
+var LabModel = color.ModelFunc(func(c color.Color) color.Color {
+	if _, ok := c.(Lab); ok {
+		return c
+	}
+	l, a, b := xyzToLab(rgbToXYZ(rgbFloats(c)))
+	return Lab{L: l, A: a, B: b}
+})
+
+// labDelta is the threshold at which the L*a*b* companding function switches
+// from linear to cubic.
+const labDelta = 6.0 / 29.0
+
+// labF is the L*a*b* companding function.
+func labF(t float64) float64 {
+	if t > labDelta*labDelta*labDelta {
+		return math.Cbrt(t)
+	}
+	return t/(3*labDelta*labDelta) + 4.0/29.0
+}
+
+// labFInv is the inverse of labF.
+func labFInv(t float64) float64 {
+	if t > labDelta {
+		return t * t * t
+	}
+	return 3 * labDelta * labDelta * (t - 4.0/29.0)
+}
+
+// xyzToLab converts XYZ to L*a*b*.
+func xyzToLab(x, y, z float64) (l, a, b float64) {
+	fx, fy, fz := labF(x/whiteX), labF(y/whiteY), labF(z/whiteZ)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// labToXYZ converts L*a*b* to XYZ.
+func labToXYZ(l, a, b float64) (x, y, z float64) {
+	fy := (l + 16) / 116
+	fx := fy + a/500
+	fz := fy - b/200
+	return whiteX * labFInv(fx), whiteY * labFInv(fy), whiteZ * labFInv(fz)
+}
+
+// A Space is a color space into which RGB colors can be converted and whose
+// coordinates can be stored as Netpbm samples.
+type Space int
+
+// These are the supported color spaces.  Each stores three samples per
+// pixel, scaled to a maximum value m as described.
+const (
+	// SpaceHSV stores H/360, S, and V, each scaled to m.
+	SpaceHSV Space = iota
+
+	// SpaceHSL stores H/360, S, and L, each scaled to m.
+	SpaceHSL
+
+	// SpaceYCbCr601 stores full-range BT.601 YCbCr, as used by JPEG:
+	// Y, Cb, and Cr, each scaled to m, with (m+1)/2 added to Cb and Cr.
+	SpaceYCbCr601
+
+	// SpaceYCbCr601Limited stores limited-range BT.601 YCbCr, as used by
+	// digital video: 16+219*Y, 128+224*Cb, and 128+224*Cr, each
+	// multiplied by (m+1)/256.
+	SpaceYCbCr601Limited
+
+	// SpaceYCbCr709 stores full-range BT.709 YCbCr, as with
+	// SpaceYCbCr601.
+	SpaceYCbCr709
+
+	// SpaceYCbCr709Limited stores limited-range BT.709 YCbCr, as with
+	// SpaceYCbCr601Limited.
+	SpaceYCbCr709Limited
+
+	// SpaceXYZ stores X, Y, and Z relative to the D65 white point,
+	// X/0.95047, Y, and Z/1.08883, each scaled to m.
+	SpaceXYZ
+
+	// SpaceLab stores D65 L*a*b*: L/100, (A+128)/255, and (B+128)/255,
+	// each scaled to m.
+	SpaceLab
+)
+
+// spaceTupleTypes maps each color space to its PAM tuple type.
+var spaceTupleTypes = [...]string{
+	SpaceHSV:             "HSV",
+	SpaceHSL:             "HSL",
+	SpaceYCbCr601:        "YCbCr",
+	SpaceYCbCr601Limited: "YCbCr_LIMITED",
+	SpaceYCbCr709:        "YCbCr_709",
+	SpaceYCbCr709Limited: "YCbCr_709_LIMITED",
+	SpaceXYZ:             "XYZ",
+	SpaceLab:             "LAB",
+}
+
+// TupleType returns the PAM tuple type used to store colors in a color
+// space.
+func (s Space) TupleType() string {
+	if s < 0 || int(s) >= len(spaceTupleTypes) {
+		return ""
+	}
+	return spaceTupleTypes[s]
+}
+
+// SpaceFromTupleType returns the color space corresponding to a PAM tuple
+// type and a success code.
+func SpaceFromTupleType(tt string) (Space, bool) {
+	for s, t := range spaceTupleTypes {
+		if t == tt {
+			return Space(s), true
+		}
+	}
+	return 0, false
+}
+
+// ycbcr returns the standard of a YCbCr color space and whether the space
+// is limited-range.
+func (s Space) ycbcr() (std YCbCrStandard, limited bool) {
+	switch s {
+	case SpaceYCbCr601Limited:
+		return YCbCrBT601, true
+	case SpaceYCbCr709:
+		return YCbCrBT709, false
+	case SpaceYCbCr709Limited:
+		return YCbCrBT709, true
+	default:
+		return YCbCrBT601, false
+	}
+}
+
+// fromRGB converts RGB fractions to a color space's coordinates.
+func (s Space) fromRGB(r, g, b float64) (v [3]float64) {
+	switch s {
+	case SpaceHSV:
+		v[0], v[1], v[2] = rgbToHSV(r, g, b)
+	case SpaceHSL:
+		v[0], v[1], v[2] = rgbToHSL(r, g, b)
+	case SpaceXYZ:
+		v[0], v[1], v[2] = rgbToXYZ(r, g, b)
+	case SpaceLab:
+		v[0], v[1], v[2] = xyzToLab(rgbToXYZ(r, g, b))
+	default:
+		std, _ := s.ycbcr()
+		v[0], v[1], v[2] = rgbToYCbCr(std, r, g, b)
+	}
+	return
+}
+
+// toRGB converts a color space's coordinates to RGB fractions.
+func (s Space) toRGB(v [3]float64) (r, g, b float64) {
+	switch s {
+	case SpaceHSV:
+		return hsvToRGB(v[0], v[1], v[2])
+	case SpaceHSL:
+		return hslToRGB(v[0], v[1], v[2])
+	case SpaceXYZ:
+		return xyzToRGB(v[0], v[1], v[2])
+	case SpaceLab:
+		return xyzToRGB(labToXYZ(v[0], v[1], v[2]))
+	default:
+		std, _ := s.ycbcr()
+		return ycbcrToRGB(std, v[0], v[1], v[2])
+	}
+}
+
+// scaling returns, for each coordinate of a color space, an offset and a
+// scale factor that map the coordinate to a sample with maximum value m:
+// sample = (coordinate + offset) * scale.
+func (s Space) scaling(m uint16) (offset, scale [3]float64) {
+	mf := float64(m)
+	switch s {
+	case SpaceHSV, SpaceHSL:
+		return [3]float64{}, [3]float64{mf / 360, mf, mf}
+	case SpaceXYZ:
+		return [3]float64{}, [3]float64{mf / whiteX, mf / whiteY, mf / whiteZ}
+	case SpaceLab:
+		return [3]float64{0, 128, 128}, [3]float64{mf / 100, mf / 255, mf / 255}
+	}
+	if _, limited := s.ycbcr(); limited {
+		k := (mf + 1) / 256
+		return [3]float64{16.0 / 219, 128.0 / 224, 128.0 / 224}, [3]float64{219 * k, 224 * k, 224 * k}
+	}
+	c := (mf + 1) / 2 / mf
+	return [3]float64{0, c, c}, [3]float64{mf, mf, mf}
+}
+
+// quantize converts a color space's coordinates to samples with maximum
+// value m, rounding to the nearest integer and clamping to [0, m].
+func (s Space) quantize(v [3]float64, m uint16) (q [3]uint16) {
+	offset, scale := s.scaling(m)
+	for i := range v {
+		f := (v[i]+offset[i])*scale[i] + 0.5
+		switch {
+		case f >= float64(m):
+			q[i] = m
+		case f > 0:
+			q[i] = uint16(f)
+		}
+	}
+	if s == SpaceHSV || s == SpaceHSL {
+		// Hue wraps around.
+		if q[0] == m && v[0] < 360 {
+			q[0] = 0
+		}
+	}
+	return
+}
+
+// dequantize converts samples with maximum value m to a color space's
+// coordinates.
+func (s Space) dequantize(q [3]uint16, m uint16) (v [3]float64) {
+	offset, scale := s.scaling(m)
+	for i := range q {
+		v[i] = float64(q[i])/scale[i] - offset[i]
+	}
+	return
+}
+
+// SpaceM represents a color as three samples in a color space, as stored in
+// a PAM file, and the maximum value of each sample.
+type SpaceM struct {
+	C     [3]uint16
+	M     uint16
+	Space Space
+}
+
+// Coordinates returns a color's coordinates in its color space, such as H,
+// S, and V for SpaceHSV.
+func (c SpaceM) Coordinates() [3]float64 {
+	return c.Space.dequantize(c.C, c.M)
+}
+
+// RGBA converts a SpaceM to alpha-premultiplied R, G, B, and A.
+func (c SpaceM) RGBA() (r, g, b, a uint32) {
+	if c.M == 0 {
+		return
+	}
+	return rgbaFromFloats(c.Space.toRGB(c.Coordinates()))
+}
+
+// A SpaceMModel represents the color space and maximum value of a SpaceM.
+type SpaceMModel struct {
+	Space Space  // Color space
+	M     uint16 // Maximum value of each sample
+}
+
+// Convert converts an arbitrary color to a SpaceM.  A SpaceM in the same
+// color space is rescaled directly rather than by way of RGB.
+func (model SpaceMModel) Convert(c color.Color) color.Color {
+	if sc, ok := c.(SpaceM); ok && sc.Space == model.Space && sc.M != 0 {
+		if sc.M == model.M {
+			return c
+		}
+		for i, v := range sc.C {
+			sc.C[i] = uint16((uint32(v)*uint32(model.M) + uint32(sc.M)/2) / uint32(sc.M))
+		}
+		sc.M = model.M
+		return sc
+	}
+	v := model.Space.fromRGB(rgbFloats(c))
+	return SpaceM{C: model.Space.quantize(v, model.M), M: model.M, Space: model.Space}
+}
+
+// MaxValue returns the maximum value of a SpaceM.
+func (model SpaceMModel) MaxValue() uint16 { return model.M }
+
+// Channels returns 3.
+func (model SpaceMModel) Channels() int { return 3 }
+
+// HasAlpha returns false.
+func (model SpaceMModel) HasAlpha() bool { return false }
+
+// BytesPerSample returns 1 if the maximum value is less than 256 and 2
+// otherwise.
+func (model SpaceMModel) BytesPerSample() int {
+	if model.M < 256 {
+		return 1
+	}
+	return 2
+}
+
+// TupleType returns the PAM tuple type of the model's color space.
+func (model SpaceMModel) TupleType() string { return model.Space.TupleType() }
@@ -0,0 +1,170 @@
+// Test conversions between RGB and other color spaces.
+
+package npcolor
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+// near reports whether two sets of coordinates are within tol of each other.
+func near(a, b [3]float64, tol float64) bool {
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > tol {
+			return false
+		}
+	}
+	return true
+}
+
+// TestSpaceCoordinates confirms that colors convert to the expected
+// coordinates in each color space.
+func TestSpaceCoordinates(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	for _, tc := range []struct {
+		m   color.Model
+		c   color.Color
+		exp color.Color
+	}{
+		{HSVModel, red, HSV{H: 0, S: 1, V: 1}},
+		{HSVModel, color.NRGBA{G: 255, B: 255, A: 255}, HSV{H: 180, S: 1, V: 1}},
+		{HSLModel, red, HSL{H: 0, S: 1, L: 0.5}},
+		{HSLModel, white, HSL{H: 0, S: 0, L: 1}},
+		{YCbCrModel(YCbCrBT601), red, YCbCr{Y: 0.299, Cb: -0.168736, Cr: 0.5, Std: YCbCrBT601}},
+		{YCbCrModel(YCbCrBT709), red, YCbCr{Y: 0.2126, Cb: -0.114572, Cr: 0.5, Std: YCbCrBT709}},
+		{XYZModel, white, XYZ{X: whiteX, Y: whiteY, Z: whiteZ}},
+		{LabModel, white, Lab{L: 100}},
+		{LabModel, red, Lab{L: 53.2408, A: 80.0925, B: 67.2032}},
+	} {
+		var v, e [3]float64
+		switch c := tc.m.Convert(tc.c).(type) {
+		case HSV:
+			v, e = [3]float64{c.H, c.S, c.V}, [3]float64{tc.exp.(HSV).H, tc.exp.(HSV).S, tc.exp.(HSV).V}
+		case HSL:
+			v, e = [3]float64{c.H, c.S, c.L}, [3]float64{tc.exp.(HSL).H, tc.exp.(HSL).S, tc.exp.(HSL).L}
+		case YCbCr:
+			v, e = [3]float64{c.Y, c.Cb, c.Cr}, [3]float64{tc.exp.(YCbCr).Y, tc.exp.(YCbCr).Cb, tc.exp.(YCbCr).Cr}
+		case XYZ:
+			v, e = [3]float64{c.X, c.Y, c.Z}, [3]float64{tc.exp.(XYZ).X, tc.exp.(XYZ).Y, tc.exp.(XYZ).Z}
+		case Lab:
+			v, e = [3]float64{c.L, c.A, c.B}, [3]float64{tc.exp.(Lab).L, tc.exp.(Lab).A, tc.exp.(Lab).B}
+		default:
+			t.Fatalf("Unexpected color type %T", c)
+		}
+		if !near(v, e, 0.001) {
+			t.Fatalf("Expected %v to convert to %v but saw %v", tc.c, e, v)
+		}
+
+		// Converting back should recover the original color.
+		if r, g, b, _ := tc.exp.RGBA(); color.NRGBA64Model.Convert(tc.c) != (color.NRGBA64{uint16(r), uint16(g), uint16(b), 0xffff}) {
+			t.Fatalf("Expected %v to convert back to %v", tc.exp, tc.c)
+		}
+	}
+}
+
+// TestSpaceMRoundTrip confirms that colors survive conversion to samples in
+// each color space and back.
+func TestSpaceMRoundTrip(t *testing.T) {
+	for s := SpaceHSV; s <= SpaceLab; s++ {
+		for _, m := range []uint16{255, 1023, 65535} {
+			model := SpaceMModel{Space: s, M: m}
+			_, scale := s.scaling(m)
+			for r := 0; r < 256; r += 17 {
+				for g := 0; g < 256; g += 51 {
+					for b := 0; b < 256; b += 85 {
+						// Each coordinate should be within half a
+						// sample of its exact value.
+						c := color.NRGBA{uint8(r), uint8(g), uint8(b), 255}
+						sc := model.Convert(c).(SpaceM)
+						v := s.fromRGB(rgbFloats(c))
+						q := sc.Coordinates()
+						for i := range v {
+							d := math.Abs(v[i] - q[i])
+							if i == 0 && (s == SpaceHSV || s == SpaceHSL) {
+								d = math.Min(d, 360-d) // Hue wraps around.
+							}
+							if d > 0.5/scale[i]+1e-9 {
+								t.Fatalf("%s with maxval %d: expected %v to map to %v but saw %v",
+									s.TupleType(), m, c, v, q)
+							}
+						}
+
+						// 16-bit samples should reproduce the
+						// original color.
+						if m < 65535 {
+							continue
+						}
+						r0, g0, b0, _ := c.RGBA()
+						r1, g1, b1, _ := sc.RGBA()
+						for i, v0 := range []uint32{r0, g0, b0} {
+							v1 := []uint32{r1, g1, b1}[i]
+							if v1+0x40 < v0 || v1 > v0+0x40 {
+								t.Fatalf("%s: expected %v to round-trip but saw %v",
+									s.TupleType(), c, color.NRGBA64Model.Convert(sc))
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestSpaceMScaling confirms that samples are scaled to the maximum value as
+// documented.
+func TestSpaceMScaling(t *testing.T) {
+	white := color.White
+	black := color.Black
+	for _, tc := range []struct {
+		s   Space
+		m   uint16
+		c   color.Color
+		exp [3]uint16
+	}{
+		{SpaceYCbCr601, 255, white, [3]uint16{255, 128, 128}},
+		{SpaceYCbCr601Limited, 255, white, [3]uint16{235, 128, 128}},
+		{SpaceYCbCr601Limited, 255, black, [3]uint16{16, 128, 128}},
+		{SpaceYCbCr709Limited, 1023, white, [3]uint16{940, 512, 512}},
+		{SpaceYCbCr709Limited, 1023, black, [3]uint16{64, 512, 512}},
+		{SpaceHSV, 100, color.NRGBA{B: 255, A: 255}, [3]uint16{67, 100, 100}},
+		{SpaceXYZ, 65535, white, [3]uint16{65535, 65535, 65535}},
+		{SpaceLab, 255, white, [3]uint16{255, 128, 128}},
+		{SpaceLab, 255, black, [3]uint16{0, 128, 128}},
+	} {
+		sc := SpaceMModel{Space: tc.s, M: tc.m}.Convert(tc.c).(SpaceM)
+		if sc.C != tc.exp {
+			t.Fatalf("Expected %v in %s with maxval %d to be %v but saw %v", tc.c, tc.s.TupleType(), tc.m, tc.exp, sc.C)
+		}
+	}
+
+	// Converting between maximum values should rescale samples
+	// directly.
+	sc := SpaceM{C: [3]uint16{0, 100, 255}, M: 255, Space: SpaceLab}
+	exp := SpaceM{C: [3]uint16{0, 100 * 257, 65535}, M: 65535, Space: SpaceLab}
+	if c := (SpaceMModel{Space: SpaceLab, M: 65535}).Convert(sc); c != exp {
+		t.Fatalf("Expected %v but saw %v", exp, c)
+	}
+}
+
+// TestSpaceTupleTypes confirms that color spaces and tuple types map to each
+// other.
+func TestSpaceTupleTypes(t *testing.T) {
+	for s := SpaceHSV; s <= SpaceLab; s++ {
+		tt := s.TupleType()
+		if s2, ok := SpaceFromTupleType(tt); !ok || s2 != s {
+			t.Fatalf("Expected %q to map back to %d but saw %d", tt, s, s2)
+		}
+		model := NewModel(tt, 1000)
+		if model != (SpaceMModel{Space: s, M: 1000}) || model.TupleType() != tt {
+			t.Fatalf("Unexpected model %v for tuple type %q", model, tt)
+		}
+	}
+	if _, ok := SpaceFromTupleType("RGB"); ok {
+		t.Fatal("Expected RGB not to be a color space")
+	}
+	if tt := Space(100).TupleType(); tt != "" {
+		t.Fatalf("Expected an invalid space to have no tuple type but saw %q", tt)
+	}
+}
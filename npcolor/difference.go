@@ -0,0 +1,272 @@
+// This file measures differences between colors and finds the nearest color
+// in a palette.
+
+package npcolor
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"sync"
+)
+
+// DeltaE76 returns the CIE 1976 color difference between two Lab colors,
+// which is their Euclidean distance.
+func (c Lab) DeltaE76(d Lab) float64 {
+	dl, da, db := c.L-d.L, c.A-d.A, c.B-d.B
+	return math.Sqrt(dl*dl + da*da + db*db)
+}
+
+// DeltaE94 returns the CIE 1994 color difference between two Lab colors,
+// using the weights for graphic arts (kL=1, K1=0.045, K2=0.015).  c is taken
+// as the reference color, so the measure is not symmetric.
+func (c Lab) DeltaE94(d Lab) float64 {
+	dl, da, db := c.L-d.L, c.A-d.A, c.B-d.B
+	c1, c2 := math.Hypot(c.A, c.B), math.Hypot(d.A, d.B)
+	dc := c1 - c2
+	dh2 := da*da + db*db - dc*dc
+	if dh2 < 0 {
+		dh2 = 0 // Guard against round-off.
+	}
+	sc := 1 + 0.045*c1
+	sh := 1 + 0.015*c1
+	return math.Sqrt(dl*dl + (dc/sc)*(dc/sc) + dh2/(sh*sh))
+}
+
+// DeltaE2000 returns the CIEDE2000 color difference between two Lab colors,
+// with unit weighting factors (kL=kC=kH=1).
+func (c Lab) DeltaE2000(d Lab) float64 {
+	const pow25to7 = 6103515625.0 // 25^7
+	deg := math.Pi / 180
+
+	// Adjust a* to compensate for the non-uniformity of neutral colors.
+	cbar := (math.Hypot(c.A, c.B) + math.Hypot(d.A, d.B)) / 2
+	cbar7 := math.Pow(cbar, 7)
+	g := 0.5 * (1 - math.Sqrt(cbar7/(cbar7+pow25to7)))
+	a1, a2 := (1+g)*c.A, (1+g)*d.A
+	c1, c2 := math.Hypot(a1, c.B), math.Hypot(a2, d.B)
+	h1, h2 := labHue(a1, c.B), labHue(a2, d.B)
+
+	// Compute differences in lightness, chroma, and hue.
+	dl := d.L - c.L
+	dc := c2 - c1
+	var dh float64
+	if c1*c2 != 0 {
+		dh = h2 - h1
+		switch {
+		case dh > 180:
+			dh -= 360
+		case dh < -180:
+			dh += 360
+		}
+	}
+	dhh := 2 * math.Sqrt(c1*c2) * math.Sin(dh/2*deg)
+
+	// Compute means of lightness, chroma, and hue.
+	lbar := (c.L + d.L) / 2
+	cbarp := (c1 + c2) / 2
+	hbar := h1 + h2
+	if c1*c2 != 0 {
+		switch {
+		case math.Abs(h1-h2) <= 180:
+			hbar /= 2
+		case hbar < 360:
+			hbar = (hbar + 360) / 2
+		default:
+			hbar = (hbar - 360) / 2
+		}
+	}
+
+	// Weight the differences and combine them.
+	t := 1 - 0.17*math.Cos((hbar-30)*deg) + 0.24*math.Cos(2*hbar*deg) +
+		0.32*math.Cos((3*hbar+6)*deg) - 0.20*math.Cos((4*hbar-63)*deg)
+	dtheta := 30 * math.Exp(-((hbar-275)/25)*((hbar-275)/25))
+	cbarp7 := math.Pow(cbarp, 7)
+	rc := 2 * math.Sqrt(cbarp7/(cbarp7+pow25to7))
+	l50 := (lbar - 50) * (lbar - 50)
+	sl := 1 + 0.015*l50/math.Sqrt(20+l50)
+	sc := 1 + 0.045*cbarp
+	sh := 1 + 0.015*cbarp*t
+	rt := -math.Sin(2*dtheta*deg) * rc
+	tl, tc, th := dl/sl, dc/sc, dhh/sh
+	return math.Sqrt(tl*tl + tc*tc + th*th + rt*tc*th)
+}
+
+// labHue returns the hue angle, in degrees from 0 up to 360, of a* and b*.
+func labHue(a, b float64) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+	h := math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+// weightedRGB returns the "redmean" weighted Euclidean distance between two
+// colors expressed as red, green, and blue fractions.
+func weightedRGB(r1, g1, b1, r2, g2, b2 float64) float64 {
+	rbar := (r1 + r2) / 2
+	dr, dg, db := r1-r2, g1-g2, b1-b2
+	return math.Sqrt((2+rbar)*dr*dr + 4*dg*dg + (3-rbar)*db*db)
+}
+
+// A Metric specifies how the difference between two colors is measured.
+// All metrics ignore alpha channels.
+type Metric int
+
+// These are the supported color-difference metrics.
+const (
+	// MetricRGB is a weighted Euclidean distance between gamma-encoded
+	// red, green, and blue fractions, with weights that vary with the
+	// mean red level ("redmean").  It ranges from 0.0 (identical) to 3.0
+	// (black versus white) and is cheap to compute.
+	MetricRGB Metric = iota
+
+	// MetricDeltaE76 is the CIE 1976 color difference, ΔE*ab.
+	MetricDeltaE76
+
+	// MetricDeltaE94 is the CIE 1994 color difference, ΔE*94, with the
+	// first color taken as the reference.
+	MetricDeltaE94
+
+	// MetricDeltaE2000 is the CIEDE2000 color difference, ΔE*00.
+	MetricDeltaE2000
+)
+
+// Distance returns the difference between two colors.  Unrecognized metrics
+// behave like MetricRGB.
+func (m Metric) Distance(c1, c2 color.Color) float64 {
+	return m.distance(m.coordinates(c1), m.coordinates(c2))
+}
+
+// coordinates returns the coordinates of a color in the space in which a
+// metric is measured: RGB fractions for MetricRGB or L*a*b* otherwise.
+func (m Metric) coordinates(c color.Color) [3]float64 {
+	r, g, b := rgbFloats(c)
+	switch m {
+	case MetricDeltaE76, MetricDeltaE94, MetricDeltaE2000:
+		return SpaceLab.fromRGB(r, g, b)
+	default:
+		return [3]float64{r, g, b}
+	}
+}
+
+// distance returns the difference between two colors expressed as
+// coordinates.
+func (m Metric) distance(v1, v2 [3]float64) float64 {
+	lab1, lab2 := Lab{v1[0], v1[1], v1[2]}, Lab{v2[0], v2[1], v2[2]}
+	switch m {
+	case MetricDeltaE76:
+		return lab1.DeltaE76(lab2)
+	case MetricDeltaE94:
+		return lab1.DeltaE94(lab2)
+	case MetricDeltaE2000:
+		return lab1.DeltaE2000(lab2)
+	default:
+		return weightedRGB(v1[0], v1[1], v1[2], v2[0], v2[1], v2[2])
+	}
+}
+
+// String returns the name of a color-difference metric.
+func (m Metric) String() string {
+	switch m {
+	case MetricRGB:
+		return "RGB"
+	case MetricDeltaE76:
+		return "ΔE76"
+	case MetricDeltaE94:
+		return "ΔE94"
+	case MetricDeltaE2000:
+		return "ΔE2000"
+	default:
+		return fmt.Sprintf("%%!s(npcolor.Metric=%d)", int(m))
+	}
+}
+
+// maxPaletteCache is the number of colors a Palette remembers before it
+// starts over.
+const maxPaletteCache = 1 << 16
+
+// A Palette finds the color in a color.Palette that is nearest to a given
+// color according to a Metric.  It precomputes each palette entry's
+// coordinates and caches the results of previous searches, so it is much
+// faster than color.Palette when the same colors recur, as they do in most
+// images.  A Palette is safe for concurrent use and implements color.Model,
+// so it can be used wherever a color.Palette is used as a model, including
+// with the two-color palette of a netpbm.BW image.
+type Palette struct {
+	colors color.Palette // Palette entries
+	metric Metric        // Color-difference metric
+	coords [][3]float64  // Each entry's coordinates under metric
+
+	mu    sync.RWMutex      // Protects cache
+	cache map[[3]uint16]int // Map from a 16-bit color to an index
+}
+
+// NewPalette returns a Palette that searches colors using metric m.
+func NewPalette(colors color.Palette, m Metric) *Palette {
+	p := &Palette{
+		colors: colors,
+		metric: m,
+		coords: make([][3]float64, len(colors)),
+		cache:  make(map[[3]uint16]int),
+	}
+	for i, c := range colors {
+		p.coords[i] = m.coordinates(c)
+	}
+	return p
+}
+
+// Colors returns the palette's colors.
+func (p *Palette) Colors() color.Palette { return p.colors }
+
+// Metric returns the metric the palette uses to compare colors.
+func (p *Palette) Metric() Metric { return p.metric }
+
+// Index returns the index of the palette color nearest to c.  Ties are
+// broken in favor of the lower index.  Index panics if the palette is empty.
+func (p *Palette) Index(c color.Color) int {
+	// Check the cache.
+	r, g, b, _ := nrgba64(c)
+	key := [3]uint16{uint16(r), uint16(g), uint16(b)}
+	p.mu.RLock()
+	idx, ok := p.cache[key]
+	p.mu.RUnlock()
+	if ok {
+		return idx
+	}
+
+	// Search the palette exhaustively.
+	if len(p.colors) == 0 {
+		panic("Nearest color requested from an empty palette")
+	}
+	v := p.metric.coordinates(color.NRGBA64{key[0], key[1], key[2], 0xffff})
+	best := math.Inf(1)
+	for i, pv := range p.coords {
+		if d := p.metric.distance(v, pv); d < best {
+			idx, best = i, d
+			if d == 0 {
+				break
+			}
+		}
+	}
+
+	// Cache the result.
+	p.mu.Lock()
+	if len(p.cache) >= maxPaletteCache {
+		p.cache = make(map[[3]uint16]int)
+	}
+	p.cache[key] = idx
+	p.mu.Unlock()
+	return idx
+}
+
+// Convert returns the palette color nearest to c.
+func (p *Palette) Convert(c color.Color) color.Color {
+	if len(p.colors) == 0 {
+		return nil
+	}
+	return p.colors[p.Index(c)]
+}
@@ -0,0 +1,127 @@
+// Test color-difference metrics and nearest-color search.
+
+package npcolor
+
+import (
+	"image/color"
+	"math"
+	"sync"
+	"testing"
+)
+
+// TestDeltaE confirms that the CIE color differences match published values.
+func TestDeltaE(t *testing.T) {
+	// These pairs and their CIEDE2000 differences come from Sharma, Wu,
+	// and Dalal, "The CIEDE2000 Color-Difference Formula: Implementation
+	// Notes, Supplementary Test Data, and Mathematical Observations".
+	for _, tc := range []struct {
+		c1, c2 Lab
+		exp    float64
+	}{
+		{Lab{50, 2.6772, -79.7751}, Lab{50, 0, -82.7485}, 2.0425},
+		{Lab{50, 0, 0}, Lab{50, -1, 2}, 2.3669},
+		{Lab{50, 2.5, 0}, Lab{73, 25, -18}, 27.1492},
+		{Lab{60.2574, -34.0099, 36.2677}, Lab{60.4626, -34.1751, 39.4387}, 1.2644},
+		{Lab{2.0776, 0.0795, -1.1350}, Lab{0.9033, -0.0636, -0.5514}, 0.9082},
+	} {
+		for _, d := range []float64{tc.c1.DeltaE2000(tc.c2), tc.c2.DeltaE2000(tc.c1)} {
+			if math.Abs(d-tc.exp) > 0.0001 {
+				t.Fatalf("Expected ΔE2000(%v, %v) = %.4f but saw %.4f", tc.c1, tc.c2, tc.exp, d)
+			}
+		}
+	}
+
+	// Check ΔE76 and ΔE94.
+	c1, c2 := Lab{50, 2.6772, -79.7751}, Lab{50, 0, -82.7485}
+	if d := c1.DeltaE76(c2); math.Abs(d-4.0011) > 0.0001 {
+		t.Fatalf("Expected ΔE76 = 4.0011 but saw %.4f", d)
+	}
+	if d := c1.DeltaE94(c2); math.Abs(d-1.3950) > 0.0001 {
+		t.Fatalf("Expected ΔE94 = 1.3950 but saw %.4f", d)
+	}
+	c1, c2 = Lab{50, 0, 0}, Lab{50, -1, 2}
+	if d := c1.DeltaE94(c2); math.Abs(d-math.Sqrt(5)) > 0.0001 {
+		t.Fatalf("Expected ΔE94 from a neutral color to equal ΔE76 but saw %.4f", d)
+	}
+}
+
+// TestMetricDistance confirms that metrics compare colors independently of
+// their maximum values.
+func TestMetricDistance(t *testing.T) {
+	for m := MetricRGB; m <= MetricDeltaE2000; m++ {
+		// Equal colors with different maximum values should have no
+		// difference.
+		c1 := RGBM{R: 10, G: 20, B: 30, M: 100}
+		c2 := RGBM64{R: 1000, G: 2000, B: 3000, M: 10000}
+		if d := m.Distance(c1, c2); d > 1e-9 {
+			t.Fatalf("Expected %s distance 0 but saw %v", m, d)
+		}
+
+		// Black and white should be maximally different.
+		d := m.Distance(color.Black, color.White)
+		exp := 100.0
+		if m == MetricRGB {
+			exp = 3
+		}
+		if math.Abs(d-exp) > 0.001 {
+			t.Fatalf("Expected %s distance %v between black and white but saw %v", m, exp, d)
+		}
+	}
+	if s := Metric(10).String(); s != "%!s(npcolor.Metric=10)" {
+		t.Fatalf("Unexpected name %q", s)
+	}
+}
+
+// TestPalette confirms that a Palette finds the nearest color and agrees
+// with the cache.
+func TestPalette(t *testing.T) {
+	colors := color.Palette{
+		color.Black,
+		color.White,
+		RGBM{R: 255, M: 255},
+		RGBM{G: 255, M: 255},
+		RGBM{B: 255, M: 255},
+		RGBM{R: 128, G: 128, B: 128, M: 255},
+	}
+	for m := MetricRGB; m <= MetricDeltaE2000; m++ {
+		p := NewPalette(colors, m)
+		if p.Metric() != m || len(p.Colors()) != len(colors) {
+			t.Fatalf("Unexpected palette %v", p)
+		}
+		for _, tc := range []struct {
+			c   color.Color
+			exp int
+		}{
+			{RGBM64{R: 60000, G: 1000, B: 3000, M: 65535}, 2},
+			{GrayM{Y: 9, M: 10}, 1},
+			{GrayM{Y: 1, M: 10}, 0},
+			{color.NRGBA{R: 130, G: 125, B: 128, A: 255}, 5},
+			{RGBAM{B: 200, A: 100, M: 200}, 4},
+		} {
+			// Search twice to exercise the cache.
+			for i := 0; i < 2; i++ {
+				if idx := p.Index(tc.c); idx != tc.exp {
+					t.Fatalf("Expected %s to map %v to %v but saw %v", m, tc.c, colors[tc.exp], colors[idx])
+				}
+			}
+			if c := p.Convert(tc.c); c != colors[tc.exp] {
+				t.Fatalf("Expected %v but saw %v", colors[tc.exp], c)
+			}
+		}
+	}
+
+	// Exact palette colors should always map to themselves, even when
+	// the palette is searched concurrently.
+	p := NewPalette(colors, MetricDeltaE2000)
+	var wg sync.WaitGroup
+	for i := range colors {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if idx := p.Index(colors[i]); idx != i {
+				t.Errorf("Expected %v to map to itself but saw %v", colors[i], colors[idx])
+			}
+		}(i)
+	}
+	wg.Wait()
+}
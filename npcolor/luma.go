@@ -0,0 +1,74 @@
+// This file provides the formulas used to convert color to grayscale.
+
+package npcolor
+
+import "fmt"
+
+// A Luma specifies how a grayscale value is computed from red, green, and
+// blue channels.  All formulas operate on integers and round to the nearest
+// integer, so conversions are bit-exact on every platform.
+type Luma int
+
+// These are the supported luma formulas.  In each, r, g, and b are channels
+// sharing any common maximum value, and the result shares that maximum
+// value.
+const (
+	// LumaBT601 weights channels as in ITU-R BT.601:
+	// (299*r + 587*g + 114*b + 500) / 1000.  It is the default.
+	LumaBT601 Luma = iota
+
+	// LumaBT709 weights channels as in ITU-R BT.709:
+	// (2126*r + 7152*g + 722*b + 5000) / 10000.
+	LumaBT709
+
+	// LumaAverage weights all channels equally: (r + g + b + 1) / 3.
+	LumaAverage
+
+	// LumaRed selects the red channel: r.
+	LumaRed
+
+	// LumaGreen selects the green channel: g.
+	LumaGreen
+
+	// LumaBlue selects the blue channel: b.
+	LumaBlue
+)
+
+// Y computes a grayscale value from red, green, and blue channels of at most
+// 16 bits each.  Unrecognized formulas behave like LumaBT601.
+func (l Luma) Y(r, g, b uint32) uint32 {
+	switch l {
+	case LumaBT709:
+		return (2126*r + 7152*g + 722*b + 5000) / 10000
+	case LumaAverage:
+		return (r + g + b + 1) / 3
+	case LumaRed:
+		return r
+	case LumaGreen:
+		return g
+	case LumaBlue:
+		return b
+	default:
+		return (299*r + 587*g + 114*b + 500) / 1000
+	}
+}
+
+// String returns the name of a luma formula.
+func (l Luma) String() string {
+	switch l {
+	case LumaBT601:
+		return "BT.601"
+	case LumaBT709:
+		return "BT.709"
+	case LumaAverage:
+		return "average"
+	case LumaRed:
+		return "red"
+	case LumaGreen:
+		return "green"
+	case LumaBlue:
+		return "blue"
+	default:
+		return fmt.Sprintf("%%!s(npcolor.Luma=%d)", int(l))
+	}
+}
@@ -0,0 +1,61 @@
+// Test conversion of color to grayscale.
+
+package npcolor
+
+import (
+	"image/color"
+	"testing"
+)
+
+// TestLumaY tests each luma formula on a few colors.
+func TestLumaY(t *testing.T) {
+	for _, tc := range []struct {
+		l       Luma
+		r, g, b uint32
+		y       uint32
+	}{
+		{LumaBT601, 255, 128, 0, 151},
+		{LumaBT709, 255, 128, 0, 146},
+		{LumaAverage, 255, 128, 0, 128},
+		{LumaAverage, 1, 1, 0, 1},
+		{LumaAverage, 1, 0, 0, 0},
+		{LumaRed, 255, 128, 0, 255},
+		{LumaGreen, 255, 128, 0, 128},
+		{LumaBlue, 255, 128, 0, 0},
+		{LumaBT601, 0xffff, 0xffff, 0xffff, 0xffff},
+		{LumaBT709, 0xffff, 0xffff, 0xffff, 0xffff},
+		{LumaAverage, 0xffff, 0xffff, 0xffff, 0xffff},
+	} {
+		if y := tc.l.Y(tc.r, tc.g, tc.b); y != tc.y {
+			t.Fatalf("Expected %s luma of (%d, %d, %d) to be %d but saw %d", tc.l, tc.r, tc.g, tc.b, tc.y, y)
+		}
+	}
+}
+
+// TestLumaModels tests that every grayscale model honors its Luma field.
+func TestLumaModels(t *testing.T) {
+	c := color.NRGBA64{R: 0xffff, G: 0x8000, B: 0, A: 0xffff}
+	for _, l := range []Luma{LumaBT601, LumaBT709, LumaAverage, LumaRed, LumaGreen, LumaBlue} {
+		y := l.Y(0xffff, 0x8000, 0)
+		if g := (GrayM32Model{M: 0xffff, Luma: l}).Convert(c).(GrayM32); uint32(g.Y) != y {
+			t.Fatalf("GrayM32Model: expected %s luma %d but saw %d", l, y, g.Y)
+		}
+		if g := (GrayAM48Model{M: 0xffff, Luma: l}).Convert(c).(GrayAM48); uint32(g.Y) != y {
+			t.Fatalf("GrayAM48Model: expected %s luma %d but saw %d", l, y, g.Y)
+		}
+		y8 := uint8((y*255 + 0xffff/2) / 0xffff)
+		if g := (GrayMModel{M: 255, Luma: l}).Convert(c).(GrayM); g.Y != y8 {
+			t.Fatalf("GrayMModel: expected %s luma %d but saw %d", l, y8, g.Y)
+		}
+		if g := (GrayAMModel{M: 255, Luma: l}).Convert(c).(GrayAM); g.Y != y8 {
+			t.Fatalf("GrayAMModel: expected %s luma %d but saw %d", l, y8, g.Y)
+		}
+	}
+
+	// Gray colors are unaffected by the choice of formula.
+	for _, l := range []Luma{LumaBT601, LumaBT709, LumaAverage, LumaBlue} {
+		if g := (GrayMModel{M: 100, Luma: l}).Convert(GrayM{Y: 37, M: 100}).(GrayM); g.Y != 37 {
+			t.Fatalf("Expected %s luma to preserve Y=37 but saw %d", l, g.Y)
+		}
+	}
+}
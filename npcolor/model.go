@@ -0,0 +1,230 @@
+// This file describes the sample layout of each color model.
+
+package npcolor
+
+import "image/color"
+
+// A Model is a color model that additionally describes how colors are
+// represented as Netpbm samples.  Every model in this package implements
+// Model.  The 8-bit models store one byte per sample and the 16-bit models
+// two, regardless of maximum value, but the New*Model constructors choose a
+// model whose storage matches that of a Netpbm file with the given maximum
+// value.
+type Model interface {
+	color.Model
+	MaxValue() uint16    // Maximum value of each channel
+	Channels() int       // Number of channels, including alpha
+	HasAlpha() bool      // true=the last channel is alpha; false=no alpha channel
+	BytesPerSample() int // Number of bytes each sample occupies in memory
+	TupleType() string   // PAM tuple type of the model's colors
+}
+
+// NewGrayModel returns a GrayMModel if m is less than 256 or a GrayM32Model
+// otherwise.
+func NewGrayModel(m uint16) Model {
+	if m < 256 {
+		return GrayMModel{M: uint8(m)}
+	}
+	return GrayM32Model{M: m}
+}
+
+// NewGrayAlphaModel returns a GrayAMModel if m is less than 256 or a
+// GrayAM48Model otherwise.
+func NewGrayAlphaModel(m uint16) Model {
+	if m < 256 {
+		return GrayAMModel{M: uint8(m)}
+	}
+	return GrayAM48Model{M: m}
+}
+
+// NewRGBModel returns an RGBMModel if m is less than 256 or an RGBM64Model
+// otherwise.
+func NewRGBModel(m uint16) Model {
+	if m < 256 {
+		return RGBMModel{M: uint8(m)}
+	}
+	return RGBM64Model{M: m}
+}
+
+// NewRGBAlphaModel returns an RGBAMModel if m is less than 256 or an
+// RGBAM64Model otherwise.
+func NewRGBAlphaModel(m uint16) Model {
+	if m < 256 {
+		return RGBAMModel{M: uint8(m)}
+	}
+	return RGBAM64Model{M: m}
+}
+
+// NewModel returns the integer model with maximum value m whose colors have
+// the given PAM tuple type: GRAYSCALE, GRAYSCALE_ALPHA, RGB, RGB_ALPHA, or the
+// tuple type of a Space, for which it returns a SpaceMModel.  It returns nil
+// for any other tuple type.
+func NewModel(tupleType string, m uint16) Model {
+	if s, ok := SpaceFromTupleType(tupleType); ok {
+		return SpaceMModel{Space: s, M: m}
+	}
+	switch tupleType {
+	case "GRAYSCALE":
+		return NewGrayModel(m)
+	case "GRAYSCALE_ALPHA":
+		return NewGrayAlphaModel(m)
+	case "RGB":
+		return NewRGBModel(m)
+	case "RGB_ALPHA":
+		return NewRGBAlphaModel(m)
+	default:
+		return nil
+	}
+}
+
+// MaxValue returns the maximum value of a GrayM.
+func (model GrayMModel) MaxValue() uint16 { return uint16(model.M) }
+
+// Channels returns 1 (gray).
+func (model GrayMModel) Channels() int { return 1 }
+
+// HasAlpha returns false.
+func (model GrayMModel) HasAlpha() bool { return false }
+
+// BytesPerSample returns 1, the size of an 8-bit sample.
+func (model GrayMModel) BytesPerSample() int { return 1 }
+
+// TupleType returns "GRAYSCALE".
+func (model GrayMModel) TupleType() string { return "GRAYSCALE" }
+
+// MaxValue returns the maximum value of a GrayM32.
+func (model GrayM32Model) MaxValue() uint16 { return model.M }
+
+// Channels returns 1 (gray).
+func (model GrayM32Model) Channels() int { return 1 }
+
+// HasAlpha returns false.
+func (model GrayM32Model) HasAlpha() bool { return false }
+
+// BytesPerSample returns 2, the size of a 16-bit sample.
+func (model GrayM32Model) BytesPerSample() int { return 2 }
+
+// TupleType returns "GRAYSCALE".
+func (model GrayM32Model) TupleType() string { return "GRAYSCALE" }
+
+// MaxValue returns the maximum value of an RGBM.
+func (model RGBMModel) MaxValue() uint16 { return uint16(model.M) }
+
+// Channels returns 3 (red, green, and blue).
+func (model RGBMModel) Channels() int { return 3 }
+
+// HasAlpha returns false.
+func (model RGBMModel) HasAlpha() bool { return false }
+
+// BytesPerSample returns 1, the size of an 8-bit sample.
+func (model RGBMModel) BytesPerSample() int { return 1 }
+
+// TupleType returns "RGB".
+func (model RGBMModel) TupleType() string { return "RGB" }
+
+// MaxValue returns the maximum value of an RGBM64.
+func (model RGBM64Model) MaxValue() uint16 { return model.M }
+
+// Channels returns 3 (red, green, and blue).
+func (model RGBM64Model) Channels() int { return 3 }
+
+// HasAlpha returns false.
+func (model RGBM64Model) HasAlpha() bool { return false }
+
+// BytesPerSample returns 2, the size of a 16-bit sample.
+func (model RGBM64Model) BytesPerSample() int { return 2 }
+
+// TupleType returns "RGB".
+func (model RGBM64Model) TupleType() string { return "RGB" }
+
+// MaxValue returns the maximum value of a GrayAM.
+func (model GrayAMModel) MaxValue() uint16 { return uint16(model.M) }
+
+// Channels returns 2 (gray and alpha).
+func (model GrayAMModel) Channels() int { return 2 }
+
+// HasAlpha returns true.
+func (model GrayAMModel) HasAlpha() bool { return true }
+
+// BytesPerSample returns 1, the size of an 8-bit sample.
+func (model GrayAMModel) BytesPerSample() int { return 1 }
+
+// TupleType returns "GRAYSCALE_ALPHA".
+func (model GrayAMModel) TupleType() string { return "GRAYSCALE_ALPHA" }
+
+// MaxValue returns the maximum value of a GrayAM48.
+func (model GrayAM48Model) MaxValue() uint16 { return model.M }
+
+// Channels returns 2 (gray and alpha).
+func (model GrayAM48Model) Channels() int { return 2 }
+
+// HasAlpha returns true.
+func (model GrayAM48Model) HasAlpha() bool { return true }
+
+// BytesPerSample returns 2, the size of a 16-bit sample.
+func (model GrayAM48Model) BytesPerSample() int { return 2 }
+
+// TupleType returns "GRAYSCALE_ALPHA".
+func (model GrayAM48Model) TupleType() string { return "GRAYSCALE_ALPHA" }
+
+// MaxValue returns the maximum value of an RGBAM.
+func (model RGBAMModel) MaxValue() uint16 { return uint16(model.M) }
+
+// Channels returns 4 (red, green, blue, and alpha).
+func (model RGBAMModel) Channels() int { return 4 }
+
+// HasAlpha returns true.
+func (model RGBAMModel) HasAlpha() bool { return true }
+
+// BytesPerSample returns 1, the size of an 8-bit sample.
+func (model RGBAMModel) BytesPerSample() int { return 1 }
+
+// TupleType returns "RGB_ALPHA".
+func (model RGBAMModel) TupleType() string { return "RGB_ALPHA" }
+
+// MaxValue returns the maximum value of an RGBAM64.
+func (model RGBAM64Model) MaxValue() uint16 { return model.M }
+
+// Channels returns 4 (red, green, blue, and alpha).
+func (model RGBAM64Model) Channels() int { return 4 }
+
+// HasAlpha returns true.
+func (model RGBAM64Model) HasAlpha() bool { return true }
+
+// BytesPerSample returns 2, the size of a 16-bit sample.
+func (model RGBAM64Model) BytesPerSample() int { return 2 }
+
+// TupleType returns "RGB_ALPHA".
+func (model RGBAM64Model) TupleType() string { return "RGB_ALPHA" }
+
+// MaxValue returns 65535, the maximum value to which a GrayF32 is quantized
+// by default.
+func (model GrayF32Model) MaxValue() uint16 { return 0xffff }
+
+// Channels returns 1 (gray).
+func (model GrayF32Model) Channels() int { return 1 }
+
+// HasAlpha returns false.
+func (model GrayF32Model) HasAlpha() bool { return false }
+
+// BytesPerSample returns 4, the size of a float32.
+func (model GrayF32Model) BytesPerSample() int { return 4 }
+
+// TupleType returns "GRAYSCALE".
+func (model GrayF32Model) TupleType() string { return "GRAYSCALE" }
+
+// MaxValue returns 65535, the maximum value to which an RGBF32 is quantized
+// by default.
+func (model RGBF32Model) MaxValue() uint16 { return 0xffff }
+
+// Channels returns 3 (red, green, and blue).
+func (model RGBF32Model) Channels() int { return 3 }
+
+// HasAlpha returns false.
+func (model RGBF32Model) HasAlpha() bool { return false }
+
+// BytesPerSample returns 4, the size of a float32.
+func (model RGBF32Model) BytesPerSample() int { return 4 }
+
+// TupleType returns "RGB".
+func (model RGBF32Model) TupleType() string { return "RGB" }
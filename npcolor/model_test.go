@@ -0,0 +1,71 @@
+// Test the Model interface.
+
+package npcolor
+
+import "testing"
+
+// TestModelLayout tests that every model reports its sample layout.
+func TestModelLayout(t *testing.T) {
+	for _, tc := range []struct {
+		model    Model
+		maxVal   uint16
+		channels int
+		alpha    bool
+		bytes    int
+		tt       string
+	}{
+		{GrayMModel{M: 100}, 100, 1, false, 1, "GRAYSCALE"},
+		{GrayM32Model{M: 1000}, 1000, 1, false, 2, "GRAYSCALE"},
+		{RGBMModel{M: 255}, 255, 3, false, 1, "RGB"},
+		{RGBM64Model{M: 65535}, 65535, 3, false, 2, "RGB"},
+		{GrayAMModel{M: 7}, 7, 2, true, 1, "GRAYSCALE_ALPHA"},
+		{GrayAM48Model{M: 300}, 300, 2, true, 2, "GRAYSCALE_ALPHA"},
+		{RGBAMModel{M: 1}, 1, 4, true, 1, "RGB_ALPHA"},
+		{RGBAM64Model{M: 4095}, 4095, 4, true, 2, "RGB_ALPHA"},
+		{GrayF32Model{}, 65535, 1, false, 4, "GRAYSCALE"},
+		{RGBF32Model{}, 65535, 3, false, 4, "RGB"},
+	} {
+		m := tc.model
+		if m.MaxValue() != tc.maxVal || m.Channels() != tc.channels || m.HasAlpha() != tc.alpha ||
+			m.BytesPerSample() != tc.bytes || m.TupleType() != tc.tt {
+			t.Fatalf("Unexpected layout (%d, %d, %v, %d, %q) for %#v",
+				m.MaxValue(), m.Channels(), m.HasAlpha(), m.BytesPerSample(), m.TupleType(), m)
+		}
+	}
+}
+
+// TestNewModel tests that the model constructors select 8-bit or 16-bit
+// models based on the maximum value.
+func TestNewModel(t *testing.T) {
+	for _, tc := range []struct {
+		model Model
+		exp   Model
+	}{
+		{NewGrayModel(255), GrayMModel{M: 255}},
+		{NewGrayModel(256), GrayM32Model{M: 256}},
+		{NewGrayAlphaModel(1), GrayAMModel{M: 1}},
+		{NewGrayAlphaModel(65535), GrayAM48Model{M: 65535}},
+		{NewRGBModel(15), RGBMModel{M: 15}},
+		{NewRGBModel(1023), RGBM64Model{M: 1023}},
+		{NewRGBAlphaModel(200), RGBAMModel{M: 200}},
+		{NewRGBAlphaModel(4095), RGBAM64Model{M: 4095}},
+		{NewModel("GRAYSCALE", 300), GrayM32Model{M: 300}},
+		{NewModel("GRAYSCALE_ALPHA", 3), GrayAMModel{M: 3}},
+		{NewModel("RGB", 255), RGBMModel{M: 255}},
+		{NewModel("RGB_ALPHA", 256), RGBAM64Model{M: 256}},
+	} {
+		if tc.model != tc.exp {
+			t.Fatalf("Expected %#v but saw %#v", tc.exp, tc.model)
+		}
+		nBytes := 1
+		if tc.model.MaxValue() >= 256 {
+			nBytes = 2
+		}
+		if tc.model.BytesPerSample() != nBytes {
+			t.Fatalf("%#v stores %d bytes per sample", tc.model, tc.model.BytesPerSample())
+		}
+	}
+	if m := NewModel("BLACKANDWHITE", 1); m != nil {
+		t.Fatalf("Expected nil but saw %#v", m)
+	}
+}
@@ -0,0 +1,300 @@
+// This file parses and formats color specifications in the forms that Netpbm
+// tools accept.
+
+package npcolor
+
+//go:generate go run ../helpers/namegen.go /usr/share/X11/rgb.txt
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// A namedColor associates a color name with 8-bit red, green, and blue
+// values.
+type namedColor struct {
+	name    string
+	r, g, b uint8
+}
+
+// canonicalName converts a color name to the form used to look it up.  Color
+// names are case-insensitive and ignore spaces.
+func canonicalName(name string) string {
+	return strings.ToLower(strings.Replace(name, " ", "", -1))
+}
+
+// colorNameIndex maps each canonical color name to the index in colorNames
+// of its first occurrence.
+var colorNameIndex = func() map[string]int {
+	idx := make(map[string]int, len(colorNames))
+	for i, nc := range colorNames {
+		cn := canonicalName(nc.name)
+		if _, ok := idx[cn]; !ok {
+			idx[cn] = i
+		}
+	}
+	return idx
+}()
+
+// Parse parses a color specification and returns the color it represents,
+// scaled to maximum value maxVal.  The result is an RGBM if maxVal is less
+// than 256 or an RGBM64 otherwise, or an RGBAM or RGBAM64 if spec includes
+// an alpha channel.  Parse accepts the following forms:
+//
+//	rgb:r/g/b       1–4 hexadecimal digits per channel, each a fraction of
+//	                the largest value with that many digits (e.g., ff/80/0)
+//	rgbi:r/g/b      decimal fractions from 0.0 to 1.0
+//	rgb-N:r/g/b     decimal integers from 0 to N (e.g., rgb-255:255/128/0)
+//	#rgb, #rrggbb, #rrrgggbbb, #rrrrggggbbbb
+//	                hexadecimal digits, interpreted as with rgb:
+//	r,g,b           decimal fractions from 0.0 to 1.0
+//	name            a color name from X11's rgb.txt, ignoring case and
+//	                spaces (e.g., "Ghost White")
+//	rgba:r/g/b/a    like rgb: but with an alpha channel
+//	rgbai:r/g/b/a   like rgbi: but with an alpha channel
+//
+// The rgba: and rgbai: forms are extensions provided by this package.  Netpbm
+// tools do not accept them.
+//
+// All channels are rounded to the nearest integer.
+func Parse(spec string, maxVal uint16) (color.Color, error) {
+	if maxVal == 0 {
+		return nil, errors.New("Maximum value must be at least 1")
+	}
+	s := strings.TrimSpace(spec)
+	lower := strings.ToLower(s)
+	var ch []uint16
+	var ok bool
+	switch {
+	case strings.HasPrefix(lower, "rgb:"):
+		ch, ok = parseHexChannels(strings.Split(s[4:], "/"), 3, maxVal)
+	case strings.HasPrefix(lower, "rgba:"):
+		ch, ok = parseHexChannels(strings.Split(s[5:], "/"), 4, maxVal)
+	case strings.HasPrefix(lower, "rgbi:"):
+		ch, ok = parseFracChannels(strings.Split(s[5:], "/"), 3, maxVal)
+	case strings.HasPrefix(lower, "rgbai:"):
+		ch, ok = parseFracChannels(strings.Split(s[6:], "/"), 4, maxVal)
+	case strings.HasPrefix(lower, "rgb-"):
+		ch, ok = parseIntChannels(s[4:], maxVal)
+	case strings.HasPrefix(s, "#"):
+		digits := s[1:]
+		n := len(digits) / 3
+		if n < 1 || n > 4 || len(digits)%3 != 0 {
+			break
+		}
+		ch, ok = parseHexChannels([]string{digits[:n], digits[n : 2*n], digits[2*n:]}, 3, maxVal)
+	case strings.Contains(s, ","):
+		ch, ok = parseFracChannels(strings.Split(s, ","), 3, maxVal)
+	default:
+		i, found := colorNameIndex[canonicalName(s)]
+		if !found {
+			return nil, fmt.Errorf("Unknown color name %q", spec)
+		}
+		nc := colorNames[i]
+		ch, ok = make([]uint16, 3), true
+		for j, v := range [...]uint8{nc.r, nc.g, nc.b} {
+			ch[j] = scaleInt(uint64(v), 255, maxVal)
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("Invalid color specification %q", spec)
+	}
+
+	// Return a color of the appropriate type.
+	switch {
+	case len(ch) == 3 && maxVal < 256:
+		return RGBM{R: uint8(ch[0]), G: uint8(ch[1]), B: uint8(ch[2]), M: uint8(maxVal)}, nil
+	case len(ch) == 3:
+		return RGBM64{R: ch[0], G: ch[1], B: ch[2], M: maxVal}, nil
+	case maxVal < 256:
+		return RGBAM{R: uint8(ch[0]), G: uint8(ch[1]), B: uint8(ch[2]), A: uint8(ch[3]), M: uint8(maxVal)}, nil
+	default:
+		return RGBAM64{R: ch[0], G: ch[1], B: ch[2], A: ch[3], M: maxVal}, nil
+	}
+}
+
+// scaleInt scales an integer in [0, n] to [0, m], rounding to the nearest
+// integer.
+func scaleInt(v, n uint64, m uint16) uint16 {
+	return uint16((v*uint64(m) + n/2) / n)
+}
+
+// parseHexChannels parses a list of n hexadecimal channel values, each with
+// 1–4 digits, and scales them to maximum value m.
+func parseHexChannels(fields []string, n int, m uint16) ([]uint16, bool) {
+	if len(fields) != n {
+		return nil, false
+	}
+	ch := make([]uint16, n)
+	for i, f := range fields {
+		if len(f) < 1 || len(f) > 4 {
+			return nil, false
+		}
+		v, err := strconv.ParseUint(f, 16, 16)
+		if err != nil {
+			return nil, false
+		}
+		ch[i] = scaleInt(v, 1<<(4*uint(len(f)))-1, m)
+	}
+	return ch, true
+}
+
+// parseFracChannels parses a list of n decimal fractions in [0, 1] and
+// scales them to maximum value m.
+func parseFracChannels(fields []string, n int, m uint16) ([]uint16, bool) {
+	if len(fields) != n {
+		return nil, false
+	}
+	ch := make([]uint16, n)
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil || !(v >= 0 && v <= 1) {
+			return nil, false
+		}
+		ch[i] = uint16(v*float64(m) + 0.5)
+	}
+	return ch, true
+}
+
+// parseIntChannels parses the "N:r/g/b" portion of an rgb-N: specification
+// and scales the channels from maximum value N to maximum value m.
+func parseIntChannels(s string, m uint16) ([]uint16, bool) {
+	colon := strings.Index(s, ":")
+	if colon < 0 {
+		return nil, false
+	}
+	n, err := strconv.ParseUint(s[:colon], 10, 16)
+	if err != nil || n == 0 {
+		return nil, false
+	}
+	fields := strings.Split(s[colon+1:], "/")
+	if len(fields) != 3 {
+		return nil, false
+	}
+	ch := make([]uint16, 3)
+	for i, f := range fields {
+		v, err := strconv.ParseUint(f, 10, 16)
+		if err != nil || v > n {
+			return nil, false
+		}
+		ch[i] = scaleInt(v, n, m)
+	}
+	return ch, true
+}
+
+// ClosestName returns the name from X11's rgb.txt of the color nearest to c
+// in 8-bit RGB space, ignoring alpha.  It additionally reports whether the
+// named color matches c exactly when c is rounded to 8 bits per channel.
+// Ties are broken in favor of the name appearing first in rgb.txt.
+func ClosestName(c color.Color) (string, bool) {
+	r, g, b, _ := nrgba64(c)
+	var rgb [3]int
+	for i, v := range [...]uint32{r, g, b} {
+		rgb[i] = int((v*0xff + 0xffff/2) / 0xffff)
+	}
+	best, bestDist := 0, -1
+	for i, nc := range colorNames {
+		dr, dg, db := int(nc.r)-rgb[0], int(nc.g)-rgb[1], int(nc.b)-rgb[2]
+		dist := dr*dr + dg*dg + db*db
+		if bestDist < 0 || dist < bestDist {
+			best, bestDist = i, dist
+			if dist == 0 {
+				break
+			}
+		}
+	}
+	return colorNames[best].name, bestDist == 0
+}
+
+// A Syntax is a form of color specification that FormatSpec can produce and
+// Parse can parse.
+type Syntax int
+
+// These are the syntaxes that FormatSpec supports.
+const (
+	// SyntaxRGB produces rgb:rr/gg/bb if every channel is exactly
+	// representable with 8 bits and rgb:rrrr/gggg/bbbb otherwise.
+	SyntaxRGB Syntax = iota
+
+	// SyntaxRGBI produces rgbi:r/g/b, with each channel a decimal
+	// fraction.
+	SyntaxRGBI
+
+	// SyntaxHex produces #rrggbb if every channel is exactly
+	// representable with 8 bits and #rrrrggggbbbb otherwise.
+	SyntaxHex
+
+	// SyntaxFraction produces r,g,b, with each channel a decimal
+	// fraction.
+	SyntaxFraction
+
+	// SyntaxName produces the name of the closest color in X11's rgb.txt,
+	// as returned by ClosestName.
+	SyntaxName
+
+	// SyntaxRGBA is like SyntaxRGB but produces rgba:rr/gg/bb/aa or
+	// rgba:rrrr/gggg/bbbb/aaaa for translucent colors.  Netpbm tools do
+	// not accept this syntax.
+	SyntaxRGBA
+
+	// SyntaxRGBAI is like SyntaxRGBI but produces rgbai:r/g/b/a for
+	// translucent colors.  Netpbm tools do not accept this syntax.
+	SyntaxRGBAI
+)
+
+// FormatSpec formats a color as a color specification of the given syntax.
+// Channels are formatted without alpha premultiplication.  Only SyntaxRGBA
+// and SyntaxRGBAI represent alpha; the other syntaxes ignore it.
+func FormatSpec(c color.Color, syntax Syntax) string {
+	r, g, b, a := nrgba64(c)
+	ch := []uint32{r, g, b}
+	alpha := a != 0xffff && (syntax == SyntaxRGBA || syntax == SyntaxRGBAI)
+	if alpha {
+		ch = append(ch, a)
+	}
+	parts := make([]string, len(ch))
+	switch syntax {
+	case SyntaxRGB, SyntaxRGBA, SyntaxHex:
+		// Use two hexadecimal digits per channel when that suffices
+		// and four otherwise.
+		format := "%02x"
+		for _, v := range ch {
+			if v%0x101 != 0 {
+				format = "%04x"
+				break
+			}
+		}
+		for i, v := range ch {
+			if format == "%02x" {
+				v /= 0x101
+			}
+			parts[i] = fmt.Sprintf(format, v)
+		}
+		switch {
+		case syntax == SyntaxHex:
+			return "#" + strings.Join(parts, "")
+		case alpha:
+			return "rgba:" + strings.Join(parts, "/")
+		default:
+			return "rgb:" + strings.Join(parts, "/")
+		}
+	case SyntaxRGBI, SyntaxRGBAI, SyntaxFraction:
+		for i, v := range ch {
+			parts[i] = strconv.FormatFloat(float64(v)/0xffff, 'f', -1, 32)
+		}
+		switch {
+		case syntax == SyntaxFraction:
+			return strings.Join(parts, ",")
+		case alpha:
+			return "rgbai:" + strings.Join(parts, "/")
+		default:
+			return "rgbi:" + strings.Join(parts, "/")
+		}
+	default:
+		name, _ := ClosestName(c)
+		return name
+	}
+}
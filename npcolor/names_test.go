@@ -0,0 +1,141 @@
+// Test parsing and formatting of color specifications.
+
+package npcolor
+
+import (
+	"image/color"
+	"testing"
+)
+
+// TestParse tests that Parse accepts every supported syntax.
+func TestParse(t *testing.T) {
+	orange := RGBM{R: 255, G: 128, B: 0, M: 255}
+	for _, tc := range []struct {
+		spec string
+		m    uint16
+		exp  color.Color
+	}{
+		{"rgb:ff/80/00", 255, orange},
+		{"RGB:FF/80/0", 255, orange},
+		{"rgb:ffff/8080/0", 255, orange},
+		{"rgb:f/8/0", 255, RGBM{R: 255, G: 136, B: 0, M: 255}},
+		{"rgbi:1.0/0.5/0", 255, orange},
+		{"rgbi:1/0.5/0", 1000, RGBM64{R: 1000, G: 500, B: 0, M: 1000}},
+		{"rgb-255:255/128/0", 255, orange},
+		{"rgb-1000:1000/500/0", 100, RGBM{R: 100, G: 50, B: 0, M: 100}},
+		{"#ff8000", 255, orange},
+		{"#f80", 255, RGBM{R: 255, G: 136, B: 0, M: 255}},
+		{"#fff888000", 4095, RGBM64{R: 4095, G: 2184, B: 0, M: 4095}},
+		{"#ffff80800000", 65535, RGBM64{R: 65535, G: 32896, B: 0, M: 65535}},
+		{"1.0,0.5,0", 255, orange},
+		{" 0.25, 0.5, 0.75 ", 100, RGBM{R: 25, G: 50, B: 75, M: 100}},
+		{"DarkOrange", 255, RGBM{R: 255, G: 140, B: 0, M: 255}},
+		{"dark orange", 255, RGBM{R: 255, G: 140, B: 0, M: 255}},
+		{"darkorange", 65535, RGBM64{R: 65535, G: 140 * 257, B: 0, M: 65535}},
+		{"rgba:ff/80/00/80", 255, RGBAM{R: 255, G: 128, B: 0, A: 128, M: 255}},
+		{"rgbai:1/0.5/0/0.25", 1000, RGBAM64{R: 1000, G: 500, B: 0, A: 250, M: 1000}},
+	} {
+		c, err := Parse(tc.spec, tc.m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != tc.exp {
+			t.Fatalf("Expected %q to parse as %#v but saw %#v", tc.spec, tc.exp, c)
+		}
+	}
+}
+
+// TestParseErrors tests that Parse rejects malformed specifications.
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"rgb:ff/80",
+		"rgb:fffff/0/0",
+		"rgb:xx/0/0",
+		"rgbi:1.5/0/0",
+		"rgbi:-0.1/0/0",
+		"rgb-255:256/0/0",
+		"rgb-0:0/0/0",
+		"#ff80",
+		"#",
+		"0.5,0.5",
+		"no such color",
+		"",
+	} {
+		if c, err := Parse(spec, 255); err == nil {
+			t.Fatalf("Expected %q to be rejected but saw %v", spec, c)
+		}
+	}
+	if _, err := Parse("red", 0); err == nil {
+		t.Fatal("Expected a maximum value of 0 to be rejected")
+	}
+}
+
+// TestClosestName tests that ClosestName finds exact and approximate
+// matches.
+func TestClosestName(t *testing.T) {
+	for _, tc := range []struct {
+		c     color.Color
+		name  string
+		exact bool
+	}{
+		{color.RGBA{R: 255, G: 250, B: 250, A: 255}, "snow", true},
+		{color.RGBA{R: 248, G: 248, B: 255, A: 255}, "ghost white", true},
+		{RGBM{R: 1, G: 0, B: 0, M: 1}, "red", true},
+		{color.RGBA{R: 254, G: 1, B: 0, A: 255}, "red", false},
+		{color.NRGBA{R: 0, G: 0, B: 255, A: 10}, "blue", true},
+	} {
+		name, exact := ClosestName(tc.c)
+		if name != tc.name || exact != tc.exact {
+			t.Fatalf("Expected %v to be named (%q, %v) but saw (%q, %v)", tc.c, tc.name, tc.exact, name, exact)
+		}
+	}
+}
+
+// TestFormatSpec tests that FormatSpec produces each syntax and that Parse
+// accepts the result.
+func TestFormatSpec(t *testing.T) {
+	orange := RGBM{R: 255, G: 128, B: 0, M: 255}
+	odd := RGBM64{R: 1000, G: 500, B: 0, M: 1000}
+	clear := RGBAM{R: 255, G: 128, B: 0, A: 51, M: 255}
+	for _, tc := range []struct {
+		c      color.Color
+		syntax Syntax
+		exp    string
+	}{
+		{orange, SyntaxRGB, "rgb:ff/80/00"},
+		{orange, SyntaxHex, "#ff8000"},
+		{orange, SyntaxRGBI, "rgbi:1/0.5019608/0"},
+		{orange, SyntaxFraction, "1,0.5019608,0"},
+		{orange, SyntaxName, "DarkOrange1"},
+		{odd, SyntaxRGB, "rgb:ffff/8000/0000"},
+		{odd, SyntaxHex, "#ffff80000000"},
+		{orange, SyntaxRGBA, "rgb:ff/80/00"},
+		{clear, SyntaxRGBA, "rgba:ff/80/00/33"},
+		{clear, SyntaxRGBAI, "rgbai:1/0.5019608/0/0.2"},
+		{clear, SyntaxRGB, "rgb:ff/80/00"},
+		{clear, SyntaxRGBI, "rgbi:1/0.5019608/0"},
+		{clear, SyntaxHex, "#ff8000"},
+	} {
+		spec := FormatSpec(tc.c, tc.syntax)
+		if spec != tc.exp {
+			t.Fatalf("Expected %#v to format as %q but saw %q", tc.c, tc.exp, spec)
+		}
+		if tc.syntax == SyntaxName {
+			continue
+		}
+		m := uint16(255)
+		if tc.c == color.Color(odd) {
+			m = 1000
+		}
+		c, err := Parse(spec, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.c == color.Color(clear) && tc.syntax != SyntaxRGBA && tc.syntax != SyntaxRGBAI {
+			continue // Alpha was dropped.
+		}
+		if c != tc.c {
+			t.Fatalf("Expected %q to parse as %#v but saw %#v", spec, tc.c, c)
+		}
+	}
+}
@@ -26,7 +26,9 @@ in that they represent, respectively, 8-bit and 16-bit grayscale
 values.  However, while a color.Gray value has a hard-wired upper
 bound of 255, npcolor.GrayM supports any upper bound from 1–255.
 Likewise, while a color.Gray16 value has a hard-wired upper bound of
-65,535, npcolor.GrayM32 supports any upper bound from 1–65,535.
+65,535, npcolor.GrayM32 supports any upper bound from 1–65,535.  Their
+models additionally select the Luma formula by which colors are
+converted to grayscale.
 
 GrayAM and GrayAM32 have no analogue in the color package.  They
 represent, respectively, 8-bit and 16-bit grayscale values with an
@@ -34,6 +36,26 @@ alpha channel.  Like the other colors represented in this package,
 these support variable maximum channel values.  npcolor.GrayAM
 supports any upper bound from 1–255, and npcolor.GrayM32 supports any
 upper bound from 1–65,535.
+
+GrayF32 and RGBF32 represent, respectively, grayscale and color values
+with a 32-bit floating-point number per channel, as stored in Portable
+Float Map (PFM) files.  Nominal black is 0.0, and nominal white is 1.0,
+but samples may lie outside that range.  Converting a GrayF32 or RGBF32
+to any other color clamps each channel to [0.0, 1.0].
+
+HSV, HSL, YCbCr, XYZ, and Lab represent colors as floating-point
+coordinates in other color spaces.  SpaceM stores such coordinates as
+three samples with a maximum value, as in a PAM file whose tuple type
+names the color space (e.g., "HSV" or "LAB").
+
+A Metric measures the difference between two colors, either as a
+weighted RGB distance or as a CIE ΔE, and a Palette uses a Metric to
+find the nearest color in a color.Palette.
+
+Every color model in this package implements the Model interface, which
+reports the model's maximum value and sample layout.  NewGrayModel,
+NewGrayAlphaModel, NewRGBModel, and NewRGBAlphaModel choose between the
+8-bit and 16-bit models based on a maximum value.
 */
 package npcolor
 
@@ -60,7 +82,8 @@ func (c GrayM) RGBA() (r, g, b, a uint32) {
 
 // A GrayMModel represents the maximum value of a GrayM (0-255).
 type GrayMModel struct {
-	M uint8 // Maximum value of the luminance channel
+	M    uint8 // Maximum value of the luminance channel
+	Luma Luma  // Formula for computing luminance from color
 }
 
 // Convert converts an arbitrary color to a GrayM.
@@ -69,7 +92,7 @@ func (model GrayMModel) Convert(c color.Color) color.Color {
 		return c
 	}
 	r, g, b, _ := c.RGBA()
-	y := (299*r + 587*g + 114*b + 500) / 1000
+	y := model.Luma.Y(r, g, b)
 	m := uint32(model.M)
 	y = (y*m + 0xffff/2) / 0xffff
 	return GrayM{Y: uint8(y), M: uint8(m)}
@@ -94,7 +117,8 @@ func (c GrayM32) RGBA() (r, g, b, a uint32) {
 
 // A GrayM32Model represents the maximum value of a GrayM32 (0-65535).
 type GrayM32Model struct {
-	M uint16 // Maximum value of the luminance channel
+	M    uint16 // Maximum value of the luminance channel
+	Luma Luma   // Formula for computing luminance from color
 }
 
 // Convert converts an arbitrary color to a GrayM32.
@@ -103,7 +127,7 @@ func (model GrayM32Model) Convert(c color.Color) color.Color {
 		return c
 	}
 	r, g, b, _ := c.RGBA()
-	y := (299*r + 587*g + 114*b + 500) / 1000
+	y := model.Luma.Y(r, g, b)
 	m := uint32(model.M)
 	y = (y*m + 0xffff/2) / 0xffff
 	return GrayM32{Y: uint16(y), M: uint16(m)}
@@ -206,7 +230,8 @@ func (c GrayAM) RGBA() (r, g, b, a uint32) {
 
 // A GrayAMModel represents the maximum value of a GrayAM (0-255).
 type GrayAMModel struct {
-	M uint8 // Maximum value of the luminance channel
+	M    uint8 // Maximum value of the luminance channel
+	Luma Luma  // Formula for computing luminance from color
 }
 
 // Convert converts an arbitrary color to a GrayAM.
@@ -214,8 +239,8 @@ func (model GrayAMModel) Convert(c color.Color) color.Color {
 	if gray, ok := c.(GrayAM); ok && gray.M == model.M {
 		return c
 	}
-	r, g, b, a := c.RGBA()
-	y := (299*r + 587*g + 114*b + 500) / 1000
+	r, g, b, a := nrgba64(c)
+	y := model.Luma.Y(r, g, b)
 	m := uint32(model.M)
 	const half = 0xffff / 2
 	y = (y*m + half) / 0xffff
@@ -242,7 +267,8 @@ func (c GrayAM48) RGBA() (r, g, b, a uint32) {
 
 // A GrayAM48Model represents the maximum value of a GrayAM48 (0-65535).
 type GrayAM48Model struct {
-	M uint16 // Maximum value of the luminance channel
+	M    uint16 // Maximum value of the luminance channel
+	Luma Luma   // Formula for computing luminance from color
 }
 
 // Convert converts an arbitrary color to a GrayAM48.
@@ -250,8 +276,8 @@ func (model GrayAM48Model) Convert(c color.Color) color.Color {
 	if gray, ok := c.(GrayAM48); ok && gray.M == model.M {
 		return c
 	}
-	r, g, b, a := c.RGBA()
-	y := (299*r + 587*g + 114*b + 500) / 1000
+	r, g, b, a := nrgba64(c)
+	y := model.Luma.Y(r, g, b)
 	m := uint32(model.M)
 	const half = 0xffff / 2
 	y = (y*m + half) / 0xffff
@@ -289,7 +315,7 @@ func (model RGBAMModel) Convert(c color.Color) color.Color {
 		return c
 	}
 	m := uint32(model.M)
-	r, g, b, a := c.RGBA()
+	r, g, b, a := nrgba64(c)
 	const half = 0xffff / 2
 	r = (r*m + half) / 0xffff
 	g = (g*m + half) / 0xffff
@@ -328,7 +354,7 @@ func (model RGBAM64Model) Convert(c color.Color) color.Color {
 		return c
 	}
 	m := uint32(model.M)
-	r, g, b, a := c.RGBA()
+	r, g, b, a := nrgba64(c)
 	const half = 0xffff / 2
 	r = (r*m + half) / 0xffff
 	g = (g*m + half) / 0xffff
@@ -336,3 +362,161 @@ func (model RGBAM64Model) Convert(c color.Color) color.Color {
 	a = (a*m + half) / 0xffff
 	return RGBAM64{R: uint16(r), G: uint16(g), B: uint16(b), A: uint16(a), M: uint16(m)}
 }
+
+// scale16 scales a channel value from [0, m] to [0, 0xffff].
+func scale16(v, m uint32) uint32 {
+	return (v*0xffff + m/2) / m
+}
+
+// nrgba64 converts an arbitrary color to non-alpha-premultiplied R, G, B,
+// and A, each in the range [0, 0xffff].  Colors that are already
+// non-alpha-premultiplied are converted directly from their channels to avoid
+// the precision lost by premultiplying.
+func nrgba64(c color.Color) (r, g, b, a uint32) {
+	switch c := c.(type) {
+	case RGBAM:
+		if c.M == 0 {
+			return
+		}
+		m := uint32(c.M)
+		return scale16(uint32(c.R), m), scale16(uint32(c.G), m), scale16(uint32(c.B), m), scale16(uint32(c.A), m)
+	case RGBAM64:
+		if c.M == 0 {
+			return
+		}
+		m := uint32(c.M)
+		return scale16(uint32(c.R), m), scale16(uint32(c.G), m), scale16(uint32(c.B), m), scale16(uint32(c.A), m)
+	case GrayAM:
+		if c.M == 0 {
+			return
+		}
+		m := uint32(c.M)
+		y := scale16(uint32(c.Y), m)
+		return y, y, y, scale16(uint32(c.A), m)
+	case GrayAM48:
+		if c.M == 0 {
+			return
+		}
+		m := uint32(c.M)
+		y := scale16(uint32(c.Y), m)
+		return y, y, y, scale16(uint32(c.A), m)
+	case color.NRGBA:
+		return uint32(c.R) * 0x101, uint32(c.G) * 0x101, uint32(c.B) * 0x101, uint32(c.A) * 0x101
+	case color.NRGBA64:
+		return uint32(c.R), uint32(c.G), uint32(c.B), uint32(c.A)
+	default:
+		n := color.NRGBA64Model.Convert(c).(color.NRGBA64)
+		return uint32(n.R), uint32(n.G), uint32(n.B), uint32(n.A)
+	}
+}
+
+// GrayF32 represents a floating-point grayscale value, with 0.0 representing
+// black and 1.0 representing white.  Because GrayF32 does not support alpha
+// channels it does not make sense to describe it as either
+// "alpha-premultiplied" or "non-alpha-premultiplied".
+type GrayF32 struct {
+	Y float32
+}
+
+// RGBA converts a GrayF32 to alpha-premultiplied R, G, B, and A, clamping
+// the gray value to [0.0, 1.0].
+func (c GrayF32) RGBA() (r, g, b, a uint32) {
+	y := clamp16(c.Y)
+	return y, y, y, 0xffff
+}
+
+// A GrayF32Model converts colors to GrayF32 values.
+type GrayF32Model struct{}
+
+// Convert converts an arbitrary color to a GrayF32.  Netpbm colors without
+// alpha are converted exactly from their channels and maximum values.
+func (model GrayF32Model) Convert(c color.Color) color.Color {
+	if _, ok := c.(GrayF32); ok {
+		return c
+	}
+	r, g, b := floats(c)
+	if r == g && g == b {
+		return GrayF32{Y: float32(r)}
+	}
+	return GrayF32{Y: float32(0.299*r + 0.587*g + 0.114*b)}
+}
+
+// RGBF32 represents a floating-point color, with 0.0 representing 0% and 1.0
+// representing 100% of a color channel.  Because RGBF32 does not support
+// alpha channels it does not make sense to describe it as either
+// "alpha-premultiplied" or "non-alpha-premultiplied".
+type RGBF32 struct {
+	R, G, B float32
+}
+
+// RGBA converts an RGBF32 to alpha-premultiplied R, G, B, and A, clamping
+// each channel to [0.0, 1.0].
+func (c RGBF32) RGBA() (r, g, b, a uint32) {
+	return clamp16(c.R), clamp16(c.G), clamp16(c.B), 0xffff
+}
+
+// An RGBF32Model converts colors to RGBF32 values.
+type RGBF32Model struct{}
+
+// Convert converts an arbitrary color to an RGBF32.  Netpbm colors without
+// alpha are converted exactly from their channels and maximum values.
+func (model RGBF32Model) Convert(c color.Color) color.Color {
+	if _, ok := c.(RGBF32); ok {
+		return c
+	}
+	r, g, b := floats(c)
+	return RGBF32{R: float32(r), G: float32(g), B: float32(b)}
+}
+
+// clamp16 clamps a floating-point channel to [0.0, 1.0] and scales it to
+// [0, 0xffff].  NaN maps to 0.
+func clamp16(v float32) uint32 {
+	switch {
+	case v >= 1:
+		return 0xffff
+	case v > 0:
+		return uint32(float64(v)*0xffff + 0.5)
+	default:
+		return 0
+	}
+}
+
+// floats converts an arbitrary color to alpha-premultiplied R, G, and B,
+// each nominally in the range [0.0, 1.0].  Colors with a maximum value and
+// no alpha channel are converted directly from their channels to avoid the
+// precision lost by scaling to 16 bits.
+func floats(c color.Color) (r, g, b float64) {
+	switch c := c.(type) {
+	case GrayF32:
+		return float64(c.Y), float64(c.Y), float64(c.Y)
+	case RGBF32:
+		return float64(c.R), float64(c.G), float64(c.B)
+	case GrayM:
+		if c.M == 0 {
+			return
+		}
+		y := float64(c.Y) / float64(c.M)
+		return y, y, y
+	case GrayM32:
+		if c.M == 0 {
+			return
+		}
+		y := float64(c.Y) / float64(c.M)
+		return y, y, y
+	case RGBM:
+		if c.M == 0 {
+			return
+		}
+		m := float64(c.M)
+		return float64(c.R) / m, float64(c.G) / m, float64(c.B) / m
+	case RGBM64:
+		if c.M == 0 {
+			return
+		}
+		m := float64(c.M)
+		return float64(c.R) / m, float64(c.G) / m, float64(c.B) / m
+	default:
+		r16, g16, b16, _ := c.RGBA()
+		return float64(r16) / 0xffff, float64(g16) / 0xffff, float64(b16) / 0xffff
+	}
+}
@@ -284,6 +284,61 @@ func TestRGBAMPremult(t *testing.T) {
 	}
 }
 
+// TestAlphaConvertUnpremult tests that the alpha models store unpremultiplied
+// channels when converting a translucent color.  Prior to this, they stored
+// alpha-premultiplied channels, which RGBA then premultiplied a second time,
+// darkening the color on every conversion.
+func TestAlphaConvertUnpremult(t *testing.T) {
+	for _, tc := range []struct {
+		model color.Model
+		in    color.Color
+		out   color.Color // Current behavior
+		old   color.Color // Behavior when converting from premultiplied channels
+	}{
+		{
+			RGBAMModel{M: 255},
+			color.NRGBA{200, 100, 50, 128},
+			RGBAM{R: 200, G: 100, B: 50, A: 128, M: 255},
+			RGBAM{R: 100, G: 50, B: 25, A: 128, M: 255},
+		},
+		{
+			RGBAM64Model{M: 1000},
+			color.NRGBA64{0x8000, 0x4000, 0xffff, 0x4000},
+			RGBAM64{R: 500, G: 250, B: 1000, A: 250, M: 1000},
+			RGBAM64{R: 125, G: 63, B: 250, A: 250, M: 1000},
+		},
+		{
+			GrayAMModel{M: 255},
+			color.NRGBA{200, 200, 200, 128},
+			GrayAM{Y: 200, A: 128, M: 255},
+			GrayAM{Y: 100, A: 128, M: 255},
+		},
+		{
+			GrayAM48Model{M: 1000},
+			color.NRGBA{200, 200, 200, 128},
+			GrayAM48{Y: 784, A: 502, M: 1000},
+			GrayAM48{Y: 394, A: 502, M: 1000},
+		},
+	} {
+		c := tc.model.Convert(tc.in)
+		if c != tc.out {
+			t.Fatalf("Expected %v to convert to %v but saw %v (was %v)", tc.in, tc.out, c, tc.old)
+		}
+
+		// The converted color should look like the original.  The
+		// old behavior fails this test.
+		r1, g1, b1, a1 := tc.in.RGBA()
+		for _, c := range []color.Color{tc.out, tc.old} {
+			r2, g2, b2, a2 := c.RGBA()
+			same := colorsNearEqual(color.RGBA64{uint16(r1), uint16(g1), uint16(b1), uint16(a1)},
+				color.RGBA64{uint16(r2), uint16(g2), uint16(b2), uint16(a2)}, 0x200)
+			if same != (c == tc.out) {
+				t.Fatalf("Unexpected RGBA [%d, %d, %d, %d] from %v for %v", r2, g2, b2, a2, c, tc.in)
+			}
+		}
+	}
+}
+
 // TestZeroRGBMToRGBA ensures that a maxval of 0 doesn't inhibit conversion to
 // RGBA.
 func TestZeroRGBMToRGBA(t *testing.T) {
@@ -293,3 +348,89 @@ func TestZeroRGBMToRGBA(t *testing.T) {
 		t.Fatalf("RGBA color [%d, %d, %d, %d] is not an alpha-premultiplied zero", r, g, b, a)
 	}
 }
+
+// TestRGBAMUnpremult tests that converting a translucent color between
+// RGBAM models preserves its unpremultiplied color channels.
+func TestRGBAMUnpremult(t *testing.T) {
+	for i := 0; i < numConversions; i++ {
+		m := rand.Intn(255) + 1 // [1, 255]
+		c := RGBAM{
+			R: uint8(rand.Intn(m + 1)),
+			G: uint8(rand.Intn(m + 1)),
+			B: uint8(rand.Intn(m + 1)),
+			A: uint8(rand.Intn(m) + 1),
+			M: uint8(m),
+		}
+		c64 := RGBAM64Model{M: uint16(m) * 257}.Convert(c).(RGBAM64)
+		c2 := RGBAMModel{M: uint8(m)}.Convert(c64).(RGBAM)
+		if c != c2 {
+			t.Fatalf("Converting %v to %v and back produced %v", c, c64, c2)
+		}
+	}
+}
+
+// TestGrayAMUnpremult tests that converting a translucent color to a GrayAM48
+// preserves its unpremultiplied gray channel.
+func TestGrayAMUnpremult(t *testing.T) {
+	for i := 0; i < numConversions; i++ {
+		m := rand.Intn(255) + 1 // [1, 255]
+		c := GrayAM{
+			Y: uint8(rand.Intn(m + 1)),
+			A: uint8(rand.Intn(m) + 1),
+			M: uint8(m),
+		}
+		c48 := GrayAM48Model{M: uint16(m) * 257}.Convert(c).(GrayAM48)
+		if c48.Y != uint16(c.Y)*257 || c48.A != uint16(c.A)*257 {
+			t.Fatalf("Expected %v to convert to Y=%d, A=%d but saw %v", c, uint16(c.Y)*257, uint16(c.A)*257, c48)
+		}
+	}
+}
+
+// TestNRGBAUnpremult tests that converting a translucent color.NRGBA to an
+// RGBAM preserves its unpremultiplied color channels.
+func TestNRGBAUnpremult(t *testing.T) {
+	for i := 0; i < numConversions; i++ {
+		c := color.NRGBA{
+			R: uint8(rand.Intn(256)),
+			G: uint8(rand.Intn(256)),
+			B: uint8(rand.Intn(256)),
+			A: uint8(rand.Intn(255) + 1),
+		}
+		c2 := RGBAMModel{M: 255}.Convert(c).(RGBAM)
+		if c2.R != c.R || c2.G != c.G || c2.B != c.B || c2.A != c.A {
+			t.Fatalf("Expected %v to convert to the same channel values but saw %v", c, c2)
+		}
+	}
+}
+
+// TestFloatConvert tests that Netpbm colors without alpha convert exactly to
+// floating-point colors and that floating-point colors clamp when converted
+// to other colors.
+func TestFloatConvert(t *testing.T) {
+	for i := 0; i < numConversions; i++ {
+		m := rand.Intn(65535) + 1 // [1, 65535]
+		c := RGBM64{
+			R: uint16(rand.Intn(m + 1)),
+			G: uint16(rand.Intn(m + 1)),
+			B: uint16(rand.Intn(m + 1)),
+			M: uint16(m),
+		}
+		f := RGBF32Model{}.Convert(c).(RGBF32)
+		e := RGBF32{
+			R: float32(float64(c.R) / float64(m)),
+			G: float32(float64(c.G) / float64(m)),
+			B: float32(float64(c.B) / float64(m)),
+		}
+		if f != e {
+			t.Fatalf("Expected %v to convert to %v but saw %v", c, e, f)
+		}
+		g := GrayF32Model{}.Convert(GrayM32{Y: c.R, M: c.M}).(GrayF32)
+		if g.Y != e.R {
+			t.Fatalf("Expected Y=%v but saw %v", e.R, g)
+		}
+	}
+	r, g, b, a := RGBF32{R: -0.5, G: 0.5, B: 7.0}.RGBA()
+	if r != 0 || g != 0x8000 || b != 0xffff || a != 0xffff {
+		t.Fatalf("Expected (0, 32768, 65535, 65535) but saw (%d, %d, %d, %d)", r, g, b, a)
+	}
+}
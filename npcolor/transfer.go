@@ -0,0 +1,119 @@
+// This file provides transfer functions, which relate the channel values
+// stored in an image to linear light.
+
+package npcolor
+
+import "math"
+
+// A Transfer is a transfer function, which specifies how color channels are
+// encoded.  Both encoded values and linear-light values are nominally in the
+// range [0.0, 1.0].  Transfer functions are extended to negative values by
+// symmetry, f(-v) = -f(v), and to values greater than 1.0 by their
+// formulas.
+type Transfer interface {
+	ToLinear(v float64) float64   // Convert an encoded channel value to linear light
+	FromLinear(l float64) float64 // Convert a linear-light value to an encoded channel value
+}
+
+// These are the transfer functions most commonly associated with Netpbm
+// images.
+var (
+	// BT709 is the ITU-R BT.709 transfer function, which the Netpbm
+	// specification prescribes for PGM and PPM samples.
+	BT709 Transfer = bt709{}
+
+	// SRGB is the IEC 61966-2-1 sRGB transfer function, which most
+	// images on the web use.
+	SRGB Transfer = srgb{}
+
+	// Linear is the identity transfer function, which represents samples
+	// already proportional to linear light.
+	Linear Transfer = linear{}
+)
+
+// Gamma returns a pure power-law transfer function in which an encoded value
+// v represents linear light v^g.  A g of 1 is equivalent to Linear.
+func Gamma(g float64) Transfer {
+	return gamma(g)
+}
+
+// symmetric applies a function defined on non-negative values to any value
+// by symmetry about the origin.
+func symmetric(v float64, f func(float64) float64) float64 {
+	if v < 0 {
+		return -f(-v)
+	}
+	return f(v)
+}
+
+// bt709 implements the ITU-R BT.709 transfer function.
+type bt709 struct{}
+
+// ToLinear converts a BT.709-encoded value to linear light.
+func (bt709) ToLinear(v float64) float64 {
+	return symmetric(v, func(v float64) float64 {
+		if v < 0.081 {
+			return v / 4.5
+		}
+		return math.Pow((v+0.099)/1.099, 1/0.45)
+	})
+}
+
+// FromLinear converts a linear-light value to a BT.709-encoded value.
+func (bt709) FromLinear(l float64) float64 {
+	return symmetric(l, func(l float64) float64 {
+		if l < 0.018 {
+			return l * 4.5
+		}
+		return 1.099*math.Pow(l, 0.45) - 0.099
+	})
+}
+
+// srgb implements the sRGB transfer function.
+type srgb struct{}
+
+// ToLinear converts an sRGB-encoded value to linear light.
+func (srgb) ToLinear(v float64) float64 {
+	return symmetric(v, func(v float64) float64 {
+		if v <= 0.04045 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	})
+}
+
+// FromLinear converts a linear-light value to an sRGB-encoded value.
+func (srgb) FromLinear(l float64) float64 {
+	return symmetric(l, func(l float64) float64 {
+		if l <= 0.0031308 {
+			return l * 12.92
+		}
+		return 1.055*math.Pow(l, 1/2.4) - 0.055
+	})
+}
+
+// linear implements the identity transfer function.
+type linear struct{}
+
+// ToLinear returns its argument unmodified.
+func (linear) ToLinear(v float64) float64 { return v }
+
+// FromLinear returns its argument unmodified.
+func (linear) FromLinear(l float64) float64 { return l }
+
+// gamma implements a pure power-law transfer function.
+type gamma float64
+
+// ToLinear raises an encoded value to the power g.
+func (g gamma) ToLinear(v float64) float64 {
+	return symmetric(v, func(v float64) float64 {
+		return math.Pow(v, float64(g))
+	})
+}
+
+// FromLinear raises a linear-light value to the power 1/g.
+func (g gamma) FromLinear(l float64) float64 {
+	return symmetric(l, func(l float64) float64 {
+		return math.Pow(l, 1/float64(g))
+	})
+}
@@ -0,0 +1,61 @@
+// Test transfer functions.
+
+package npcolor
+
+import (
+	"math"
+	"testing"
+)
+
+// TestTransferRoundTrip tests that each transfer function's FromLinear
+// inverts its ToLinear.
+func TestTransferRoundTrip(t *testing.T) {
+	for _, tf := range []Transfer{BT709, SRGB, Linear, Gamma(2.2), Gamma(1.0 / 3.0)} {
+		for i := -100; i <= 200; i++ {
+			v := float64(i) / 100
+			if v2 := tf.FromLinear(tf.ToLinear(v)); math.Abs(v2-v) > 1e-12 {
+				t.Fatalf("%#v: Expected %v but saw %v", tf, v, v2)
+			}
+		}
+	}
+}
+
+// TestTransferValues tests each transfer function at a few known points.
+func TestTransferValues(t *testing.T) {
+	for _, tc := range []struct {
+		tf     Transfer
+		v, lin float64
+	}{
+		{BT709, 0.0, 0.0},
+		{BT709, 0.045, 0.01},
+		{BT709, 0.5, 0.2596},
+		{BT709, 1.0, 1.0},
+		{SRGB, 0.02, 0.02 / 12.92},
+		{SRGB, 0.5, 0.2140},
+		{SRGB, 1.0, 1.0},
+		{Linear, 0.3, 0.3},
+		{Gamma(2.2), 0.5, 0.2176},
+		{Gamma(2.2), -0.5, -0.2176},
+	} {
+		if lin := tc.tf.ToLinear(tc.v); math.Abs(lin-tc.lin) > 1e-4 {
+			t.Fatalf("%#v: Expected %v to map to %v but saw %v", tc.tf, tc.v, tc.lin, lin)
+		}
+	}
+}
+
+// TestTransferContinuity tests that the piecewise transfer functions are
+// continuous at their breakpoints.
+func TestTransferContinuity(t *testing.T) {
+	for _, tc := range []struct {
+		tf Transfer
+		v  float64
+	}{
+		{BT709, 0.081},
+		{SRGB, 0.04045},
+	} {
+		lo, hi := tc.tf.ToLinear(tc.v-1e-9), tc.tf.ToLinear(tc.v+1e-9)
+		if math.Abs(hi-lo) > 1e-4 {
+			t.Fatalf("%#v: Discontinuity at %v: %v vs. %v", tc.tf, tc.v, lo, hi)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+// This file provides a black-and-white image type that stores eight pixels
+// per byte, exactly as in a raw PBM raster.
+
+package netpbm
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+// A PackedBW is an in-memory black-and-white image that stores eight pixels
+// per byte, using one eighth the memory of a BW.  Its rows are laid out
+// exactly as in a raw PBM file: 0=white, 1=black, most significant bit first,
+// with each row padded to a whole number of bytes.  Like a BW, its color
+// model is a two-color palette, and a pixel's bit is its palette index.
+type PackedBW struct {
+	// Pix holds the image's pixels as packed bits.  The pixel at (x, y)
+	// is bit 7-n%8 of Pix[(y-Rect.Min.Y)*Stride + n/8], where n is
+	// BitOffset+x-Rect.Min.X.
+	Pix []uint8
+	// Stride is the Pix stride (in bytes) between vertically adjacent
+	// pixels.
+	Stride int
+	// BitOffset is the position (0-7, counting from the most
+	// significant bit) of each row's first pixel within its byte.  It is
+	// nonzero only in subimages that do not begin on a byte boundary.
+	BitOffset int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+	// Palette is the image's palette, normally white followed by black.
+	Palette color.Palette
+}
+
+// ColorModel returns the PackedBW image's palette.
+func (p *PackedBW) ColorModel() color.Model { return p.Palette }
+
+// Bounds returns the domain for which At can return non-zero color.  The
+// bounds do not necessarily contain the point (0, 0).
+func (p *PackedBW) Bounds() image.Rectangle { return p.Rect }
+
+// At returns the color of the pixel at (x, y) as a color.Color.
+// At(Bounds().Min.X, Bounds().Min.Y) returns the upper-left pixel of the grid.
+// At(Bounds().Max.X-1, Bounds().Max.Y-1) returns the lower-right one.
+func (p *PackedBW) At(x, y int) color.Color {
+	if len(p.Palette) == 0 {
+		return nil
+	}
+	return p.Palette[p.ColorIndexAt(x, y)]
+}
+
+// ColorIndexAt returns the bit representing the pixel at (x, y): 0 for white
+// or 1 for black.
+func (p *PackedBW) ColorIndexAt(x, y int) uint8 {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return 0
+	}
+	i := p.PixOffset(x, y)
+	return (p.Pix[i] >> (7 - uint(p.BitOffset+x-p.Rect.Min.X)%8)) & 1
+}
+
+// SetColorIndex sets the pixel at (x, y) to white if index is 0 or black
+// otherwise.
+func (p *PackedBW) SetColorIndex(x, y int, index uint8) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	i := p.PixOffset(x, y)
+	mask := uint8(0x80) >> (uint(p.BitOffset+x-p.Rect.Min.X) % 8)
+	if index == 0 {
+		p.Pix[i] &^= mask
+	} else {
+		p.Pix[i] |= mask
+	}
+}
+
+// PixOffset returns the index of the element of Pix that contains the pixel
+// at (x, y).
+func (p *PackedBW) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (p.BitOffset+x-p.Rect.Min.X)/8
+}
+
+// Set sets the pixel at (x, y) to the palette color nearest a given color.
+func (p *PackedBW) Set(x, y int, c color.Color) {
+	p.SetColorIndex(x, y, uint8(p.Palette.Index(c)))
+}
+
+// RGBA64At returns the color of the pixel at (x, y) as a color.RGBA64.
+func (p *PackedBW) RGBA64At(x, y int) color.RGBA64 {
+	if len(p.Palette) == 0 {
+		return color.RGBA64{}
+	}
+	r, g, b, a := p.Palette[p.ColorIndexAt(x, y)].RGBA()
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
+
+// SetRGBA64 sets the pixel at (x, y) to the palette color nearest a given
+// color, expressed as a color.RGBA64.
+func (p *PackedBW) SetRGBA64(x, y int, c color.RGBA64) {
+	p.Set(x, y, c)
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r.  The returned value shares pixels with the original image.  If
+// r.Min.X does not lie on a byte boundary, the result's BitOffset is nonzero.
+func (p *PackedBW) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to
+	// be inside either r1 or r2 if the intersection is empty. Without
+	// explicitly checking for this, the Pix[i:] expression below can
+	// panic.
+	if r.Empty() {
+		return &PackedBW{Palette: p.Palette}
+	}
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+	return &PackedBW{
+		Pix:       p.Pix[i:],
+		Stride:    p.Stride,
+		BitOffset: (p.BitOffset + r.Min.X - p.Rect.Min.X) % 8,
+		Rect:      r,
+		Palette:   p.Palette,
+	}
+}
+
+// Opaque scans the entire image and reports whether it is fully opaque.
+func (p *PackedBW) Opaque() bool {
+	return true
+}
+
+// MaxValue returns the maximum index value allowed.
+func (p *PackedBW) MaxValue() uint16 {
+	return 1
+}
+
+// Format identifies the image as a PBM image.
+func (p *PackedBW) Format() Format {
+	return PBM
+}
+
+// HasAlpha indicates that there is no alpha channel.
+func (p *PackedBW) HasAlpha() bool {
+	return false
+}
+
+// getBits returns the eight bits of row that start at bit offset off, which
+// may be negative.  Bits that lie outside row are returned as 0.
+func getBits(row []uint8, off int) uint8 {
+	i, shift := off>>3, uint(off&7)
+	var hi, lo uint8
+	if i >= 0 && i < len(row) {
+		hi = row[i]
+	}
+	if i+1 >= 0 && i+1 < len(row) {
+		lo = row[i+1]
+	}
+	if shift == 0 {
+		return hi
+	}
+	return hi<<shift | lo>>(8-shift)
+}
+
+// combine replaces each pixel of p that lies within q's bounds with
+// op(pixel of p, pixel of q), processing eight pixels at a time.  Pixels of p
+// outside q's bounds are left unchanged.
+func (p *PackedBW) combine(q *PackedBW, op func(dst, src uint8) uint8) {
+	r := p.Rect.Intersect(q.Rect)
+	if r.Empty() {
+		return
+	}
+
+	// Modify bits [x0, x1) of each row of p.  Bit b of p corresponds to
+	// bit b+delta of q.
+	x0 := p.BitOffset + r.Min.X - p.Rect.Min.X
+	x1 := p.BitOffset + r.Max.X - p.Rect.Min.X
+	delta := q.BitOffset - p.BitOffset + p.Rect.Min.X - q.Rect.Min.X
+	nb := packedStride(x1)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		dst := p.Pix[p.PixOffset(p.Rect.Min.X, y):][:nb]
+		src := q.Pix[q.PixOffset(q.Rect.Min.X, y):][:packedStride(q.BitOffset+q.Rect.Dx())]
+		for b := x0 / 8; b < nb; b++ {
+			// Construct a mask of the bits in this byte that lie
+			// within [x0, x1).
+			mask := uint8(0xff)
+			if lo := x0 - b*8; lo > 0 {
+				mask >>= uint(lo)
+			}
+			if hi := b*8 + 8 - x1; hi > 0 {
+				mask &= 0xff << uint(hi)
+			}
+			v := op(dst[b], getBits(src, b*8+delta))
+			dst[b] = dst[b]&^mask | v&mask
+		}
+	}
+}
+
+// And sets each pixel of p to black if both it and the corresponding pixel
+// of q are black.  Only pixels in the intersection of the two images' bounds
+// are modified.
+func (p *PackedBW) And(q *PackedBW) {
+	p.combine(q, func(a, b uint8) uint8 { return a & b })
+}
+
+// Or sets each pixel of p to black if either it or the corresponding pixel
+// of q is black.  Only pixels in the intersection of the two images' bounds
+// are modified.
+func (p *PackedBW) Or(q *PackedBW) {
+	p.combine(q, func(a, b uint8) uint8 { return a | b })
+}
+
+// Xor sets each pixel of p to black if exactly one of it and the
+// corresponding pixel of q is black.  Only pixels in the intersection of the
+// two images' bounds are modified.
+func (p *PackedBW) Xor(q *PackedBW) {
+	p.combine(q, func(a, b uint8) uint8 { return a ^ b })
+}
+
+// Not inverts every pixel of p.
+func (p *PackedBW) Not() {
+	p.combine(p, func(a, _ uint8) uint8 { return ^a })
+}
+
+// ToBW converts the image to a BW with the same bounds and palette.
+func (p *PackedBW) ToBW() *BW {
+	bw := &BW{image.NewPaletted(p.Rect, p.Palette)}
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		row := p.Pix[p.PixOffset(p.Rect.Min.X, y):]
+		pix := bw.Pix[bw.PixOffset(p.Rect.Min.X, y):]
+		for x := range pix[:p.Rect.Dx()] {
+			n := p.BitOffset + x
+			pix[x] = (row[n/8] >> (7 - uint(n)%8)) & 1
+		}
+	}
+	return bw
+}
+
+// ToPackedBW converts the image to a PackedBW with the same bounds and
+// palette.  Any nonzero color index is treated as black.
+func (p *BW) ToPackedBW() *PackedBW {
+	rect := p.Bounds()
+	packed := NewPackedBW(rect)
+	packed.Palette = p.Palette
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		pix := p.Pix[p.PixOffset(rect.Min.X, y):]
+		row := packed.Pix[packed.PixOffset(rect.Min.X, y):]
+		for x := range pix[:rect.Dx()] {
+			if pix[x] != 0 {
+				row[x/8] |= 0x80 >> (uint(x) % 8)
+			}
+		}
+	}
+	return packed
+}
+
+// packedStride returns the number of bytes needed to store a row of w
+// pixels.
+func packedStride(w int) int {
+	return (w + 7) / 8
+}
+
+// NewPackedBW returns a new, all-white PackedBW with the given bounds.
+func NewPackedBW(r image.Rectangle) *PackedBW {
+	stride := packedStride(r.Dx())
+	return &PackedBW{
+		Pix:     make([]uint8, stride*r.Dy()),
+		Stride:  stride,
+		Rect:    r,
+		Palette: NewBW(image.ZR).Palette,
+	}
+}
+
+// DecodePackedBW reads a PBM image into a PackedBW.  Raw PBM rasters are read
+// directly into the image's pixels with no conversion.  Plain PBM images are
+// decoded with Decode and then packed.  Any other format is an error.
+func DecodePackedBW(r io.Reader) (*PackedBW, error) {
+	// Plain PBM images must be parsed.
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	magic, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	switch string(magic) {
+	case "P1":
+		img, err := Decode(br, nil)
+		if err != nil {
+			return nil, err
+		}
+		return img.(*BW).ToPackedBW(), nil
+	case "P4":
+	default:
+		return nil, errors.New("Only PBM images can be decoded as packed bits")
+	}
+
+	// Read the header.
+	nr := newNetpbmReader(br)
+	header, err := nr.GetHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the raster directly into the image.
+	img := NewPackedBW(image.Rect(0, 0, header.Width, header.Height))
+	if _, err := io.ReadFull(nr, img.Pix); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			err = errors.New("Failed to read binary PBM data")
+		}
+		return nil, err
+	}
+	return img, nil
+}
+
+// encodePackedBWData writes a PackedBW's raster to a raw PBM file.  p must
+// have a two-color palette.  Each bit is written as the PBM bit for the
+// nearer of white and black to its palette color, and each row's padding bits
+// are written as zeros.  An image with the standard palette whose rows are
+// stored contiguously is written directly from its pixels.
+func encodePackedBWData(w io.Writer, p *PackedBW) error {
+	// Determine the masks m0 and m1 that map each byte b of pixels to
+	// b&m1 | ^b&m0.
+	var m0, m1 uint8
+	cm := NewBW(image.ZR).Palette
+	if cm.Index(p.Palette[0]) != 0 {
+		m0 = 0xff
+	}
+	if cm.Index(p.Palette[1]) != 0 {
+		m1 = 0xff
+	}
+
+	// Write the raster.
+	width := p.Rect.Dx()
+	nb := packedStride(width)
+	if m0 == 0 && m1 == 0xff && p.BitOffset == 0 && p.Stride == nb && width%8 == 0 {
+		// Write the entire raster at once.
+		_, err := w.Write(p.Pix[:nb*p.Rect.Dy()])
+		return err
+	}
+	wb := bufio.NewWriter(w)
+	buf := make([]uint8, nb)
+	pad := uint8(0xff) << uint(nb*8-width)
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y && nb > 0; y++ {
+		row := p.Pix[p.PixOffset(p.Rect.Min.X, y):][:packedStride(p.BitOffset+width)]
+		for i := range buf {
+			b := getBits(row, p.BitOffset+i*8)
+			buf[i] = b&m1 | ^b&m0
+		}
+		buf[nb-1] &= pad
+		wb.Write(buf)
+	}
+	return wb.Flush()
+}
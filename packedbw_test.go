@@ -0,0 +1,243 @@
+// Test bit-packed black-and-white images.
+
+package netpbm
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// randomBW returns a BW image of the given bounds with random pixels.
+func randomBW(r image.Rectangle, seed int64) *BW {
+	rng := rand.New(rand.NewSource(seed))
+	bw := NewBW(r)
+	for i := range bw.Pix {
+		bw.Pix[i] = uint8(rng.Intn(2))
+	}
+	return bw
+}
+
+// TestPackedBWConvert confirms that converting between BW and PackedBW
+// preserves every pixel and that a PackedBW is stored like a raw PBM raster.
+func TestPackedBWConvert(t *testing.T) {
+	bw := randomBW(image.Rect(3, 2, 22, 9), 1)
+	packed := bw.ToPackedBW()
+	if packed.Stride != 3 || len(packed.Pix) != 3*7 {
+		t.Fatalf("Expected stride 3 and 21 bytes but saw %d and %d", packed.Stride, len(packed.Pix))
+	}
+	for y := 2; y < 9; y++ {
+		for x := 3; x < 22; x++ {
+			if packed.At(x, y) != bw.At(x, y) {
+				t.Fatalf("Expected %v at (%d, %d) but saw %v", bw.At(x, y), x, y, packed.At(x, y))
+			}
+		}
+	}
+	if bw2 := packed.ToBW(); !reflect.DeepEqual(bw, bw2) {
+		t.Fatalf("Expected %v but saw %v", bw, bw2)
+	}
+
+	// The raster should match the one in a raw PBM file.
+	var w bytes.Buffer
+	if err := Encode(&w, bw, nil); err != nil {
+		t.Fatal(err)
+	}
+	if pbm := w.Bytes(); !bytes.HasSuffix(pbm, packed.Pix) {
+		t.Fatalf("Expected raster %v in %v", packed.Pix, pbm)
+	}
+}
+
+// TestPackedBWOps confirms that bitwise operations match their pixel-by-pixel
+// definitions, including when the images are not byte-aligned with each
+// other.
+func TestPackedBWOps(t *testing.T) {
+	ops := []struct {
+		name  string
+		apply func(p, q *PackedBW)
+		pixel func(a, b uint8) uint8
+	}{
+		{"And", (*PackedBW).And, func(a, b uint8) uint8 { return a & b }},
+		{"Or", (*PackedBW).Or, func(a, b uint8) uint8 { return a | b }},
+		{"Xor", (*PackedBW).Xor, func(a, b uint8) uint8 { return a ^ b }},
+	}
+	a := randomBW(image.Rect(0, 0, 29, 4), 2)
+	for _, qr := range []image.Rectangle{
+		image.Rect(0, 0, 29, 4),
+		image.Rect(5, 1, 20, 3),
+		image.Rect(-3, -1, 40, 10),
+		image.Rect(11, 0, 35, 4),
+	} {
+		b := randomBW(qr, 3)
+		for _, op := range ops {
+			p, q := a.ToPackedBW(), b.ToPackedBW()
+			op.apply(p, q)
+			for y := 0; y < 4; y++ {
+				for x := 0; x < 29; x++ {
+					e := a.ColorIndexAt(x, y)
+					if (image.Point{x, y}).In(qr) {
+						e = op.pixel(e, b.ColorIndexAt(x, y))
+					}
+					if v := p.ColorIndexAt(x, y); v != e {
+						t.Fatalf("%s with %v: expected %d at (%d, %d) but saw %d", op.name, qr, e, x, y, v)
+					}
+				}
+			}
+		}
+	}
+
+	// Not should invert every pixel but leave the padding alone.
+	p := a.ToPackedBW()
+	p.Not()
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 29; x++ {
+			if p.ColorIndexAt(x, y) == a.ColorIndexAt(x, y) {
+				t.Fatalf("Expected pixel (%d, %d) to be inverted", x, y)
+			}
+		}
+		if pad := p.Pix[p.PixOffset(28, y)] & 0x07; pad != 0 {
+			t.Fatalf("Expected padding bits to remain 0 but saw %03b", pad)
+		}
+	}
+}
+
+// TestPackedBWSubImage confirms that subimages share pixels with their
+// parent, whether or not they begin on a byte boundary.
+func TestPackedBWSubImage(t *testing.T) {
+	bw := randomBW(image.Rect(0, 0, 30, 5), 4)
+	for _, r := range []image.Rectangle{
+		image.Rect(8, 1, 25, 4),
+		image.Rect(3, 1, 25, 4),
+		image.Rect(13, 0, 14, 5),
+		image.Rect(5, 2, 29, 3),
+	} {
+		p := bw.ToPackedBW()
+		sub := p.SubImage(r).(*PackedBW)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				if sub.ColorIndexAt(x, y) != p.ColorIndexAt(x, y) {
+					t.Fatalf("Subimage %v differs at (%d, %d)", r, x, y)
+				}
+			}
+		}
+
+		// Inverting the subimage, including a subimage of it,
+		// should invert exactly the corresponding pixels of its
+		// parent.
+		sub.Not()
+		sub.SubImage(r.Inset(1)).(*PackedBW).Not()
+		for y := 0; y < 5; y++ {
+			for x := 0; x < 30; x++ {
+				pt := image.Pt(x, y)
+				inv := pt.In(r) && !pt.In(r.Inset(1))
+				if (p.ColorIndexAt(x, y) != bw.ColorIndexAt(x, y)) != inv {
+					t.Fatalf("Expected inversion of (%d, %d) through subimage %v to be %v", x, y, r, inv)
+				}
+			}
+		}
+
+		// Converting and encoding the subimage should treat it
+		// like any other image.
+		ref := NewBW(r)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				ref.SetColorIndex(x, y, p.ColorIndexAt(x, y))
+			}
+		}
+		if got := sub.ToBW(); !reflect.DeepEqual(got, ref) {
+			t.Fatalf("Expected %v but saw %v", ref, got)
+		}
+		var w1, w2 bytes.Buffer
+		if err := Encode(&w1, ref, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := Encode(&w2, sub, nil); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(w1.Bytes(), w2.Bytes()) {
+			t.Fatalf("Expected %q but saw %q", w1.Bytes(), w2.Bytes())
+		}
+	}
+}
+
+// TestPackedBWPalette confirms that encoding a PackedBW maps its bits to PBM
+// bits according to its palette.
+func TestPackedBWPalette(t *testing.T) {
+	white, black := color.Gray{255}, color.Gray{0}
+	for _, r := range []image.Rectangle{image.Rect(0, 0, 16, 3), image.Rect(0, 0, 13, 3)} {
+		for _, pal := range []color.Palette{
+			{black, white},
+			{white, white},
+			{black, black},
+			{color.RGBA{255, 0, 0, 255}, color.RGBA{255, 255, 200, 255}},
+		} {
+			bw := randomBW(r, 6)
+			bw.Palette = pal
+			packed := bw.ToPackedBW()
+			var w1, w2 bytes.Buffer
+			if err := Encode(&w1, bw, nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := Encode(&w2, packed, nil); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(w1.Bytes(), w2.Bytes()) {
+				t.Fatalf("Expected %q but saw %q with palette %v", w1.Bytes(), w2.Bytes(), pal)
+			}
+		}
+	}
+}
+
+// TestPackedBWEncodeDecode confirms that PackedBW images round-trip through
+// raw and plain PBM files.
+func TestPackedBWEncodeDecode(t *testing.T) {
+	bw := randomBW(image.Rect(0, 0, 21, 6), 5)
+	packed := bw.ToPackedBW()
+	for _, plain := range []bool{false, true} {
+		// Encoding a PackedBW should produce the same file as
+		// encoding a BW.
+		var w1, w2 bytes.Buffer
+		opts := &EncodeOptions{Plain: plain}
+		if err := Encode(&w1, bw, opts); err != nil {
+			t.Fatal(err)
+		}
+		if err := Encode(&w2, packed, opts); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(w1.Bytes(), w2.Bytes()) {
+			t.Fatalf("Expected %q but saw %q", w1.Bytes(), w2.Bytes())
+		}
+
+		// Decoding should reproduce the image.
+		img, err := DecodePackedBW(&w2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(img, packed) {
+			t.Fatalf("Expected %v but saw %v", packed, img)
+		}
+	}
+
+	// Unaligned subimages should be encoded with zero padding.
+	sub := packed.SubImage(image.Rect(0, 1, 13, 5))
+	var w1, w2 bytes.Buffer
+	if err := Encode(&w1, bw.SubImage(sub.Bounds()), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := Encode(&w2, sub, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w1.Bytes(), w2.Bytes()) {
+		t.Fatalf("Expected %q but saw %q", w1.Bytes(), w2.Bytes())
+	}
+
+	// Other formats and truncated files should be rejected.
+	if _, err := DecodePackedBW(bytes.NewReader([]byte("P5 1 1 255\n\x00"))); err == nil {
+		t.Fatal("Decoding a PGM file as packed bits unexpectedly succeeded")
+	}
+	if _, err := DecodePackedBW(bytes.NewReader([]byte("P4 9 2\n\x00\x00"))); err == nil {
+		t.Fatal("Decoding a truncated PBM file unexpectedly succeeded")
+	}
+}
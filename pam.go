@@ -10,7 +10,6 @@ import (
 	"image/color"
 	"io"
 	"strconv"
-	"strings"
 
 	"github.com/spakin/netpbm/npcolor"
 )
@@ -26,6 +25,7 @@ const (
 	pamGrayscaleAlpha
 	pamColor
 	pamColorAlpha
+	pamColorSpace
 )
 
 // ttToInt maps a PAM tuple type from a string to an integer.
@@ -38,6 +38,28 @@ var ttToInt = map[string]pamTupleType{
 	"RGB_ALPHA":           pamColorAlpha,
 }
 
+// Map the tuple type of each non-RGB color space to pamColorSpace.
+func init() {
+	for s := npcolor.SpaceHSV; s.TupleType() != ""; s++ {
+		ttToInt[s.TupleType()] = pamColorSpace
+	}
+}
+
+// pamStorageType maps a PAM tuple type to the tuple type used to represent it
+// in memory.  A PAM black-and-white tuple, unlike a PBM pixel, is stored as a
+// sample with 0=black and 1=white.  It therefore has the same representation
+// as a grayscale tuple with a maximum value of 1.
+func pamStorageType(ttype pamTupleType) pamTupleType {
+	switch ttype {
+	case pamBlackAndWhite:
+		return pamGrayscale
+	case pamBlackAndWhiteAlpha:
+		return pamGrayscaleAlpha
+	default:
+		return ttype
+	}
+}
+
 // A RGBAM is an in-memory image whose At method returns npcolor.RGBAM
 // values.
 type RGBAM struct {
@@ -118,6 +140,24 @@ func (p *RGBAM) SetRGBAM(x, y int, c npcolor.RGBAM) {
 	}
 }
 
+// RGBA64At returns the color of the pixel at (x, y) as a color.RGBA64.
+func (p *RGBAM) RGBA64At(x, y int) color.RGBA64 {
+	r, g, b, a := p.RGBAMAt(x, y).RGBA()
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
+
+// SetRGBA64 sets the pixel at (x, y) to a given color, expressed as a
+// color.RGBA64.
+func (p *RGBAM) SetRGBA64(x, y int, c color.RGBA64) {
+	m := uint32(p.Model.M)
+	r, g, b, a := unpremultiply(c)
+	r = scaleRGBA64(r, m)
+	g = scaleRGBA64(g, m)
+	b = scaleRGBA64(b, m)
+	a = scaleRGBA64(a, m)
+	p.SetRGBAM(x, y, npcolor.RGBAM{R: uint8(r), G: uint8(g), B: uint8(b), A: uint8(a), M: p.Model.M})
+}
+
 // SubImage returns an image representing the portion of the image p visible
 // through r. The returned value shares pixels with the original image.
 func (p *RGBAM) SubImage(r image.Rectangle) image.Image {
@@ -127,13 +167,14 @@ func (p *RGBAM) SubImage(r image.Rectangle) image.Image {
 	// explicitly checking for this, the Pix[i:] expression below can
 	// panic.
 	if r.Empty() {
-		return &RGBAM{}
+		return &RGBAM{Model: p.Model}
 	}
 	i := p.PixOffset(r.Min.X, r.Min.Y)
 	return &RGBAM{
 		Pix:    p.Pix[i:],
 		Stride: p.Stride,
 		Rect:   r,
+		Model:  p.Model,
 	}
 }
 
@@ -170,6 +211,25 @@ func (p *RGBAM) HasAlpha() bool {
 	return true
 }
 
+// DemoteToGrayAM generates an 8-bit grayscale-plus-alpha image from the given
+// color image.  Each gray level is computed from the red, green, and blue
+// samples using luma formula l, and the result's model uses the same
+// formula.  The alpha channel is copied unmodified.
+func (p *RGBAM) DemoteToGrayAM(l npcolor.Luma) *GrayAM {
+	gray := NewGrayAM(p.Rect, p.Model.M)
+	gray.Model.Luma = l
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		src := p.Pix[p.PixOffset(p.Rect.Min.X, y):]
+		dst := gray.Pix[gray.PixOffset(p.Rect.Min.X, y):]
+		for x := 0; x < p.Rect.Dx(); x++ {
+			s := src[x*4 : x*4+4]
+			dst[x*2] = uint8(l.Y(uint32(s[0]), uint32(s[1]), uint32(s[2])))
+			dst[x*2+1] = s[3]
+		}
+	}
+	return gray
+}
+
 // NewRGBAM returns a new RGBAM with the given bounds and maximum channel
 // value.
 func NewRGBAM(r image.Rectangle, m uint8) *RGBAM {
@@ -268,6 +328,24 @@ func (p *RGBAM64) SetRGBAM64(x, y int, c npcolor.RGBAM64) {
 	}
 }
 
+// RGBA64At returns the color of the pixel at (x, y) as a color.RGBA64.
+func (p *RGBAM64) RGBA64At(x, y int) color.RGBA64 {
+	r, g, b, a := p.RGBAM64At(x, y).RGBA()
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
+
+// SetRGBA64 sets the pixel at (x, y) to a given color, expressed as a
+// color.RGBA64.
+func (p *RGBAM64) SetRGBA64(x, y int, c color.RGBA64) {
+	m := uint32(p.Model.M)
+	r, g, b, a := unpremultiply(c)
+	r = scaleRGBA64(r, m)
+	g = scaleRGBA64(g, m)
+	b = scaleRGBA64(b, m)
+	a = scaleRGBA64(a, m)
+	p.SetRGBAM64(x, y, npcolor.RGBAM64{R: uint16(r), G: uint16(g), B: uint16(b), A: uint16(a), M: p.Model.M})
+}
+
 // SubImage returns an image representing the portion of the image p visible
 // through r. The returned value shares pixels with the original image.
 func (p *RGBAM64) SubImage(r image.Rectangle) image.Image {
@@ -277,13 +355,14 @@ func (p *RGBAM64) SubImage(r image.Rectangle) image.Image {
 	// explicitly checking for this, the Pix[i:] expression below can
 	// panic.
 	if r.Empty() {
-		return &RGBAM64{}
+		return &RGBAM64{Model: p.Model}
 	}
 	i := p.PixOffset(r.Min.X, r.Min.Y)
 	return &RGBAM64{
 		Pix:    p.Pix[i:],
 		Stride: p.Stride,
 		Rect:   r,
+		Model:  p.Model,
 	}
 }
 
@@ -320,6 +399,31 @@ func (p *RGBAM64) HasAlpha() bool {
 	return true
 }
 
+// DemoteToGrayAM48 generates a 16-bit grayscale-plus-alpha image from the
+// given color image.  Each gray level is computed from the red, green, and
+// blue samples using luma formula l, and the result's model uses the same
+// formula.  The alpha channel is copied unmodified.
+func (p *RGBAM64) DemoteToGrayAM48(l npcolor.Luma) *GrayAM48 {
+	gray := NewGrayAM48(p.Rect, p.Model.M)
+	gray.Model.Luma = l
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		src := p.Pix[p.PixOffset(p.Rect.Min.X, y):]
+		dst := gray.Pix[gray.PixOffset(p.Rect.Min.X, y):]
+		for x := 0; x < p.Rect.Dx(); x++ {
+			s := src[x*8 : x*8+8]
+			r := uint32(s[0])<<8 | uint32(s[1])
+			g := uint32(s[2])<<8 | uint32(s[3])
+			b := uint32(s[4])<<8 | uint32(s[5])
+			v := l.Y(r, g, b)
+			dst[x*4] = uint8(v >> 8)
+			dst[x*4+1] = uint8(v)
+			dst[x*4+2] = s[6]
+			dst[x*4+3] = s[7]
+		}
+	}
+	return gray
+}
+
 // NewRGBAM64 returns a new RGBAM64 with the given bounds and maximum
 // channel value.
 func NewRGBAM64(r image.Rectangle, m uint16) *RGBAM64 {
@@ -330,15 +434,15 @@ func NewRGBAM64(r image.Rectangle, m uint16) *RGBAM64 {
 }
 
 // GetPamHeader parses the entire header of a PAM file (raw or
-// plain) and returns it as a netpbmHeader (plus a success value).
-func (nr *netpbmReader) GetPamHeader() (netpbmHeader, bool) {
-	var header netpbmHeader
+// plain) and returns it as a Header (plus a success value).
+func (nr *netpbmReader) GetPamHeader() (Header, bool) {
+	var header Header
 
 	// Read the magic value and skip the following whitespace.
 	var ok bool
 	header.Magic, ok = nr.getMagic('7', '7')
 	if !ok {
-		return netpbmHeader{}, false
+		return Header{}, false
 	}
 
 	// Process each line in turn.
@@ -347,13 +451,13 @@ ReadLoop:
 		// Read a line.
 		kv := nr.GetLineAsKeyValue()
 		if nr.Err() != nil {
-			return netpbmHeader{}, false
+			return Header{}, false
 		}
 		if len(kv) == 0 {
 			continue
 		}
 		if len(kv) == 1 && kv[0] != "ENDHDR" {
-			return netpbmHeader{}, false
+			return Header{}, false
 		}
 
 		// Parse the line.
@@ -377,15 +481,18 @@ ReadLoop:
 			header.TupleType += v
 		case "#":
 			header.Comments = append(header.Comments, v)
+		case "PLAIN":
+			// Nonstandard: The raster is ASCII, not binary.
+			header.Plain = true
 		default:
-			return netpbmHeader{}, false
+			return Header{}, false
 		}
 		if err != nil {
-			return netpbmHeader{}, false
+			return Header{}, false
 		}
 	}
 	if header.Maxval < 1 || header.Maxval > 65535 {
-		return netpbmHeader{}, false
+		return Header{}, false
 	}
 
 	// Return the header and a success code.
@@ -421,6 +528,12 @@ func decodeConfigPAMWithComments(r io.Reader) (image.Config, []string, error) {
 	if !ok {
 		return image.Config{}, nil, fmt.Errorf("Unsupported tuple type %q", header.TupleType)
 	}
+	ttype = pamStorageType(ttype)
+	if ttype == pamColorSpace {
+		s, _ := npcolor.SpaceFromTupleType(header.TupleType)
+		cfg.ColorModel = npcolor.SpaceMModel{Space: s, M: uint16(header.Maxval)}
+		return cfg, header.Comments, nil
+	}
 	if header.Maxval < 256 {
 		switch ttype {
 		case pamColorAlpha:
@@ -431,15 +544,6 @@ func decodeConfigPAMWithComments(r io.Reader) (image.Config, []string, error) {
 			cfg.ColorModel = npcolor.GrayAMModel{M: uint8(header.Maxval)}
 		case pamGrayscale:
 			cfg.ColorModel = npcolor.GrayMModel{M: uint8(header.Maxval)}
-		case pamBlackAndWhiteAlpha:
-			// TODO: Implement BW + alpha
-			panic("Black & white + alpha is not currently supported")
-		case pamBlackAndWhite:
-			// Define a color map with 0=black and 1=white.
-			colorMap := make(color.Palette, 2)
-			colorMap[0] = color.RGBA{0, 0, 0, 255}
-			colorMap[1] = color.RGBA{255, 255, 255, 255}
-			cfg.ColorModel = colorMap
 		default:
 			panic(fmt.Sprintf("Internal error processing tuple type %q", header.TupleType))
 		}
@@ -453,15 +557,6 @@ func decodeConfigPAMWithComments(r io.Reader) (image.Config, []string, error) {
 			cfg.ColorModel = npcolor.GrayAM48Model{M: uint16(header.Maxval)}
 		case pamGrayscale:
 			cfg.ColorModel = npcolor.GrayM32Model{M: uint16(header.Maxval)}
-		case pamBlackAndWhiteAlpha:
-			// TODO: Implement BW + alpha
-			panic("Black & white + alpha is not currently supported")
-		case pamBlackAndWhite:
-			// Define a color map with 0=black and 1=white.
-			colorMap := make(color.Palette, 2)
-			colorMap[0] = color.RGBA{0, 0, 0, 255}
-			colorMap[1] = color.RGBA{255, 255, 255, 255}
-			cfg.ColorModel = colorMap
 		default:
 			panic(fmt.Sprintf("Internal error processing tuple type %q", header.TupleType))
 		}
@@ -476,90 +571,65 @@ func decodeConfigPAM(r io.Reader) (image.Config, error) {
 }
 
 // decodePAMWithComments reads a complete PAM image.  Unlike decodePAM, it also
-// returns any comments appearing in the file.
-func decodePAMWithComments(r io.Reader) (image.Image, []string, error) {
-	// Read the image header, and use it to prepare a color image.
-	br := bufio.NewReader(r)
-	config, comments, err := decodeConfigPAMWithComments(br)
-	if err != nil {
+// returns any comments appearing in the file.  Nonstandard plain PAM images
+// are rejected unless allowPlain is true.
+func decodePAMWithComments(r io.Reader, allowPlain bool) (image.Image, []string, error) {
+	// Read the image header.
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	nr := newNetpbmReader(br)
+	header, ok := nr.GetPamHeader()
+	if !ok {
+		err := nr.Err()
+		if err == nil {
+			err = errors.New("Invalid PAM header")
+		}
+		return nil, nil, err
+	}
+	if err := checkPlainPAM(header, allowPlain); err != nil {
 		return nil, nil, err
 	}
 
 	// Create an appropriate image type.
-	var img image.Image // Image to return
-	var data []uint8    // RGB (no M) image data
-	var maxVal uint     // 100% white value
-	switch model := config.ColorModel.(type) {
-	case npcolor.RGBAMModel:
-		maxVal = uint(model.M)
-		pImg := NewRGBAM(image.Rect(0, 0, config.Width, config.Height), uint8(maxVal))
-		data = pImg.Pix
-		img = pImg
-
-	case npcolor.RGBMModel:
-		maxVal = uint(model.M)
-		pImg := NewRGBM(image.Rect(0, 0, config.Width, config.Height), uint8(maxVal))
-		data = pImg.Pix
-		img = pImg
-
-	case npcolor.GrayAMModel:
-		maxVal = uint(model.M)
-		pImg := NewGrayAM(image.Rect(0, 0, config.Width, config.Height), uint8(maxVal))
-		data = pImg.Pix
-		img = pImg
-
-	case npcolor.GrayMModel:
-		maxVal = uint(model.M)
-		pImg := NewGrayM(image.Rect(0, 0, config.Width, config.Height), uint8(maxVal))
-		data = pImg.Pix
-		img = pImg
-
-	case npcolor.RGBAM64Model:
-		maxVal = uint(model.M)
-		pImg := NewRGBAM64(image.Rect(0, 0, config.Width, config.Height), uint16(maxVal))
-		data = pImg.Pix
-		img = pImg
-
-	case npcolor.RGBM64Model:
-		maxVal = uint(model.M)
-		pImg := NewRGBM64(image.Rect(0, 0, config.Width, config.Height), uint16(maxVal))
-		data = pImg.Pix
-		img = pImg
-
-	case npcolor.GrayAM48Model:
-		maxVal = uint(model.M)
-		pImg := NewGrayAM48(image.Rect(0, 0, config.Width, config.Height), uint16(maxVal))
-		data = pImg.Pix
-		img = pImg
-
-	case npcolor.GrayM32Model:
-		maxVal = uint(model.M)
-		pImg := NewGrayM32(image.Rect(0, 0, config.Width, config.Height), uint16(maxVal))
-		data = pImg.Pix
-		img = pImg
+	rect := image.Rect(0, 0, header.Width, header.Height)
+	img, err := imageFromHeader(header, rect, nil)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	default:
-		panic("Unexpected color model")
+	// Plain PAM images must be parsed.
+	if header.Plain {
+		rr, err := newRasterReader(nr, header)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err = readRegion(rr, img, rect); err != nil {
+			return nil, nil, err
+		}
+		return img, header.Comments, nil
 	}
 
-	// PAM images are nice because we can read directly into the image
+	// Raw PAM images are nice because we can read directly into the image
 	// data.
+	data := pixSlice(img)
 	for len(data) > 0 {
 		nRead, err := br.Read(data)
 		if err != nil && err != io.EOF {
 			return img, nil, err
 		}
 		if nRead == 0 {
-			return img, nil, errors.New("Failed to read binary PPM data")
+			return img, nil, errors.New("Failed to read binary PAM data")
 		}
 		data = data[nRead:]
 	}
-	return img, comments, nil
+	return img, header.Comments, nil
 }
 
 // decodePAM reads a complete PAM image.
 func decodePAM(r io.Reader) (image.Image, error) {
-	img, _, err := decodePAMWithComments(r)
+	img, _, err := decodePAMWithComments(r, false)
 	return img, err
 }
 
@@ -579,7 +649,7 @@ func encodePAM(w io.Writer, img image.Image, opts *EncodeOptions) error {
 	switch ttype {
 	case pamColorAlpha:
 		depth = 4
-	case pamColor:
+	case pamColor, pamColorSpace:
 		depth = 3
 	case pamGrayscaleAlpha:
 		depth = 2
@@ -593,24 +663,36 @@ func encodePAM(w io.Writer, img image.Image, opts *EncodeOptions) error {
 		panic(fmt.Sprintf("Internal error processing tuple type %q", opts.TupleType))
 	}
 
-	// Write the PAM header.
-	fmt.Fprintln(w, "P7")
-	for _, cmt := range opts.Comments {
-		cmt = strings.Replace(cmt, "\n", " ", -1)
-		cmt = strings.Replace(cmt, "\r", " ", -1)
-		fmt.Fprintf(w, "# %s\n", cmt)
+	// Black-and-white tuples always have a maximum value of 1 and are
+	// otherwise written like grayscale tuples.
+	if ttype == pamBlackAndWhite || ttype == pamBlackAndWhiteAlpha {
+		o := *opts
+		o.MaxValue = 1
+		opts = &o
+		ttype = pamStorageType(ttype)
 	}
+
+	// Write the PAM header.
 	rect := img.Bounds()
-	width := rect.Max.X - rect.Min.X
-	height := rect.Max.Y - rect.Min.Y
-	fmt.Fprintf(w, "WIDTH %d\n", width)
-	fmt.Fprintf(w, "HEIGHT %d\n", height)
-	fmt.Fprintf(w, "DEPTH %d\n", depth)
-	fmt.Fprintf(w, "MAXVAL %d\n", opts.MaxValue)
-	fmt.Fprintf(w, "TUPLTYPE %s\n", opts.TupleType)
-	fmt.Fprintf(w, "ENDHDR\n")
+	header := Header{
+		Magic:     "P7",
+		Width:     rect.Max.X - rect.Min.X,
+		Height:    rect.Max.Y - rect.Min.Y,
+		Depth:     depth,
+		Maxval:    int(opts.MaxValue),
+		TupleType: opts.TupleType,
+		Comments:  opts.Comments,
+		Plain:     opts.Plain,
+	}
+	if err := writeHeader(w, header, opts); err != nil {
+		return err
+	}
 
 	// Write the PAM data.
+	if ttype == pamColorSpace {
+		s, _ := npcolor.SpaceFromTupleType(opts.TupleType)
+		return encodeSpaceData(w, img, opts, s)
+	}
 	if opts.MaxValue < 256 {
 		switch ttype {
 		case pamColorAlpha:
@@ -618,15 +700,9 @@ func encodePAM(w io.Writer, img image.Image, opts *EncodeOptions) error {
 		case pamColor:
 			return encodeRGBData(w, img, opts)
 		case pamGrayscaleAlpha:
-			// TODO: Implement grayscale + alpha
-			panic("Grayscale + alpha is not currently supported")
+			return encodeGrayAData(w, img, opts)
 		case pamGrayscale:
 			return encodeGrayData(w, img, opts)
-		case pamBlackAndWhiteAlpha:
-			// TODO: Implement BW + alpha
-			panic("Black & white + alpha is not currently supported")
-		case pamBlackAndWhite:
-			return encodeBWData(w, img, opts)
 		default:
 			panic(fmt.Sprintf("Internal error processing tuple type %q", opts.TupleType))
 		}
@@ -637,15 +713,9 @@ func encodePAM(w io.Writer, img image.Image, opts *EncodeOptions) error {
 		case pamColor:
 			return encodeRGB64Data(w, img, opts)
 		case pamGrayscaleAlpha:
-			// TODO: Implement 16-bit grayscale + alpha
-			panic("16-bit grayscale + alpha is not currently supported")
+			return encodeGrayA32Data(w, img, opts)
 		case pamGrayscale:
 			return encodeGray32Data(w, img, opts)
-		case pamBlackAndWhiteAlpha:
-			// TODO: Implement 16-bit BW + alpha
-			panic("16-bit Black & white + alpha is not currently supported")
-		case pamBlackAndWhite:
-			return encodeBWData(w, img, opts)
 		default:
 			panic(fmt.Sprintf("Internal error processing tuple type %q", opts.TupleType))
 		}
@@ -654,58 +724,67 @@ func encodePAM(w io.Writer, img image.Image, opts *EncodeOptions) error {
 
 // encodeRGBAData writes image data as 8-bit samples.
 func encodeRGBAData(w io.Writer, img image.Image, opts *EncodeOptions) error {
-	// In the background, write each 8-bit color sample into a channel.
+	// Convert each row of pixels to samples.
 	rect := img.Bounds()
-	width := rect.Max.X - rect.Min.X
-	samples := make(chan uint16, width*3)
-	go func() {
-		cm := npcolor.RGBAMModel{M: uint8(opts.MaxValue)}
-		for y := rect.Min.Y; y < rect.Max.Y; y++ {
-			for x := rect.Min.X; x < rect.Max.X; x++ {
-				c := cm.Convert(img.At(x, y)).(npcolor.RGBAM)
-				samples <- uint16(c.R)
-				samples <- uint16(c.G)
-				samples <- uint16(c.B)
-				samples <- uint16(c.A)
-			}
+	cm := npcolor.RGBAMModel{M: uint8(opts.MaxValue)}
+	return writeRaster(w, img, opts, 4, false, func(y int, row []uint16) {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := cm.Convert(img.At(x, y)).(npcolor.RGBAM)
+			i := (x - rect.Min.X) * 4
+			row[i] = uint16(c.R)
+			row[i+1] = uint16(c.G)
+			row[i+2] = uint16(c.B)
+			row[i+3] = uint16(c.A)
 		}
-		close(samples)
-	}()
-
-	// In the foreground, consume color samples and write them to the image
-	// file.
-	if opts.Plain {
-		return writePlainData(w, samples)
-	}
-	return writeRawData(w, samples, 1)
+	})
 }
 
 // encodeRGBA64Data writes image data as 16-bit samples.
 func encodeRGBA64Data(w io.Writer, img image.Image, opts *EncodeOptions) error {
-	// In the background, write each 16-bit color sample into a channel.
+	// Convert each row of pixels to samples.
 	rect := img.Bounds()
-	width := rect.Max.X - rect.Min.X
-	samples := make(chan uint16, width*3)
-	go func() {
-		cm := npcolor.RGBAM64Model{M: opts.MaxValue}
-		for y := rect.Min.Y; y < rect.Max.Y; y++ {
-			for x := rect.Min.X; x < rect.Max.X; x++ {
-				c := cm.Convert(img.At(x, y)).(npcolor.RGBAM64)
-				samples <- c.R
-				samples <- c.G
-				samples <- c.B
-				samples <- c.A
-			}
+	cm := npcolor.RGBAM64Model{M: opts.MaxValue}
+	return writeRaster(w, img, opts, 4, false, func(y int, row []uint16) {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := cm.Convert(img.At(x, y)).(npcolor.RGBAM64)
+			i := (x - rect.Min.X) * 4
+			row[i] = c.R
+			row[i+1] = c.G
+			row[i+2] = c.B
+			row[i+3] = c.A
 		}
-		close(samples)
-	}()
+	})
+}
 
-	// In the foreground, consume color samples and write them to the image
-	// file.
-	if opts.Plain {
-		return writePlainData(w, samples)
-	}
-	return writeRawData(w, samples, 2)
+// encodeGrayAData writes grayscale-plus-alpha image data as 8-bit samples.
+func encodeGrayAData(w io.Writer, img image.Image, opts *EncodeOptions) error {
+	// Convert each row of pixels to samples.
+	rect := img.Bounds()
+	cm := npcolor.GrayAMModel{M: uint8(opts.MaxValue), Luma: opts.Luma}
+	return writeRaster(w, img, opts, 2, false, func(y int, row []uint16) {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := cm.Convert(img.At(x, y)).(npcolor.GrayAM)
+			i := (x - rect.Min.X) * 2
+			row[i] = uint16(c.Y)
+			row[i+1] = uint16(c.A)
+		}
+	})
+}
+
+// encodeGrayA32Data writes grayscale-plus-alpha image data as 16-bit
+// samples.
+func encodeGrayA32Data(w io.Writer, img image.Image, opts *EncodeOptions) error {
+	// Convert each row of pixels to samples.
+	rect := img.Bounds()
+	cm := npcolor.GrayAM48Model{M: opts.MaxValue, Luma: opts.Luma}
+	return writeRaster(w, img, opts, 2, false, func(y int, row []uint16) {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := cm.Convert(img.At(x, y)).(npcolor.GrayAM48)
+			i := (x - rect.Min.X) * 2
+			row[i] = c.Y
+			row[i+1] = c.A
+		}
+	})
 }
 
 // A dummyColor implements the color.Color interface.
@@ -790,6 +869,22 @@ func (p *GrayAM) SetGrayAM(x, y int, c npcolor.GrayAM) {
 	}
 }
 
+// RGBA64At returns the color of the pixel at (x, y) as a color.RGBA64.
+func (p *GrayAM) RGBA64At(x, y int) color.RGBA64 {
+	r, g, b, a := p.GrayAMAt(x, y).RGBA()
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
+
+// SetRGBA64 sets the pixel at (x, y) to a given color, expressed as a
+// color.RGBA64.
+func (p *GrayAM) SetRGBA64(x, y int, c color.RGBA64) {
+	m := uint32(p.Model.M)
+	r, g, b, a := unpremultiply(c)
+	v := scaleRGBA64(p.Model.Luma.Y(r, g, b), m)
+	a = scaleRGBA64(a, m)
+	p.SetGrayAM(x, y, npcolor.GrayAM{Y: uint8(v), A: uint8(a), M: p.Model.M})
+}
+
 // SubImage returns an image representing the portion of the image p visible
 // through r. The returned value shares pixels with the original image.
 func (p *GrayAM) SubImage(r image.Rectangle) image.Image {
@@ -799,13 +894,14 @@ func (p *GrayAM) SubImage(r image.Rectangle) image.Image {
 	// explicitly checking for this, the Pix[i:] expression below can
 	// panic.
 	if r.Empty() {
-		return &GrayAM{}
+		return &GrayAM{Model: p.Model}
 	}
 	i := p.PixOffset(r.Min.X, r.Min.Y)
 	return &GrayAM{
 		Pix:    p.Pix[i:],
 		Stride: p.Stride,
 		Rect:   r,
+		Model:  p.Model,
 	}
 }
 
@@ -930,6 +1026,22 @@ func (p *GrayAM48) SetGrayAM48(x, y int, c npcolor.GrayAM48) {
 	}
 }
 
+// RGBA64At returns the color of the pixel at (x, y) as a color.RGBA64.
+func (p *GrayAM48) RGBA64At(x, y int) color.RGBA64 {
+	r, g, b, a := p.GrayAM48At(x, y).RGBA()
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
+
+// SetRGBA64 sets the pixel at (x, y) to a given color, expressed as a
+// color.RGBA64.
+func (p *GrayAM48) SetRGBA64(x, y int, c color.RGBA64) {
+	m := uint32(p.Model.M)
+	r, g, b, a := unpremultiply(c)
+	v := scaleRGBA64(p.Model.Luma.Y(r, g, b), m)
+	a = scaleRGBA64(a, m)
+	p.SetGrayAM48(x, y, npcolor.GrayAM48{Y: uint16(v), A: uint16(a), M: p.Model.M})
+}
+
 // SubImage returns an image representing the portion of the image p visible
 // through r. The returned value shares pixels with the original image.
 func (p *GrayAM48) SubImage(r image.Rectangle) image.Image {
@@ -939,13 +1051,14 @@ func (p *GrayAM48) SubImage(r image.Rectangle) image.Image {
 	// explicitly checking for this, the Pix[i:] expression below can
 	// panic.
 	if r.Empty() {
-		return &GrayAM48{}
+		return &GrayAM48{Model: p.Model}
 	}
 	i := p.PixOffset(r.Min.X, r.Min.Y)
 	return &GrayAM48{
 		Pix:    p.Pix[i:],
 		Stride: p.Stride,
 		Rect:   r,
+		Model:  p.Model,
 	}
 }
 
@@ -5,6 +5,7 @@ package netpbm
 import (
 	"bytes"
 	"compress/flate"
+	"image"
 	"testing"
 )
 
@@ -64,6 +65,85 @@ func TestNetpbmDecodePlainPBMPAMOpts(t *testing.T) {
 	}
 }
 
+// pamImageFromString decodes a compressed image, retaining its alpha channel
+// if any.
+func pamImageFromString(t *testing.T, imgStr string) Image {
+	r := flate.NewReader(bytes.NewBufferString(imgStr))
+	defer r.Close()
+	img, err := Decode(r, &DecodeOptions{Target: PAM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// TestPlainPAM confirms that plain PAM files can be written and read only
+// when explicitly allowed.
+func TestPlainPAM(t *testing.T) {
+	// Ensure that plain PAM is rejected by default.
+	var w bytes.Buffer
+	img := pamImageFromString(t, pamRawColorAlpha)
+	err := Encode(&w, img, &EncodeOptions{Format: PAM, Plain: true})
+	if err == nil {
+		t.Fatal("Encode unexpectedly wrote a plain PAM file")
+	}
+
+	// Write a plain PAM file, and ensure that its header is marked.
+	w.Reset()
+	err = Encode(&w, img, &EncodeOptions{Format: PAM, Plain: true, AllowPlainPAM: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := w.Bytes()
+	if !bytes.Contains(data, []byte("\nPLAIN\nENDHDR\n")) {
+		t.Fatal("Plain PAM header lacks a PLAIN line")
+	}
+
+	// Ensure that the file can be read back only if allowed.
+	if _, err = Decode(bytes.NewReader(data), &DecodeOptions{Target: PAM}); err == nil {
+		t.Fatal("Decode unexpectedly read a plain PAM file")
+	}
+	if _, _, err = image.Decode(bytes.NewReader(data)); err == nil {
+		t.Fatal("image.Decode unexpectedly read a plain PAM file")
+	}
+	img2, err := Decode(bytes.NewReader(data), &DecodeOptions{Target: PAM, AllowPlainPAM: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(img.(*RGBAM).Pix, img2.(*RGBAM).Pix) {
+		t.Fatal("Plain PAM pixels differ from the original pixels")
+	}
+	header, err := DecodeHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !header.Plain {
+		t.Fatal("DecodeHeader failed to report a plain PAM file")
+	}
+}
+
+// TestEncodePAMTranslucent checks that translucent pixels are written to a PAM
+// file with unpremultiplied color channels, as the format requires.  Before
+// the alpha color models converted from unpremultiplied channels, the color
+// below was written as 100 50 25 128.
+func TestEncodePAMTranslucent(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	img.Pix = []uint8{200, 100, 50, 128}
+	var w bytes.Buffer
+	err := Encode(&w, img, &EncodeOptions{
+		Format:    PAM,
+		MaxValue:  255,
+		TupleType: "RGB_ALPHA",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raster := w.Bytes()[w.Len()-4:]
+	if e := []byte{200, 100, 50, 128}; !bytes.Equal(raster, e) {
+		t.Fatalf("Expected %v but saw %v", e, raster)
+	}
+}
+
 // TestRemoveAlphaFromPAMRGBA checks if we can remove the alpha channel from an
 // RGBA image and wind up with an RGB image.
 func TestRemoveAlphaFromPAMRGBA(t *testing.T) {
@@ -0,0 +1,107 @@
+// This file provides parallel conversion of image rows to raster data.
+
+package netpbm
+
+import (
+	"bytes"
+	"runtime"
+)
+
+// bandSamples is the approximate number of samples in each band of rows
+// converted by a single worker.
+const bandSamples = 1 << 16
+
+// workers returns the number of goroutines to use for converting rows.
+func (o *EncodeOptions) workers() int {
+	if o.Concurrency < 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return o.Concurrency
+}
+
+// A rasterBand is a band of consecutive rows converted to raster data.
+type rasterBand struct {
+	y0, y1 int           // First and last+1 row in the band
+	data   []byte        // Raw bytes or plain words
+	done   chan struct{} // Closed when data is ready
+}
+
+// writeRasterParallel writes an image's raster with the help of a given
+// number of worker goroutines.  Each worker converts a band of rows to raw
+// bytes or, for plain output, to space-terminated words.  The calling
+// goroutine writes the bands in order.  Because plain words are divided into
+// lines only as they are written, the output is identical to that produced by
+// converting and writing one row at a time.
+func writeRasterParallel(rw *rasterWriter, header Header, minY int, sample rowSampler, workers int) error {
+	// Determine the number of rows per band.
+	rowLen := header.Width * header.Depth
+	bandRows := 1
+	if rowLen < bandSamples {
+		bandRows = bandSamples / rowLen
+	}
+
+	// Feed bands to the workers.  The order channel, which bounds the
+	// number of bands in flight, delivers bands in row order.
+	jobs := make(chan *rasterBand)
+	order := make(chan *rasterBand, workers*2)
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		defer close(jobs)
+		defer close(order)
+		for y := minY; y < minY+header.Height; y += bandRows {
+			band := &rasterBand{y0: y, y1: y + bandRows, done: make(chan struct{})}
+			if band.y1 > minY+header.Height {
+				band.y1 = minY + header.Height
+			}
+			select {
+			case order <- band:
+			case <-quit:
+				return
+			}
+			select {
+			case jobs <- band:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	// Convert each band.
+	for i := 0; i < workers; i++ {
+		go func() {
+			row := make([]uint16, rowLen)
+			for band := range jobs {
+				var buf bytes.Buffer
+				bw := newRasterWriter(&buf, header, PlainLayout{})
+				for y := band.y0; y < band.y1; y++ {
+					sample(y, row)
+					if header.Plain {
+						band.data = appendPlainWords(band.data, row)
+					} else {
+						bw.WriteRow(row) // Writing to a bytes.Buffer can't fail.
+					}
+				}
+				if !header.Plain {
+					band.data = buf.Bytes()
+				}
+				close(band.done)
+			}
+		}()
+	}
+
+	// Write each band in order.
+	for band := range order {
+		<-band.done
+		var err error
+		if header.Plain {
+			err = rw.WriteWords(band.data)
+		} else {
+			_, err = rw.w.Write(band.data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+// Test parallel encoding.
+
+package netpbm
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/spakin/netpbm/npcolor"
+)
+
+// randomRGBAM returns an RGBAM image filled with random pixels.
+func randomRGBAM(w, h int) *RGBAM {
+	rng := rand.New(rand.NewSource(int64(w*h + 1)))
+	img := NewRGBAM(image.Rect(0, 0, w, h), 255)
+	rng.Read(img.Pix)
+	return img
+}
+
+// TestEncodeConcurrency confirms that parallel encoding produces the same
+// output as serial encoding.
+func TestEncodeConcurrency(t *testing.T) {
+	// The image is tall enough to span many bands of rows, and the
+	// number of samples per row is not a multiple of anything
+	// interesting.
+	img := randomRGBAM(211, 1001)
+	for _, format := range []Format{PBM, PGM, PPM, PAM} {
+		for _, plain := range []bool{false, true} {
+			for _, maxVal := range []uint16{0, 1000} {
+				// Encode the image serially.
+				opts := &EncodeOptions{
+					Format:        format,
+					MaxValue:      maxVal,
+					Plain:         plain,
+					AllowPlainPAM: true,
+				}
+				var serial bytes.Buffer
+				if err := Encode(&serial, img, opts); err != nil {
+					t.Fatal(err)
+				}
+
+				// Encode the image in parallel.
+				for _, workers := range []int{2, 3, 8, -1} {
+					opts.Concurrency = workers
+					var parallel bytes.Buffer
+					if err := Encode(&parallel, img, opts); err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(serial.Bytes(), parallel.Bytes()) {
+						t.Fatalf("Parallel encoding of %s (plain=%v, maxval=%d, workers=%d) differs from serial encoding",
+							format, plain, maxVal, workers)
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestEncodeConcurrencySubImage confirms that parallel encoding respects an
+// image's bounds.
+func TestEncodeConcurrencySubImage(t *testing.T) {
+	img := randomRGBAM(300, 300).SubImage(image.Rect(17, 23, 250, 280)).(*RGBAM)
+	img.SetRGBAM(17, 23, npcolor.RGBAM{R: 1, G: 2, B: 3, A: 4, M: 255})
+	var serial, parallel bytes.Buffer
+	if err := Encode(&serial, img, &EncodeOptions{Plain: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Encode(&parallel, img, &EncodeOptions{Plain: true, Concurrency: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(serial.Bytes(), parallel.Bytes()) {
+		t.Fatal("Parallel encoding of a subimage differs from serial encoding")
+	}
+}
+
+// TestEncodeConcurrencyEmpty confirms that parallel encoding handles images
+// with no columns or no rows.
+func TestEncodeConcurrencyEmpty(t *testing.T) {
+	for _, r := range []image.Rectangle{image.Rect(0, 0, 0, 5), image.Rect(0, 0, 5, 0)} {
+		img := NewRGBAM(r, 255)
+		for _, format := range []Format{PBM, PGM, PPM, PAM} {
+			for _, plain := range []bool{false, true} {
+				opts := &EncodeOptions{
+					Format:        format,
+					Plain:         plain,
+					AllowPlainPAM: true,
+				}
+				var serial, parallel bytes.Buffer
+				if err := Encode(&serial, img, opts); err != nil {
+					t.Fatal(err)
+				}
+				opts.Concurrency = 4
+				if err := Encode(&parallel, img, opts); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(serial.Bytes(), parallel.Bytes()) {
+					t.Fatalf("Parallel encoding of %s (plain=%v, bounds=%v) differs from serial encoding",
+						format, plain, r)
+				}
+			}
+		}
+	}
+}
+
+// benchmarkEncode measures the time to encode a large image with various
+// numbers of workers.
+func benchmarkEncode(b *testing.B, opts EncodeOptions) {
+	img := randomRGBAM(2048, 2048)
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			opts.Concurrency = workers
+			for i := 0; i < b.N; i++ {
+				if err := Encode(ioutil.Discard, img, &opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkEncodeRawPPM measures the time to encode a raw PPM image.
+func BenchmarkEncodeRawPPM(b *testing.B) {
+	benchmarkEncode(b, EncodeOptions{Format: PPM})
+}
+
+// BenchmarkEncodePlainPPM measures the time to encode a plain PPM image.
+func BenchmarkEncodePlainPPM(b *testing.B) {
+	benchmarkEncode(b, EncodeOptions{Format: PPM, Plain: true})
+}
+
+// BenchmarkEncodeRawPGM16 measures the time to encode a raw, 16-bit PGM
+// image.
+func BenchmarkEncodeRawPGM16(b *testing.B) {
+	benchmarkEncode(b, EncodeOptions{Format: PGM, MaxValue: 65535})
+}
@@ -6,11 +6,9 @@ package netpbm
 import (
 	"bufio"
 	"errors"
-	"fmt"
 	"image"
 	"image/color"
 	"io"
-	"strings"
 	"unicode"
 )
 
@@ -211,74 +209,38 @@ func init() {
 // encodePBM writes an arbitrary image in PBM format.
 func encodePBM(w io.Writer, img image.Image, opts *EncodeOptions) error {
 	// Write the PBM header.
+	rect := img.Bounds()
+	header := Header{
+		Magic:    "P4",
+		Width:    rect.Max.X - rect.Min.X,
+		Height:   rect.Max.Y - rect.Min.Y,
+		Comments: opts.Comments,
+	}
 	if opts.Plain {
-		fmt.Fprintln(w, "P1")
-	} else {
-		fmt.Fprintln(w, "P4")
+		header.Magic = "P1"
 	}
-	for _, cmt := range opts.Comments {
-		cmt = strings.Replace(cmt, "\n", " ", -1)
-		cmt = strings.Replace(cmt, "\r", " ", -1)
-		fmt.Fprintf(w, "# %s\n", cmt)
+	if err := writeHeader(w, header, opts); err != nil {
+		return err
 	}
-	rect := img.Bounds()
-	width := rect.Max.X - rect.Min.X
-	height := rect.Max.Y - rect.Min.Y
-	fmt.Fprintf(w, "%d %d\n", width, height)
 
-	// Write the PBM data.
+	// Write the PBM data.  A PackedBW's raw raster is already in PBM
+	// format, except possibly for the meaning of each bit, which its
+	// palette determines.
+	if p, ok := img.(*PackedBW); ok && !opts.Plain && len(p.Palette) == 2 {
+		return encodePackedBWData(w, p)
+	}
 	return encodeBWData(w, img, opts)
 }
 
 // encodeBWData writes image data as 1-bit samples.
 func encodeBWData(w io.Writer, img image.Image, opts *EncodeOptions) error {
-	// In the background, write each index value into a channel.
+	// Convert each row of pixels to color indexes (0=white, 1=black),
+	// which are packed 8 to a byte in raw PBM files.
 	rect := img.Bounds()
-	width := rect.Max.X - rect.Min.X
-	samples := make(chan uint16, width)
-	go func() {
-		bwImage := NewBW(image.ZR)
-		cm := bwImage.ColorModel().(color.Palette)
-		for y := rect.Min.Y; y < rect.Max.Y; y++ {
-			for x := rect.Min.X; x < rect.Max.X; x++ {
-				samples <- uint16(cm.Index(img.At(x, y)))
-			}
-		}
-		close(samples)
-	}()
-
-	// In the foreground, consume index values (either 0 or 1) and
-	// write them to the image file as individual bits.  Pack 8
-	// bits to a byte, pad each row, and output.
-	if opts.Plain {
-		return writePlainData(w, samples)
-	}
-	wb, ok := w.(*bufio.Writer)
-	if !ok {
-		wb = bufio.NewWriter(w)
-	}
-	var b byte      // Next byte to write
-	var bLen uint   // Valid bits in b
-	var rowBits int // Bits written to the current row
-	for s := range samples {
-		b = b<<1 | byte(s)
-		bLen++
-		rowBits++
-		if rowBits == width {
-			// Pad the last byte in the row.
-			b <<= 8 - bLen
-			bLen = 8
-			rowBits = 0
+	cm := NewBW(image.ZR).ColorModel().(color.Palette)
+	return writeRaster(w, img, opts, 1, true, func(y int, row []uint16) {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			row[x-rect.Min.X] = uint16(cm.Index(img.At(x, y)))
 		}
-		if bLen == 8 {
-			// Write a full byte to the output.
-			if err := wb.WriteByte(b); err != nil {
-				return err
-			}
-			b = 0
-			bLen = 0
-		}
-	}
-	wb.Flush()
-	return nil
+	})
 }
@@ -5,7 +5,10 @@ package netpbm
 import (
 	"bytes"
 	"compress/flate"
+	"image"
 	"testing"
+
+	"github.com/spakin/netpbm/npcolor"
 )
 
 // TestDecodePGMEncodePBM confirms that a PGM file can be re-encoded as PBM.
@@ -52,3 +55,27 @@ func TestDecodePBMComments(t *testing.T) {
 		}
 	}
 }
+
+// TestBWPalette confirms that an npcolor.Palette can choose between the
+// colors of a BW image.
+func TestBWPalette(t *testing.T) {
+	bw := NewBW(image.Rect(0, 0, 1, 1))
+	gray := npcolor.GrayM{Y: 120, M: 255} // Darker than 50% gray but lighter than L*=50
+	for _, tc := range []struct {
+		m   npcolor.Metric
+		exp uint8
+	}{
+		{npcolor.MetricRGB, 1},
+		{npcolor.MetricDeltaE76, 0},
+		{npcolor.MetricDeltaE2000, 0},
+	} {
+		p := npcolor.NewPalette(bw.Palette, tc.m)
+		bw.SetColorIndex(0, 0, uint8(p.Index(gray)))
+		if idx := bw.ColorIndexAt(0, 0); idx != tc.exp {
+			t.Fatalf("Expected %s to map %v to %v but saw %v", tc.m, gray, bw.Palette[tc.exp], bw.Palette[idx])
+		}
+		if c := p.Convert(gray); c != bw.Palette[tc.exp] {
+			t.Fatalf("Expected %v but saw %v", bw.Palette[tc.exp], c)
+		}
+	}
+}
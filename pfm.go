@@ -0,0 +1,596 @@
+// This file provides image support for Portable Float Map (PFM) files.
+
+package netpbm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/spakin/netpbm/npcolor"
+)
+
+// GrayF32 is an in-memory image whose At method returns npcolor.GrayF32
+// values.
+type GrayF32 struct {
+	// Pix holds the image's pixels as gray values. The pixel at (x, y)
+	// starts at Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*1].
+	Pix []float32
+	// Stride is the Pix stride (in samples) between vertically adjacent
+	// pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+}
+
+// ColorModel returns the GrayF32 image's color model.
+func (p *GrayF32) ColorModel() color.Model { return npcolor.GrayF32Model{} }
+
+// Bounds returns the domain for which At can return non-zero color.  The
+// bounds do not necessarily contain the point (0, 0).
+func (p *GrayF32) Bounds() image.Rectangle { return p.Rect }
+
+// At returns the color of the pixel at (x, y) as a color.Color.
+// At(Bounds().Min.X, Bounds().Min.Y) returns the upper-left pixel of the grid.
+// At(Bounds().Max.X-1, Bounds().Max.Y-1) returns the lower-right one.
+func (p *GrayF32) At(x, y int) color.Color {
+	return p.GrayF32At(x, y)
+}
+
+// GrayF32At returns the color of the pixel at (x, y) as an npcolor.GrayF32.
+func (p *GrayF32) GrayF32At(x, y int) npcolor.GrayF32 {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return npcolor.GrayF32{}
+	}
+	i := p.PixOffset(x, y)
+	return npcolor.GrayF32{Y: p.Pix[i]}
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to
+// the pixel at (x, y).
+func (p *GrayF32) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*1
+}
+
+// Set sets the pixel at (x, y) to a given color, expressed as a color.Color.
+func (p *GrayF32) Set(x, y int, c color.Color) {
+	p.SetGrayF32(x, y, npcolor.GrayF32Model{}.Convert(c).(npcolor.GrayF32))
+}
+
+// SetGrayF32 sets the pixel at (x, y) to a given color, expressed as an
+// npcolor.GrayF32.
+func (p *GrayF32) SetGrayF32(x, y int, c npcolor.GrayF32) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	p.Pix[p.PixOffset(x, y)] = c.Y
+}
+
+// RGBA64At returns the color of the pixel at (x, y) as a color.RGBA64.
+func (p *GrayF32) RGBA64At(x, y int) color.RGBA64 {
+	r, g, b, a := p.GrayF32At(x, y).RGBA()
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
+
+// SetRGBA64 sets the pixel at (x, y) to a given color, expressed as a
+// color.RGBA64.
+func (p *GrayF32) SetRGBA64(x, y int, c color.RGBA64) {
+	p.SetGrayF32(x, y, npcolor.GrayF32Model{}.Convert(c).(npcolor.GrayF32))
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *GrayF32) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to
+	// be inside either r1 or r2 if the intersection is empty. Without
+	// explicitly checking for this, the Pix[i:] expression below can
+	// panic.
+	if r.Empty() {
+		return &GrayF32{}
+	}
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+	return &GrayF32{
+		Pix:    p.Pix[i:],
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+// Opaque scans the entire image and reports whether it is fully opaque.
+func (p *GrayF32) Opaque() bool {
+	return true
+}
+
+// MaxValue returns 65535, the maximum value of the 16-bit channels to which
+// the image's floating-point channels are converted.
+func (p *GrayF32) MaxValue() uint16 {
+	return 0xffff
+}
+
+// Format identifies the image as a PFM image.
+func (p *GrayF32) Format() Format {
+	return PFM
+}
+
+// HasAlpha indicates that there is no alpha channel.
+func (p *GrayF32) HasAlpha() bool {
+	return false
+}
+
+// Quantize converts the image to a GrayM (if m < 256) or a GrayM32 (if not)
+// with maximum value m, using tm to map floating-point samples to [0.0,
+// 1.0].
+func (p *GrayF32) Quantize(m uint16, tm ToneMap) Image {
+	tmap := newToneMapper(tm, m, p.Pix, p.Rect.Dx(), p.Rect.Dy(), p.Stride)
+	var img Image
+	if m < 256 {
+		img = NewGrayM(p.Rect, uint8(m))
+	} else {
+		img = NewGrayM32(p.Rect, m)
+	}
+	row := make([]uint16, p.Rect.Dx())
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		pix := p.Pix[p.PixOffset(p.Rect.Min.X, y):]
+		for i := range row {
+			row[i] = tmap.sample(pix[i])
+		}
+		storeSamples(img, p.Rect.Min.X, y, row)
+	}
+	return img
+}
+
+// NewGrayF32 returns a new GrayF32 with the given bounds.
+func NewGrayF32(r image.Rectangle) *GrayF32 {
+	w, h := r.Dx(), r.Dy()
+	pix := make([]float32, 1*w*h)
+	return &GrayF32{pix, 1 * w, r}
+}
+
+// RGBF32 is an in-memory image whose At method returns npcolor.RGBF32
+// values.
+type RGBF32 struct {
+	// Pix holds the image's pixels, in R, G, B order. The pixel at
+	// (x, y) starts at Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*3].
+	Pix []float32
+	// Stride is the Pix stride (in samples) between vertically adjacent
+	// pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+}
+
+// ColorModel returns the RGBF32 image's color model.
+func (p *RGBF32) ColorModel() color.Model { return npcolor.RGBF32Model{} }
+
+// Bounds returns the domain for which At can return non-zero color.  The
+// bounds do not necessarily contain the point (0, 0).
+func (p *RGBF32) Bounds() image.Rectangle { return p.Rect }
+
+// At returns the color of the pixel at (x, y) as a color.Color.
+// At(Bounds().Min.X, Bounds().Min.Y) returns the upper-left pixel of the grid.
+// At(Bounds().Max.X-1, Bounds().Max.Y-1) returns the lower-right one.
+func (p *RGBF32) At(x, y int) color.Color {
+	return p.RGBF32At(x, y)
+}
+
+// RGBF32At returns the color of the pixel at (x, y) as an npcolor.RGBF32.
+func (p *RGBF32) RGBF32At(x, y int) npcolor.RGBF32 {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return npcolor.RGBF32{}
+	}
+	i := p.PixOffset(x, y)
+	return npcolor.RGBF32{R: p.Pix[i+0], G: p.Pix[i+1], B: p.Pix[i+2]}
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to
+// the pixel at (x, y).
+func (p *RGBF32) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*3
+}
+
+// Set sets the pixel at (x, y) to a given color, expressed as a color.Color.
+func (p *RGBF32) Set(x, y int, c color.Color) {
+	p.SetRGBF32(x, y, npcolor.RGBF32Model{}.Convert(c).(npcolor.RGBF32))
+}
+
+// SetRGBF32 sets the pixel at (x, y) to a given color, expressed as an
+// npcolor.RGBF32.
+func (p *RGBF32) SetRGBF32(x, y int, c npcolor.RGBF32) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	i := p.PixOffset(x, y)
+	p.Pix[i+0] = c.R
+	p.Pix[i+1] = c.G
+	p.Pix[i+2] = c.B
+}
+
+// RGBA64At returns the color of the pixel at (x, y) as a color.RGBA64.
+func (p *RGBF32) RGBA64At(x, y int) color.RGBA64 {
+	r, g, b, a := p.RGBF32At(x, y).RGBA()
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
+
+// SetRGBA64 sets the pixel at (x, y) to a given color, expressed as a
+// color.RGBA64.
+func (p *RGBF32) SetRGBA64(x, y int, c color.RGBA64) {
+	p.SetRGBF32(x, y, npcolor.RGBF32Model{}.Convert(c).(npcolor.RGBF32))
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r. The returned value shares pixels with the original image.
+func (p *RGBF32) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	// If r1 and r2 are Rectangles, r1.Intersect(r2) is not guaranteed to
+	// be inside either r1 or r2 if the intersection is empty. Without
+	// explicitly checking for this, the Pix[i:] expression below can
+	// panic.
+	if r.Empty() {
+		return &RGBF32{}
+	}
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+	return &RGBF32{
+		Pix:    p.Pix[i:],
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+// Opaque scans the entire image and reports whether it is fully opaque.
+func (p *RGBF32) Opaque() bool {
+	return true
+}
+
+// MaxValue returns 65535, the maximum value of the 16-bit channels to which
+// the image's floating-point channels are converted.
+func (p *RGBF32) MaxValue() uint16 {
+	return 0xffff
+}
+
+// Format identifies the image as a PFM image.
+func (p *RGBF32) Format() Format {
+	return PFM
+}
+
+// HasAlpha indicates that there is no alpha channel.
+func (p *RGBF32) HasAlpha() bool {
+	return false
+}
+
+// Quantize converts the image to an RGBM (if m < 256) or an RGBM64 (if not)
+// with maximum value m, using tm to map floating-point samples to [0.0,
+// 1.0].
+func (p *RGBF32) Quantize(m uint16, tm ToneMap) Image {
+	tmap := newToneMapper(tm, m, p.Pix, p.Rect.Dx()*3, p.Rect.Dy(), p.Stride)
+	var img Image
+	if m < 256 {
+		img = NewRGBM(p.Rect, uint8(m))
+	} else {
+		img = NewRGBM64(p.Rect, m)
+	}
+	row := make([]uint16, p.Rect.Dx()*3)
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		pix := p.Pix[p.PixOffset(p.Rect.Min.X, y):]
+		for i := range row {
+			row[i] = tmap.sample(pix[i])
+		}
+		storeSamples(img, p.Rect.Min.X, y, row)
+	}
+	return img
+}
+
+// NewRGBF32 returns a new RGBF32 with the given bounds.
+func NewRGBF32(r image.Rectangle) *RGBF32 {
+	w, h := r.Dx(), r.Dy()
+	pix := make([]float32, 3*w*h)
+	return &RGBF32{pix, 3 * w, r}
+}
+
+// A floatImage is an Image with floating-point samples.
+type floatImage interface {
+	Image
+	Quantize(m uint16, tm ToneMap) Image
+}
+
+// ToFloat converts an arbitrary image to a GrayF32 if its color model is
+// grayscale or black and white and to an RGBF32 otherwise.  Each sample of a
+// Netpbm image without alpha becomes the sample divided by the image's
+// maximum value.  Translucent colors are treated as though composited over
+// black.
+func ToFloat(img image.Image) Image {
+	var fimg Image
+	rect := img.Bounds()
+	if strings.HasPrefix(inferTupleType(img.ColorModel()), "RGB") {
+		fimg = NewRGBF32(rect)
+	} else {
+		fimg = NewGrayF32(rect)
+	}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			fimg.Set(x, y, img.At(x, y))
+		}
+	}
+	return fimg
+}
+
+// A ToneMap specifies how floating-point samples, which may exceed 1.0, are
+// mapped to the range [0.0, 1.0] when converted to integers.
+type ToneMap int
+
+// These are the tone maps that Quantize, Encode, and Decode support.  All of
+// them map negative and NaN samples to 0.0.
+const (
+	// ClampTone clamps samples to [0.0, 1.0].
+	ClampTone ToneMap = iota
+
+	// ReinhardTone maps each sample v to v/(1+v).
+	ReinhardTone
+
+	// NormalizeTone divides each sample by the image's largest finite
+	// sample.
+	NormalizeTone
+)
+
+// A toneMapper maps floating-point samples to integers.
+type toneMapper struct {
+	tm    ToneMap // Tone map to apply
+	m     float64 // Maximum integer value
+	scale float64 // Factor by which NormalizeTone multiplies each sample
+}
+
+// newToneMapper returns a toneMapper that maps samples to [0, m].  For
+// NormalizeTone, it finds the largest finite sample in a rectangle of w×h
+// samples with the given stride.
+func newToneMapper(tm ToneMap, m uint16, pix []float32, w, h, stride int) toneMapper {
+	t := toneMapper{tm: tm, m: float64(m), scale: 1}
+	if tm != NormalizeTone {
+		return t
+	}
+	var max float32
+	for y := 0; y < h; y++ {
+		for _, v := range pix[y*stride : y*stride+w] {
+			if v > max && !math.IsInf(float64(v), 1) {
+				max = v
+			}
+		}
+	}
+	if max > 0 {
+		t.scale = 1 / float64(max)
+	}
+	return t
+}
+
+// sample maps a single floating-point sample to an integer.
+func (t toneMapper) sample(v float32) uint16 {
+	x := float64(v)
+	switch t.tm {
+	case ReinhardTone:
+		if x > 0 && !math.IsInf(x, 1) {
+			x /= 1 + x
+		}
+	case NormalizeTone:
+		x *= t.scale
+	}
+	switch {
+	case x >= 1:
+		return uint16(t.m)
+	case x > 0:
+		return uint16(x*t.m + 0.5)
+	default:
+		return 0 // Negative or NaN
+	}
+}
+
+// A pfmHeader represents the header of a PFM file.
+type pfmHeader struct {
+	color  bool             // true=PF (color); false=Pf (grayscale)
+	width  int              // Image width in pixels
+	height int              // Image height in pixels
+	scale  float64          // Absolute value of the scale factor
+	order  binary.ByteOrder // Byte order of each sample
+}
+
+// getPFMToken skips whitespace and returns the following sequence of
+// non-whitespace characters.  It consumes the single whitespace character
+// that terminates the sequence.
+func getPFMToken(nr *netpbmReader) (string, error) {
+	c := nr.GetNextByteAsRune()
+	for unicode.IsSpace(c) {
+		c = nr.GetNextByteAsRune()
+	}
+	var tok []byte
+	for nr.Err() == nil && !unicode.IsSpace(c) {
+		tok = append(tok, byte(c))
+		c = nr.GetNextByteAsRune()
+	}
+	if nr.Err() != nil {
+		return "", errors.New("Unexpected EOF in PFM header")
+	}
+	return string(tok), nil
+}
+
+// getPFMHeader reads and parses a PFM header.
+func getPFMHeader(nr *netpbmReader) (pfmHeader, error) {
+	var h pfmHeader
+	magic, err := getPFMToken(nr)
+	if err != nil {
+		return h, err
+	}
+	switch magic {
+	case "PF":
+		h.color = true
+	case "Pf":
+	default:
+		return h, fmt.Errorf("Invalid PFM magic value %q", magic)
+	}
+	for _, dim := range []*int{&h.width, &h.height} {
+		tok, err := getPFMToken(nr)
+		if err != nil {
+			return h, err
+		}
+		*dim, err = strconv.Atoi(tok)
+		if err != nil || *dim < 0 {
+			return h, fmt.Errorf("Invalid PFM image dimension %q", tok)
+		}
+	}
+	tok, err := getPFMToken(nr)
+	if err != nil {
+		return h, err
+	}
+	h.scale, err = strconv.ParseFloat(tok, 64)
+	if err != nil || h.scale == 0 || math.IsInf(h.scale, 0) || math.IsNaN(h.scale) {
+		return h, fmt.Errorf("Invalid PFM scale factor %q", tok)
+	}
+	h.order = binary.BigEndian
+	if h.scale < 0 {
+		h.order = binary.LittleEndian
+		h.scale = -h.scale
+	}
+	return h, nil
+}
+
+// decodeConfigPFM reads and parses a PFM header, either "PF" or "Pf".
+func decodeConfigPFM(r io.Reader) (image.Config, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	h, err := getPFMHeader(newNetpbmReader(br))
+	if err != nil {
+		return image.Config{}, err
+	}
+	cfg := image.Config{Width: h.width, Height: h.height}
+	if h.color {
+		cfg.ColorModel = npcolor.RGBF32Model{}
+	} else {
+		cfg.ColorModel = npcolor.GrayF32Model{}
+	}
+	return cfg, nil
+}
+
+// decodePFMImage reads a complete PFM image.  PFM rasters store rows from
+// bottom to top.  Each sample is multiplied by the absolute value of the
+// header's scale factor.
+func decodePFMImage(r io.Reader) (Image, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	h, err := getPFMHeader(newNetpbmReader(br))
+	if err != nil {
+		return nil, err
+	}
+	rect := image.Rect(0, 0, h.width, h.height)
+	var img Image
+	var pix []float32
+	rowLen := h.width
+	if h.color {
+		rgb := NewRGBF32(rect)
+		img, pix = rgb, rgb.Pix
+		rowLen *= 3
+	} else {
+		gray := NewGrayF32(rect)
+		img, pix = gray, gray.Pix
+	}
+	buf := make([]byte, rowLen*4)
+	for y := h.height - 1; y >= 0; y-- {
+		if _, err = io.ReadFull(br, buf); err != nil {
+			return nil, err
+		}
+		row := pix[y*rowLen : (y+1)*rowLen]
+		for i := range row {
+			v := math.Float32frombits(h.order.Uint32(buf[i*4:]))
+			if h.scale != 1 {
+				v = float32(float64(v) * h.scale)
+			}
+			row[i] = v
+		}
+	}
+	return img, nil
+}
+
+// decodePFM reads a complete PFM image, either "PF" or "Pf".
+func decodePFM(r io.Reader) (image.Image, error) {
+	return decodePFMImage(r)
+}
+
+// Indicate that we can decode both color and grayscale PFM files.
+func init() {
+	image.RegisterFormat("pfm", "PF", decodePFM, decodeConfigPFM)
+	image.RegisterFormat("pfm", "Pf", decodePFM, decodeConfigPFM)
+}
+
+// encodePFM writes an arbitrary image in PFM format, as a color image if
+// opts.TupleType begins with "RGB" and as a grayscale image otherwise.
+func encodePFM(w io.Writer, img image.Image, opts *EncodeOptions) error {
+	// Validate the options.
+	if opts.Plain {
+		return errors.New("PFM does not define a plain (ASCII) encoding")
+	}
+	scale := float64(opts.PFMScale)
+	switch {
+	case scale == 0:
+		scale = 1
+	case scale < 0 || math.IsInf(scale, 0) || math.IsNaN(scale):
+		return fmt.Errorf("Invalid PFM scale factor %g", scale)
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if opts.PFMBigEndian {
+		order = binary.BigEndian
+	}
+
+	// Write the PFM header.  A negative scale factor indicates
+	// little-endian samples.
+	wb, ok := w.(*bufio.Writer)
+	if !ok {
+		wb = bufio.NewWriter(w)
+	}
+	magic, depth := "Pf", 1
+	if strings.HasPrefix(opts.TupleType, "RGB") {
+		magic, depth = "PF", 3
+	}
+	hdrScale := scale
+	if !opts.PFMBigEndian {
+		hdrScale = -scale
+	}
+	rect := img.Bounds()
+	_, err := fmt.Fprintf(wb, "%s\n%d %d\n%s\n", magic, rect.Dx(), rect.Dy(),
+		strconv.FormatFloat(hdrScale, 'g', -1, 32))
+	if err != nil {
+		return err
+	}
+
+	// Write the rows from bottom to top.
+	row := make([]float32, rect.Dx()*depth)
+	buf := make([]byte, len(row)*4)
+	for y := rect.Max.Y - 1; y >= rect.Min.Y; y-- {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			i := (x - rect.Min.X) * depth
+			if depth == 1 {
+				row[i] = npcolor.GrayF32Model{}.Convert(img.At(x, y)).(npcolor.GrayF32).Y
+			} else {
+				c := npcolor.RGBF32Model{}.Convert(img.At(x, y)).(npcolor.RGBF32)
+				row[i], row[i+1], row[i+2] = c.R, c.G, c.B
+			}
+		}
+		for i, v := range row {
+			if scale != 1 {
+				v = float32(float64(v) / scale)
+			}
+			order.PutUint32(buf[i*4:], math.Float32bits(v))
+		}
+		if _, err = wb.Write(buf); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
@@ -0,0 +1,194 @@
+// Test PFM images.
+
+package netpbm
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spakin/netpbm/npcolor"
+)
+
+// hdrImage returns a small color image whose samples include values outside
+// [0.0, 1.0].
+func hdrImage() *RGBF32 {
+	img := NewRGBF32(image.Rect(0, 0, 3, 2))
+	copy(img.Pix, []float32{
+		0.0, 0.25, 1.0, 3.5, -1.0, 0.125,
+		100.0, 0.5, 0.75, 1e-7, 2.0, 0.9,
+	})
+	return img
+}
+
+// TestPFMRoundTrip confirms that PFM images survive a round trip through
+// Encode and Decode in either byte order and with any scale factor.
+func TestPFMRoundTrip(t *testing.T) {
+	gray := NewGrayF32(image.Rect(0, 0, 2, 3))
+	copy(gray.Pix, []float32{0.0, 0.5, 1.0, 1.5, -2.0, 1e9})
+	for _, img := range []Image{hdrImage(), gray} {
+		for _, big := range []bool{false, true} {
+			for _, scale := range []float32{0, 1, 4} {
+				var w bytes.Buffer
+				opts := &EncodeOptions{PFMBigEndian: big, PFMScale: scale}
+				if err := Encode(&w, img, opts); err != nil {
+					t.Fatal(err)
+				}
+				img2, err := Decode(&w, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(img, img2) {
+					t.Fatalf("Expected %v but saw %v", img, img2)
+				}
+			}
+		}
+	}
+}
+
+// TestPFMLayout confirms that PFM files contain the expected header and that
+// their rows are stored from bottom to top.
+func TestPFMLayout(t *testing.T) {
+	// Encode a 1×2 grayscale image as big-endian PFM.
+	img := NewGrayF32(image.Rect(0, 0, 1, 2))
+	img.SetGrayF32(0, 0, npcolor.GrayF32{Y: 1.0})
+	img.SetGrayF32(0, 1, npcolor.GrayF32{Y: 2.0})
+	var w bytes.Buffer
+	if err := Encode(&w, img, &EncodeOptions{PFMBigEndian: true}); err != nil {
+		t.Fatal(err)
+	}
+	exp := "Pf\n1 2\n1\n\x40\x00\x00\x00\x3f\x80\x00\x00"
+	if w.String() != exp {
+		t.Fatalf("Expected %q but saw %q", exp, w.String())
+	}
+
+	// Decode a little-endian color image with a scale factor of 2.
+	data := "PF\n1 1\n-2.0\n\x00\x00\x80\x3f\x00\x00\x00\x3f\x00\x00\x00\x00"
+	img2, err := Decode(strings.NewReader(data), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rgb, ok := img2.(*RGBF32)
+	if !ok {
+		t.Fatalf("Expected an *RGBF32 but saw a %T", img2)
+	}
+	if c, e := rgb.RGBF32At(0, 0), (npcolor.RGBF32{R: 2.0, G: 1.0, B: 0.0}); c != e {
+		t.Fatalf("Expected %v but saw %v", e, c)
+	}
+}
+
+// TestPFMScale confirms that reduced-resolution decoding of a PFM image is
+// rejected with an error that names the format.
+func TestPFMScale(t *testing.T) {
+	var w bytes.Buffer
+	if err := Encode(&w, hdrImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Decode(&w, &DecodeOptions{Scale: 2})
+	if err == nil || !strings.Contains(err.Error(), "PFM") {
+		t.Fatalf("Expected an error mentioning PFM but saw %v", err)
+	}
+}
+
+// TestPFMRegistered confirms that image.Decode and image.DecodeConfig
+// recognize PFM files.
+func TestPFMRegistered(t *testing.T) {
+	var w bytes.Buffer
+	if err := Encode(&w, hdrImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(w.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "pfm" || cfg.Width != 3 || cfg.Height != 2 || cfg.ColorModel != (npcolor.RGBF32Model{}) {
+		t.Fatalf("Unexpected configuration %v of format %q", cfg, format)
+	}
+	img, format, err := image.Decode(&w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(img, hdrImage()) {
+		t.Fatalf("image.Decode returned %v, a %T in %q format", img, img, format)
+	}
+}
+
+// TestToneMap confirms that each tone map converts floating-point samples to
+// the expected integers.
+func TestToneMap(t *testing.T) {
+	img := NewGrayF32(image.Rect(0, 0, 7, 1))
+	copy(img.Pix, []float32{0.0, 0.5, 1.0, 3.0, -1.0, float32(math.NaN()), float32(math.Inf(1))})
+	for _, tc := range []struct {
+		tm  ToneMap
+		exp []uint8
+	}{
+		{ClampTone, []uint8{0, 128, 255, 255, 0, 0, 255}},
+		{ReinhardTone, []uint8{0, 85, 128, 191, 0, 0, 255}},
+		{NormalizeTone, []uint8{0, 43, 85, 255, 0, 0, 255}},
+	} {
+		gray, ok := img.Quantize(255, tc.tm).(*GrayM)
+		if !ok {
+			t.Fatal("Quantize failed to return a *GrayM")
+		}
+		if !bytes.Equal(gray.Pix, tc.exp) {
+			t.Fatalf("Expected tone map %d to produce %v but saw %v", tc.tm, tc.exp, gray.Pix)
+		}
+	}
+}
+
+// TestPFMConvert confirms that Decode and Encode convert between
+// floating-point and integer images.
+func TestPFMConvert(t *testing.T) {
+	// Decode a PFM image as a PPM image.
+	var w bytes.Buffer
+	if err := Encode(&w, hdrImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	pfm := w.Bytes()
+	img, err := Decode(bytes.NewReader(pfm), &DecodeOptions{Target: PPM, FloatMaxValue: 255, ToneMap: ReinhardTone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := hdrImage().Quantize(255, ReinhardTone)
+	if !reflect.DeepEqual(img, exp) {
+		t.Fatalf("Expected %v but saw %v", exp, img)
+	}
+
+	// Color PFM images cannot be demoted to grayscale.
+	if _, err = Decode(bytes.NewReader(pfm), &DecodeOptions{Target: PGM}); err == nil {
+		t.Fatal("Decoding a color PFM image as PGM unexpectedly succeeded")
+	}
+
+	// Encoding a PFM image as PPM should apply the tone map.
+	w.Reset()
+	if err = Encode(&w, hdrImage(), &EncodeOptions{Format: PPM, MaxValue: 255, ToneMap: ReinhardTone}); err != nil {
+		t.Fatal(err)
+	}
+	img, err = Decode(&w, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(img, exp) {
+		t.Fatalf("Expected %v but saw %v", exp, img)
+	}
+
+	// Decoding a PGM image with a PFM target should divide each sample by
+	// the maximum value.
+	gray := NewGrayM32(image.Rect(0, 0, 2, 1), 1000)
+	gray.SetGrayM32(0, 0, npcolor.GrayM32{Y: 250, M: 1000})
+	gray.SetGrayM32(1, 0, npcolor.GrayM32{Y: 1000, M: 1000})
+	w.Reset()
+	if err = Encode(&w, gray, nil); err != nil {
+		t.Fatal(err)
+	}
+	img, err = Decode(&w, &DecodeOptions{Target: PFM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fimg, ok := img.(*GrayF32); !ok || fimg.Pix[0] != 0.25 || fimg.Pix[1] != 1.0 {
+		t.Fatalf("Expected a GrayF32 with samples 0.25 and 1.0 but saw %v", img)
+	}
+}
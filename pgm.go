@@ -6,11 +6,9 @@ package netpbm
 import (
 	"bufio"
 	"errors"
-	"fmt"
 	"image"
 	"image/color"
 	"io"
-	"strings"
 
 	"github.com/spakin/netpbm/npcolor"
 )
@@ -80,6 +78,20 @@ func (p *GrayM) SetGrayM(x, y int, c npcolor.GrayM) {
 	}
 }
 
+// RGBA64At returns the color of the pixel at (x, y) as a color.RGBA64.
+func (p *GrayM) RGBA64At(x, y int) color.RGBA64 {
+	r, g, b, a := p.GrayMAt(x, y).RGBA()
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
+
+// SetRGBA64 sets the pixel at (x, y) to a given color, expressed as a
+// color.RGBA64.
+func (p *GrayM) SetRGBA64(x, y int, c color.RGBA64) {
+	m := uint32(p.Model.M)
+	v := scaleRGBA64(p.Model.Luma.Y(uint32(c.R), uint32(c.G), uint32(c.B)), m)
+	p.SetGrayM(x, y, npcolor.GrayM{Y: uint8(v), M: p.Model.M})
+}
+
 // SubImage returns an image representing the portion of the image p visible
 // through r. The returned value shares pixels with the original image.
 func (p *GrayM) SubImage(r image.Rectangle) image.Image {
@@ -89,13 +101,14 @@ func (p *GrayM) SubImage(r image.Rectangle) image.Image {
 	// explicitly checking for this, the Pix[i:] expression below can
 	// panic.
 	if r.Empty() {
-		return &GrayM{}
+		return &GrayM{Model: p.Model}
 	}
 	i := p.PixOffset(r.Min.X, r.Min.Y)
 	return &GrayM{
 		Pix:    p.Pix[i:],
 		Stride: p.Stride,
 		Rect:   r,
+		Model:  p.Model,
 	}
 }
 
@@ -212,6 +225,20 @@ func (p *GrayM32) SetGrayM32(x, y int, c npcolor.GrayM32) {
 	}
 }
 
+// RGBA64At returns the color of the pixel at (x, y) as a color.RGBA64.
+func (p *GrayM32) RGBA64At(x, y int) color.RGBA64 {
+	r, g, b, a := p.GrayM32At(x, y).RGBA()
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
+
+// SetRGBA64 sets the pixel at (x, y) to a given color, expressed as a
+// color.RGBA64.
+func (p *GrayM32) SetRGBA64(x, y int, c color.RGBA64) {
+	m := uint32(p.Model.M)
+	v := scaleRGBA64(p.Model.Luma.Y(uint32(c.R), uint32(c.G), uint32(c.B)), m)
+	p.SetGrayM32(x, y, npcolor.GrayM32{Y: uint16(v), M: p.Model.M})
+}
+
 // SubImage returns an image representing the portion of the image p visible
 // through r. The returned value shares pixels with the original image.
 func (p *GrayM32) SubImage(r image.Rectangle) image.Image {
@@ -221,13 +248,14 @@ func (p *GrayM32) SubImage(r image.Rectangle) image.Image {
 	// explicitly checking for this, the Pix[i:] expression below can
 	// panic.
 	if r.Empty() {
-		return &GrayM32{}
+		return &GrayM32{Model: p.Model}
 	}
 	i := p.PixOffset(r.Min.X, r.Min.Y)
 	return &GrayM32{
 		Pix:    p.Pix[i:],
 		Stride: p.Stride,
 		Rect:   r,
+		Model:  p.Model,
 	}
 }
 
@@ -428,21 +456,20 @@ func init() {
 // encodePGM writes an arbitrary image in PGM format.
 func encodePGM(w io.Writer, img image.Image, opts *EncodeOptions) error {
 	// Write the PGM header.
+	rect := img.Bounds()
+	header := Header{
+		Magic:    "P5",
+		Width:    rect.Max.X - rect.Min.X,
+		Height:   rect.Max.Y - rect.Min.Y,
+		Maxval:   int(opts.MaxValue),
+		Comments: opts.Comments,
+	}
 	if opts.Plain {
-		fmt.Fprintln(w, "P2")
-	} else {
-		fmt.Fprintln(w, "P5")
+		header.Magic = "P2"
 	}
-	for _, cmt := range opts.Comments {
-		cmt = strings.Replace(cmt, "\n", " ", -1)
-		cmt = strings.Replace(cmt, "\r", " ", -1)
-		fmt.Fprintf(w, "# %s\n", cmt)
+	if err := writeHeader(w, header, opts); err != nil {
+		return err
 	}
-	rect := img.Bounds()
-	width := rect.Max.X - rect.Min.X
-	height := rect.Max.Y - rect.Min.Y
-	fmt.Fprintf(w, "%d %d\n", width, height)
-	fmt.Fprintf(w, "%d\n", opts.MaxValue)
 
 	// Write the PGM data.
 	if opts.MaxValue < 256 {
@@ -453,50 +480,26 @@ func encodePGM(w io.Writer, img image.Image, opts *EncodeOptions) error {
 
 // encodeGrayData writes image data as 8-bit samples.
 func encodeGrayData(w io.Writer, img image.Image, opts *EncodeOptions) error {
-	// In the background, write each 8-bit color sample into a channel.
+	// Convert each row of pixels to samples.
 	rect := img.Bounds()
-	width := rect.Max.X - rect.Min.X
-	samples := make(chan uint16, width)
-	go func() {
-		cm := npcolor.GrayMModel{M: uint8(opts.MaxValue)}
-		for y := rect.Min.Y; y < rect.Max.Y; y++ {
-			for x := rect.Min.X; x < rect.Max.X; x++ {
-				c := cm.Convert(img.At(x, y)).(npcolor.GrayM)
-				samples <- uint16(c.Y)
-			}
+	cm := npcolor.GrayMModel{M: uint8(opts.MaxValue), Luma: opts.Luma}
+	return writeRaster(w, img, opts, 1, false, func(y int, row []uint16) {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := cm.Convert(img.At(x, y)).(npcolor.GrayM)
+			row[x-rect.Min.X] = uint16(c.Y)
 		}
-		close(samples)
-	}()
-
-	// In the foreground, consume grayscale samples and write them to the
-	// image file.
-	if opts.Plain {
-		return writePlainData(w, samples)
-	}
-	return writeRawData(w, samples, 1)
+	})
 }
 
 // encodeGray32Data writes image data as 16-bit samples.
 func encodeGray32Data(w io.Writer, img image.Image, opts *EncodeOptions) error {
-	// In the background, write each 16-bit color sample into a channel.
+	// Convert each row of pixels to samples.
 	rect := img.Bounds()
-	width := rect.Max.X - rect.Min.X
-	samples := make(chan uint16, width)
-	go func() {
-		cm := npcolor.GrayM32Model{M: opts.MaxValue}
-		for y := rect.Min.Y; y < rect.Max.Y; y++ {
-			for x := rect.Min.X; x < rect.Max.X; x++ {
-				c := cm.Convert(img.At(x, y)).(npcolor.GrayM32)
-				samples <- c.Y
-			}
+	cm := npcolor.GrayM32Model{M: opts.MaxValue, Luma: opts.Luma}
+	return writeRaster(w, img, opts, 1, false, func(y int, row []uint16) {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := cm.Convert(img.At(x, y)).(npcolor.GrayM32)
+			row[x-rect.Min.X] = c.Y
 		}
-		close(samples)
-	}()
-
-	// In the foreground, consume grayscale samples and write them to the
-	// image file.
-	if opts.Plain {
-		return writePlainData(w, samples)
-	}
-	return writeRawData(w, samples, 2)
+	})
 }
@@ -5,7 +5,10 @@ package netpbm
 import (
 	"bytes"
 	"compress/flate"
+	"image"
 	"testing"
+
+	"github.com/spakin/netpbm/npcolor"
 )
 
 // TestNetpbmDecodePBMPGMOpts determines if netpbm.Decode can decode a PBM file
@@ -46,3 +49,46 @@ func TestDecodePPMEncodePGM(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// TestEncodeLumaPGM confirms that Encode converts color to grayscale using
+// the requested luma formula.
+func TestEncodeLumaPGM(t *testing.T) {
+	rgb := imageFromString(t, ppmRaw, PPM).(*RGBM)
+	r := rgb.Bounds()
+	nrgba := image.NewNRGBA(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			nrgba.Set(x, y, rgb.At(x, y))
+		}
+	}
+	for _, l := range []npcolor.Luma{npcolor.LumaBT601, npcolor.LumaBT709, npcolor.LumaAverage, npcolor.LumaRed} {
+		for _, m := range []uint16{255, 1000} {
+			model := npcolor.GrayM32Model{M: m, Luma: l}
+			for _, img := range []image.Image{rgb, nrgba} {
+				var w bytes.Buffer
+				if err := Encode(&w, img, &EncodeOptions{Format: PGM, MaxValue: m, Luma: l}); err != nil {
+					t.Fatal(err)
+				}
+				gray, err := Decode(&w, &DecodeOptions{Target: PGM})
+				if err != nil {
+					t.Fatal(err)
+				}
+				for y := r.Min.Y; y < r.Max.Y; y++ {
+					for x := r.Min.X; x < r.Max.X; x++ {
+						e := model.Convert(img.At(x, y)).(npcolor.GrayM32).Y
+						g := npcolor.GrayM32Model{M: m}.Convert(gray.At(x, y)).(npcolor.GrayM32).Y
+						if g != e {
+							t.Fatalf("Expected %s luma %d at (%d, %d) of a %T but saw %d", l, e, x, y, img, g)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// TestAddRemoveAlphaPGM determines if we can add an alpha channel to a PGM
+// file, remove it, and wind up with the same PGM image as we started with.
+func TestAddRemoveAlphaPGM(t *testing.T) {
+	addRemoveAlpha(t, pgmRaw, nil, nil)
+}
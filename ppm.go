@@ -6,11 +6,9 @@ package netpbm
 import (
 	"bufio"
 	"errors"
-	"fmt"
 	"image"
 	"image/color"
 	"io"
-	"strings"
 
 	"github.com/spakin/netpbm/npcolor"
 )
@@ -91,6 +89,22 @@ func (p *RGBM) SetRGBM(x, y int, c npcolor.RGBM) {
 	}
 }
 
+// RGBA64At returns the color of the pixel at (x, y) as a color.RGBA64.
+func (p *RGBM) RGBA64At(x, y int) color.RGBA64 {
+	r, g, b, a := p.RGBMAt(x, y).RGBA()
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
+
+// SetRGBA64 sets the pixel at (x, y) to a given color, expressed as a
+// color.RGBA64.
+func (p *RGBM) SetRGBA64(x, y int, c color.RGBA64) {
+	m := uint32(p.Model.M)
+	r := scaleRGBA64(uint32(c.R), m)
+	g := scaleRGBA64(uint32(c.G), m)
+	b := scaleRGBA64(uint32(c.B), m)
+	p.SetRGBM(x, y, npcolor.RGBM{R: uint8(r), G: uint8(g), B: uint8(b), M: p.Model.M})
+}
+
 // SubImage returns an image representing the portion of the image p visible
 // through r. The returned value shares pixels with the original image.
 func (p *RGBM) SubImage(r image.Rectangle) image.Image {
@@ -100,13 +114,14 @@ func (p *RGBM) SubImage(r image.Rectangle) image.Image {
 	// explicitly checking for this, the Pix[i:] expression below can
 	// panic.
 	if r.Empty() {
-		return &RGBM{}
+		return &RGBM{Model: p.Model}
 	}
 	i := p.PixOffset(r.Min.X, r.Min.Y)
 	return &RGBM{
 		Pix:    p.Pix[i:],
 		Stride: p.Stride,
 		Rect:   r,
+		Model:  p.Model,
 	}
 }
 
@@ -130,6 +145,23 @@ func (p *RGBM) HasAlpha() bool {
 	return false
 }
 
+// DemoteToGrayM generates an 8-bit grayscale image from the given color image.
+// Each gray level is computed from the red, green, and blue samples using luma
+// formula l, and the result's model uses the same formula.
+func (p *RGBM) DemoteToGrayM(l npcolor.Luma) *GrayM {
+	gray := NewGrayM(p.Rect, p.Model.M)
+	gray.Model.Luma = l
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		src := p.Pix[p.PixOffset(p.Rect.Min.X, y):]
+		dst := gray.Pix[gray.PixOffset(p.Rect.Min.X, y):]
+		for x := 0; x < p.Rect.Dx(); x++ {
+			s := src[x*3 : x*3+3]
+			dst[x] = uint8(l.Y(uint32(s[0]), uint32(s[1]), uint32(s[2])))
+		}
+	}
+	return gray
+}
+
 // NewRGBM returns a new RGBM with the given bounds and maximum channel value.
 func NewRGBM(r image.Rectangle, m uint8) *RGBM {
 	w, h := r.Dx(), r.Dy()
@@ -222,6 +254,22 @@ func (p *RGBM64) SetRGBM64(x, y int, c npcolor.RGBM64) {
 	}
 }
 
+// RGBA64At returns the color of the pixel at (x, y) as a color.RGBA64.
+func (p *RGBM64) RGBA64At(x, y int) color.RGBA64 {
+	r, g, b, a := p.RGBM64At(x, y).RGBA()
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
+
+// SetRGBA64 sets the pixel at (x, y) to a given color, expressed as a
+// color.RGBA64.
+func (p *RGBM64) SetRGBA64(x, y int, c color.RGBA64) {
+	m := uint32(p.Model.M)
+	r := scaleRGBA64(uint32(c.R), m)
+	g := scaleRGBA64(uint32(c.G), m)
+	b := scaleRGBA64(uint32(c.B), m)
+	p.SetRGBM64(x, y, npcolor.RGBM64{R: uint16(r), G: uint16(g), B: uint16(b), M: p.Model.M})
+}
+
 // SubImage returns an image representing the portion of the image p visible
 // through r. The returned value shares pixels with the original image.
 func (p *RGBM64) SubImage(r image.Rectangle) image.Image {
@@ -231,13 +279,14 @@ func (p *RGBM64) SubImage(r image.Rectangle) image.Image {
 	// explicitly checking for this, the Pix[i:] expression below can
 	// panic.
 	if r.Empty() {
-		return &RGBM64{}
+		return &RGBM64{Model: p.Model}
 	}
 	i := p.PixOffset(r.Min.X, r.Min.Y)
 	return &RGBM64{
 		Pix:    p.Pix[i:],
 		Stride: p.Stride,
 		Rect:   r,
+		Model:  p.Model,
 	}
 }
 
@@ -261,6 +310,28 @@ func (p *RGBM64) HasAlpha() bool {
 	return false
 }
 
+// DemoteToGrayM32 generates a 16-bit grayscale image from the given color
+// image.  Each gray level is computed from the red, green, and blue samples
+// using luma formula l, and the result's model uses the same formula.
+func (p *RGBM64) DemoteToGrayM32(l npcolor.Luma) *GrayM32 {
+	gray := NewGrayM32(p.Rect, p.Model.M)
+	gray.Model.Luma = l
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		src := p.Pix[p.PixOffset(p.Rect.Min.X, y):]
+		dst := gray.Pix[gray.PixOffset(p.Rect.Min.X, y):]
+		for x := 0; x < p.Rect.Dx(); x++ {
+			s := src[x*6 : x*6+6]
+			r := uint32(s[0])<<8 | uint32(s[1])
+			g := uint32(s[2])<<8 | uint32(s[3])
+			b := uint32(s[4])<<8 | uint32(s[5])
+			v := l.Y(r, g, b)
+			dst[x*2] = uint8(v >> 8)
+			dst[x*2+1] = uint8(v)
+		}
+	}
+	return gray
+}
+
 // NewRGBM64 returns a new RGBM64 with the given bounds and maximum channel
 // value.
 func NewRGBM64(r image.Rectangle, m uint16) *RGBM64 {
@@ -424,21 +495,20 @@ func init() {
 // encodePPM writes an arbitrary image in PPM format.
 func encodePPM(w io.Writer, img image.Image, opts *EncodeOptions) error {
 	// Write the PPM header.
+	rect := img.Bounds()
+	header := Header{
+		Magic:    "P6",
+		Width:    rect.Max.X - rect.Min.X,
+		Height:   rect.Max.Y - rect.Min.Y,
+		Maxval:   int(opts.MaxValue),
+		Comments: opts.Comments,
+	}
 	if opts.Plain {
-		fmt.Fprintln(w, "P3")
-	} else {
-		fmt.Fprintln(w, "P6")
+		header.Magic = "P3"
 	}
-	for _, cmt := range opts.Comments {
-		cmt = strings.Replace(cmt, "\n", " ", -1)
-		cmt = strings.Replace(cmt, "\r", " ", -1)
-		fmt.Fprintf(w, "# %s\n", cmt)
+	if err := writeHeader(w, header, opts); err != nil {
+		return err
 	}
-	rect := img.Bounds()
-	width := rect.Max.X - rect.Min.X
-	height := rect.Max.Y - rect.Min.Y
-	fmt.Fprintf(w, "%d %d\n", width, height)
-	fmt.Fprintf(w, "%d\n", opts.MaxValue)
 
 	// Write the PPM data.
 	if opts.MaxValue < 256 {
@@ -449,54 +519,32 @@ func encodePPM(w io.Writer, img image.Image, opts *EncodeOptions) error {
 
 // encodeRGBData writes image data as 8-bit samples.
 func encodeRGBData(w io.Writer, img image.Image, opts *EncodeOptions) error {
-	// In the background, write each 8-bit color sample into a channel.
+	// Convert each row of pixels to samples.
 	rect := img.Bounds()
-	width := rect.Max.X - rect.Min.X
-	samples := make(chan uint16, width*3)
-	go func() {
-		cm := npcolor.RGBMModel{M: uint8(opts.MaxValue)}
-		for y := rect.Min.Y; y < rect.Max.Y; y++ {
-			for x := rect.Min.X; x < rect.Max.X; x++ {
-				c := cm.Convert(img.At(x, y)).(npcolor.RGBM)
-				samples <- uint16(c.R)
-				samples <- uint16(c.G)
-				samples <- uint16(c.B)
-			}
+	cm := npcolor.RGBMModel{M: uint8(opts.MaxValue)}
+	return writeRaster(w, img, opts, 3, false, func(y int, row []uint16) {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := cm.Convert(img.At(x, y)).(npcolor.RGBM)
+			i := (x - rect.Min.X) * 3
+			row[i] = uint16(c.R)
+			row[i+1] = uint16(c.G)
+			row[i+2] = uint16(c.B)
 		}
-		close(samples)
-	}()
-
-	// In the foreground, consume color samples and write them to the image
-	// file.
-	if opts.Plain {
-		return writePlainData(w, samples)
-	}
-	return writeRawData(w, samples, 1)
+	})
 }
 
 // encodeRGB64Data writes image data as 16-bit samples.
 func encodeRGB64Data(w io.Writer, img image.Image, opts *EncodeOptions) error {
-	// In the background, write each 16-bit color sample into a channel.
+	// Convert each row of pixels to samples.
 	rect := img.Bounds()
-	width := rect.Max.X - rect.Min.X
-	samples := make(chan uint16, width*3)
-	go func() {
-		cm := npcolor.RGBM64Model{M: opts.MaxValue}
-		for y := rect.Min.Y; y < rect.Max.Y; y++ {
-			for x := rect.Min.X; x < rect.Max.X; x++ {
-				c := cm.Convert(img.At(x, y)).(npcolor.RGBM64)
-				samples <- c.R
-				samples <- c.G
-				samples <- c.B
-			}
+	cm := npcolor.RGBM64Model{M: opts.MaxValue}
+	return writeRaster(w, img, opts, 3, false, func(y int, row []uint16) {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := cm.Convert(img.At(x, y)).(npcolor.RGBM64)
+			i := (x - rect.Min.X) * 3
+			row[i] = c.R
+			row[i+1] = c.G
+			row[i+2] = c.B
 		}
-		close(samples)
-	}()
-
-	// In the foreground, consume color samples and write them to the image
-	// file.
-	if opts.Plain {
-		return writePlainData(w, samples)
-	}
-	return writeRawData(w, samples, 2)
+	})
 }
@@ -5,7 +5,10 @@ package netpbm
 import (
 	"bytes"
 	"compress/flate"
+	"image"
 	"testing"
+
+	"github.com/spakin/netpbm/npcolor"
 )
 
 // TestNetpbmDecodePGMPPMOpts determines if netpbm.Decode can decode a PGM file
@@ -69,3 +72,47 @@ func TestNetpbmDecodePlainPBMPPMOpts(t *testing.T) {
 func TestAddRemoveAlphaPPM(t *testing.T) {
 	addRemoveAlpha(t, ppmRaw, nil, nil)
 }
+
+// TestDemotePPM confirms that Demote computes each gray level from the
+// original samples using the requested luma formula.
+func TestDemotePPM(t *testing.T) {
+	rgb := imageFromString(t, ppmRaw, PPM).(*RGBM)
+	sub := rgb.SubImage(image.Rect(5, 7, 20, 30)).(*RGBM)
+	for _, l := range []npcolor.Luma{npcolor.LumaBT601, npcolor.LumaBT709, npcolor.LumaAverage, npcolor.LumaGreen} {
+		for _, img := range []*RGBM{rgb, sub} {
+			demoted, ok := Demote(img, l)
+			if !ok {
+				t.Fatal("Failed to demote a PPM image")
+			}
+			gray := demoted.(*GrayM)
+			if gray.Bounds() != img.Bounds() || gray.Model != (npcolor.GrayMModel{M: img.Model.M, Luma: l}) {
+				t.Fatalf("Unexpected bounds %v or model %v", gray.Bounds(), gray.Model)
+			}
+			r := img.Bounds()
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					c := img.RGBMAt(x, y)
+					e := uint8(l.Y(uint32(c.R), uint32(c.G), uint32(c.B)))
+					if g := gray.GrayMAt(x, y).Y; g != e {
+						t.Fatalf("Expected %s luma of %v to be %d but saw %d", l, c, e, g)
+					}
+				}
+			}
+		}
+	}
+
+	// 16-bit and alpha images should be demoted as well.
+	rgba := NewRGBAM64(image.Rect(0, 0, 1, 1), 1000)
+	rgba.SetRGBAM64(0, 0, npcolor.RGBAM64{R: 1000, G: 500, B: 0, A: 300, M: 1000})
+	demoted, ok := Demote(rgba, npcolor.LumaBT709)
+	if !ok {
+		t.Fatal("Failed to demote a PAM image")
+	}
+	e := npcolor.GrayAM48{Y: 570, A: 300, M: 1000}
+	if c := demoted.(*GrayAM48).GrayAM48At(0, 0); c != e {
+		t.Fatalf("Expected %v but saw %v", e, c)
+	}
+	if _, ok = Demote(demoted, npcolor.LumaBT709); ok {
+		t.Fatal("Unexpectedly demoted a grayscale image")
+	}
+}
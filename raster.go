@@ -0,0 +1,703 @@
+// This file provides row-at-a-time access to the raster portion of a Netpbm
+// file.
+
+package netpbm
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A rasterReader reads a Netpbm raster, raw or plain, one row at a time.
+type rasterReader struct {
+	nr       *netpbmReader   // Source of raster data
+	header   Header          // Header describing the raster
+	plain    bool            // true=plain (ASCII); false=raw (binary)
+	bits     bool            // true=raw samples are packed 8 per byte (PBM)
+	width    int             // Pixels per row
+	depth    int             // Samples per pixel
+	wd       int             // Bytes per raw sample (1 or 2)
+	rowBytes int             // Bytes per raw row
+	buf      []byte          // Buffer for one raw row
+	layout   *layoutRecorder // If non-nil, records the layout of a plain raster
+}
+
+// newRasterReader returns a rasterReader that reads the raster described by a
+// header from a netpbmReader positioned just past the header.
+func newRasterReader(nr *netpbmReader, header Header) (*rasterReader, error) {
+	rr := &rasterReader{
+		nr:     nr,
+		header: header,
+		width:  header.Width,
+		wd:     1,
+	}
+	if header.Maxval >= 256 {
+		rr.wd = 2
+	}
+	switch header.Magic {
+	case "P1", "P2", "P3", "P5", "P6", "P7":
+		rr.plain = header.Plain
+		rr.depth = header.Depth
+	case "P4":
+		rr.bits = true
+		rr.depth = 1
+	default:
+		return nil, fmt.Errorf("Unrecognized magic sequence %q", header.Magic)
+	}
+	if header.Width < 0 || header.Height < 0 || rr.depth < 1 {
+		return nil, errors.New("Invalid image dimensions")
+	}
+	if rr.bits {
+		rr.rowBytes = (rr.width + 7) / 8
+	} else {
+		rr.rowBytes = rr.width * rr.depth * rr.wd
+	}
+	return rr, nil
+}
+
+// RowLen returns the number of samples in each row.
+func (rr *rasterReader) RowLen() int {
+	return rr.width * rr.depth
+}
+
+// ReadRawRow reads the next row of a raw raster and returns its bytes
+// exactly as they appear in the file.  The returned slice is overwritten by
+// the next call to a rasterReader method.
+func (rr *rasterReader) ReadRawRow() ([]byte, error) {
+	if rr.plain {
+		panic("ReadRawRow was invoked on a plain raster")
+	}
+	if rr.buf == nil {
+		rr.buf = make([]byte, rr.rowBytes)
+	}
+	if _, err := io.ReadFull(rr.nr, rr.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return rr.buf, nil
+}
+
+// ReadRow reads the next row of the raster into a slice of RowLen samples.
+// PBM samples use the file's convention of 0=white and 1=black.
+func (rr *rasterReader) ReadRow(row []uint16) error {
+	// Plain rasters contain base-10 numbers, except for plain PBM, in which
+	// each sample is a single digit.
+	row = row[:rr.RowLen()]
+	maxVal := rr.header.Maxval
+	nr := rr.nr
+	if rr.plain {
+		for i := range row {
+			var val int
+			if rr.layout != nil {
+				nr.record.Reset()
+			}
+			if rr.header.Magic == "P1" {
+				c := nr.GetNextByteAsRune()
+				for unicode.IsSpace(c) {
+					c = nr.GetNextByteAsRune()
+				}
+				val = int(c - '0')
+			} else {
+				val = nr.GetNextInt()
+			}
+			switch {
+			case nr.Err() != nil:
+				return nr.Err()
+			case val < 0 || val > maxVal:
+				return fmt.Errorf("Failed to parse ASCII %s data", rr.header.Magic)
+			}
+			if rr.layout != nil {
+				rr.layout.addSample(nr.record.Bytes(), val)
+			}
+			row[i] = uint16(val)
+		}
+		return nil
+	}
+
+	// Raw rasters contain bits, bytes, or big-endian 16-bit words.
+	buf, err := rr.ReadRawRow()
+	if err != nil {
+		return err
+	}
+	switch {
+	case rr.bits:
+		for i := range row {
+			row[i] = uint16(buf[i/8]>>uint(7-i%8)) & 1
+		}
+	case rr.wd == 1:
+		for i, b := range buf {
+			row[i] = uint16(b)
+		}
+	default:
+		for i := range row {
+			row[i] = uint16(buf[i*2])<<8 | uint16(buf[i*2+1])
+		}
+	}
+	return nil
+}
+
+// SkipRows skips over the next n rows of the raster.
+func (rr *rasterReader) SkipRows(n int) error {
+	if n <= 0 {
+		return nil
+	}
+	if !rr.plain {
+		_, err := rr.nr.Discard(n * rr.rowBytes)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	row := make([]uint16, rr.RowLen())
+	for ; n > 0; n-- {
+		if err := rr.ReadRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordLayout causes subsequent calls to ReadRow to record the layout of a
+// plain raster for FinishLayout to return.  It has no effect on a raw raster.
+func (rr *rasterReader) RecordLayout() {
+	if !rr.plain {
+		return
+	}
+	lr := &layoutRecorder{
+		isDefault: true,
+		intern:    make(map[string]string),
+	}
+	lr.def = newRasterWriter(lr, rr.header, PlainLayout{})
+	rr.layout = lr
+	rr.nr.record = new(bytes.Buffer)
+}
+
+// FinishLayout reads the whitespace and comments following the final sample
+// of a plain raster and returns the separators recorded since RecordLayout.
+// It returns nil if the raster is laid out exactly as the zero PlainLayout
+// would lay it out.
+func (rr *rasterReader) FinishLayout() ([]PlainSeparator, error) {
+	lr := rr.layout
+	if lr == nil {
+		return nil, nil
+	}
+	rr.layout = nil
+	rr.nr.record = nil
+
+	// Read the whitespace and comments following the final sample.
+	var tail []byte
+	br := rr.nr.Reader
+	for {
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c == '#' {
+			cmt, err := br.ReadBytes('\n')
+			tail = append(append(tail, c), cmt...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !unicode.IsSpace(rune(c)) {
+			br.UnreadByte()
+			break
+		}
+		tail = append(tail, c)
+	}
+	lr.addSeparator(tail)
+	if lr.isDefault {
+		lr.pending = append(lr.pending, tail...)
+		lr.def.Close()
+	}
+	if lr.isDefault && len(lr.pending) == 0 {
+		return nil, nil
+	}
+	return lr.seps, nil
+}
+
+// A layoutRecorder records the separators surrounding the samples of a plain
+// raster.  It also determines if the raster is laid out as the zero
+// PlainLayout would lay it out by writing the same samples with a
+// rasterWriter and comparing the result, received by the layoutRecorder's
+// Write method, to the original text.
+type layoutRecorder struct {
+	seps      []PlainSeparator  // Separators recorded so far
+	intern    map[string]string // Previously seen separators
+	def       *rasterWriter     // Writer of the samples in the default layout
+	pending   []byte            // Original text not yet written by def
+	isDefault bool              // true=all text written by def matches the original
+	word      []byte            // Buffer for a word to write to def
+}
+
+// addSample records the text read for a sample, comprising a separator, any
+// leading zeros, and the sample's digits.  Leading zeros are considered part
+// of the separator.  The text may lack the sample's final digit if the sample
+// ended the file.
+func (lr *layoutRecorder) addSample(text []byte, val int) {
+	lr.word = strconv.AppendInt(lr.word[:0], int64(val), 10)
+	nd := 0 // Number of trailing digits in text
+	for nd < len(text) && text[len(text)-1-nd] >= '0' && text[len(text)-1-nd] <= '9' {
+		nd++
+	}
+	nz := nd - len(lr.word) // Number of leading zeros
+	if nz < 0 {
+		nz = 0
+	}
+	sep := text[:len(text)-nd+nz]
+	lr.addSeparator(sep)
+	if lr.isDefault {
+		lr.pending = append(append(lr.pending, sep...), lr.word...)
+		lr.def.WriteWords(append(lr.word, ' ')) // Writing to lr can't fail.
+	}
+}
+
+// addSeparator appends a separator to the list of separators, extending the
+// final run if the separator matches it.
+func (lr *layoutRecorder) addSeparator(sep []byte) {
+	if n := len(lr.seps); n > 0 && lr.seps[n-1].Text == string(sep) {
+		lr.seps[n-1].Count++
+		return
+	}
+	text, ok := lr.intern[string(sep)]
+	if !ok {
+		text = string(sep)
+		lr.intern[text] = text
+	}
+	lr.seps = append(lr.seps, PlainSeparator{Text: text, Count: 1})
+}
+
+// Write compares text written in the default layout to the original text.
+func (lr *layoutRecorder) Write(p []byte) (int, error) {
+	if !lr.isDefault {
+		return len(p), nil
+	}
+	if !bytes.HasPrefix(lr.pending, p) {
+		lr.isDefault = false
+		lr.pending = nil
+		return len(p), nil
+	}
+	n := copy(lr.pending, lr.pending[len(p):])
+	lr.pending = lr.pending[:n]
+	return len(p), nil
+}
+
+// CopyRaster copies the remainder of the raster to w without decoding it.  A
+// plain raster is copied byte for byte through the whitespace and comments
+// following its final sample.
+func (rr *rasterReader) CopyRaster(w io.Writer, rows int) error {
+	// Copy a raw raster in its entirety.
+	if !rr.plain {
+		_, err := io.CopyN(w, rr.nr, int64(rows)*int64(rr.rowBytes))
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	// Copy a plain raster one byte at a time, counting samples.  In plain
+	// PBM, each digit is a sample.  In all other formats, each sequence of
+	// digits is a sample.
+	wb := bufio.NewWriter(w)
+	n := rows * rr.RowLen() // Samples remaining
+	inNum := false          // true=in the middle of a number
+	isBits := rr.header.Magic == "P1"
+	br := rr.nr.Reader
+	for n > 0 {
+		c, err := br.ReadByte()
+		if err == io.EOF && inNum && n == 1 {
+			n--
+			break
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		wb.WriteByte(c)
+		switch {
+		case c >= '0' && c <= '9':
+			if isBits {
+				n--
+			} else {
+				inNum = true
+			}
+		case unicode.IsSpace(rune(c)):
+			if inNum {
+				inNum = false
+				n--
+			}
+		case c == '#':
+			// Copy comments through the end of the line.
+			if inNum {
+				inNum = false
+				n--
+			}
+			for {
+				cmt, err := br.ReadSlice('\n')
+				wb.Write(cmt)
+				if err == bufio.ErrBufferFull {
+					continue
+				}
+				if err != nil && err != io.EOF {
+					return err
+				}
+				break
+			}
+		default:
+			return fmt.Errorf("Unexpected character %q in ASCII %s data", c, rr.header.Magic)
+		}
+	}
+
+	// Copy the whitespace and comments following the final sample.
+	for {
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case unicode.IsSpace(rune(c)):
+			wb.WriteByte(c)
+		case c == '#':
+			cmt, err := br.ReadString('\n')
+			wb.WriteByte(c)
+			wb.WriteString(cmt)
+			if err != nil && err != io.EOF {
+				return err
+			}
+		default:
+			br.UnreadByte()
+			return wb.Flush()
+		}
+	}
+	return wb.Flush()
+}
+
+// A rasterWriter writes a Netpbm raster, raw or plain, one row at a time.
+// Plain rasters contain base-10 numbers laid out as described by a
+// PlainLayout.
+type rasterWriter struct {
+	w          io.Writer        // Destination of raster data
+	plain      bool             // true=plain (ASCII); false=raw (binary)
+	bits       bool             // true=raw samples are packed 8 per byte (PBM)
+	width      int              // Pixels per row
+	depth      int              // Samples per pixel
+	wd         int              // Bytes per raw sample (1 or 2)
+	buf        []byte           // Buffer for one raw row or one plain line
+	words      []byte           // Buffer for one row of plain words
+	lineWidth  int              // Maximum length of a plain line, including the newline
+	rowPerLine bool             // true=one image row per plain line
+	sep        []byte           // Separator between plain words
+	colWidth   int              // Minimum width of a plain word
+	seps       []PlainSeparator // Exact separators remaining to write, if any
+	nSep       int              // Number of uses remaining of seps[0]
+}
+
+// A PlainLayout describes how samples are arranged in a plain (ASCII)
+// raster.  The zero value produces lines of at most 70 characters (counting
+// the newline) with samples separated by single spaces.  Every layout decodes
+// to the same samples.
+//
+// Separators, normally obtained from DecodeWithInfo, reproduces an existing
+// layout exactly.  It lists the text preceding each sample followed by the
+// text following the final sample.  It takes precedence over the other fields
+// provided that it contains one more separator than the raster contains
+// samples and that each separator consists only of whitespace and comments
+// (plus, preceding a sample of a format other than PBM, leading zeros).
+// Lines laid out by Separators may exceed 70 characters if the samples are
+// wider than the ones from which the separators were recorded.
+type PlainLayout struct {
+	LineWidth  int              // Maximum line length, counting the newline (0 or negative=70)
+	RowPerLine bool             // true=write each image row on its own line, regardless of LineWidth
+	Compact    bool             // true=omit the spaces between PBM samples (ignored for other formats)
+	Align      bool             // true=right-align samples in columns as wide as the maximum value
+	Separators []PlainSeparator // Exact text surrounding each sample (nil=arrange samples as described by the other fields)
+}
+
+// A PlainSeparator is a run of identical separators in a plain raster.
+type PlainSeparator struct {
+	Text  string // Whitespace, comments, and leading zeros
+	Count int    // Number of consecutive separators
+}
+
+// Positions of a separator within a plain raster
+const (
+	sepFirst   = iota // Precedes the first sample
+	sepBetween        // Lies between two samples
+	sepFinal          // Follows the final sample
+)
+
+// validSeparators reports whether seps describes a plain raster of n samples
+// that decodes to the samples written between the separators.  bits indicates
+// that samples are single digits (plain PBM).
+func validSeparators(seps []PlainSeparator, n int, bits bool) bool {
+	i := 0 // Position of the first separator in the current run
+	for _, sep := range seps {
+		if sep.Count <= 0 || sep.Count > n+1-i {
+			return false
+		}
+		last := i + sep.Count - 1
+		if i == 0 && n > 0 && !validSeparator(sep.Text, bits, sepFirst) {
+			return false
+		}
+		lo, hi := i, last
+		if lo < 1 {
+			lo = 1
+		}
+		if hi > n-1 {
+			hi = n - 1
+		}
+		if lo <= hi && !validSeparator(sep.Text, bits, sepBetween) {
+			return false
+		}
+		if last == n && !validSeparator(sep.Text, bits, sepFinal) {
+			return false
+		}
+		i = last + 1
+	}
+	return i == n+1
+}
+
+// validSeparator reports whether a separator at a given position consists of
+// whitespace and comments followed, if it precedes a sample of a format other
+// than PBM, by leading zeros.  bits indicates that samples are single digits
+// (plain PBM), which the decoder does not allow to be separated by comments.
+func validSeparator(text string, bits bool, pos int) bool {
+	if pos == sepBetween && !bits && (text == "" || text[0] >= '0' && text[0] <= '9') {
+		return false // The separator would extend the preceding sample.
+	}
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+		case c == '#' && (pos == sepFinal || !bits):
+			// Only the final comment may end without a newline.
+			j := strings.IndexByte(text[i:], '\n')
+			if j == -1 {
+				return pos == sepFinal
+			}
+			i += j
+		case c == '0' && pos != sepFinal && !bits:
+			return strings.Trim(text[i:], "0") == ""
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRasterWriter returns a rasterWriter that writes the raster described by
+// a header.  Plain rasters are arranged as described by layout.
+func newRasterWriter(w io.Writer, header Header, layout PlainLayout) *rasterWriter {
+	rw := &rasterWriter{
+		w:          w,
+		plain:      header.Plain,
+		bits:       header.Magic == "P4",
+		width:      header.Width,
+		depth:      header.Depth,
+		wd:         1,
+		lineWidth:  layout.LineWidth,
+		rowPerLine: layout.RowPerLine,
+		sep:        []byte{' '},
+	}
+	if header.Maxval >= 256 {
+		rw.wd = 2
+	}
+	if rw.lineWidth <= 0 {
+		rw.lineWidth = 70
+	}
+	if header.Magic == "P1" {
+		if layout.Compact {
+			rw.sep = nil
+		}
+	} else if layout.Align {
+		rw.colWidth = len(strconv.Itoa(header.Maxval))
+	}
+	if rw.plain && layout.Separators != nil {
+		n := header.Width * header.Height * header.Depth
+		if validSeparators(layout.Separators, n, header.Magic == "P1") {
+			rw.seps = layout.Separators
+			rw.nSep = rw.seps[0].Count
+		}
+	}
+	switch {
+	case rw.plain:
+		rw.buf = make([]byte, 0, rw.lineWidth+2)
+	case rw.bits:
+		rw.buf = make([]byte, (rw.width+7)/8)
+	default:
+		rw.buf = make([]byte, rw.width*rw.depth*rw.wd)
+	}
+	return rw
+}
+
+// appendPlainWords appends each sample in a row to a byte slice as a base-10
+// number followed by a space, except that the row's final sample is followed
+// by a newline.
+func appendPlainWords(dst []byte, row []uint16) []byte {
+	for _, s := range row {
+		dst = strconv.AppendUint(dst, uint64(s), 10)
+		dst = append(dst, ' ')
+	}
+	if len(row) > 0 {
+		dst[len(dst)-1] = '\n'
+	}
+	return dst
+}
+
+// WriteWords writes a sequence of words, each terminated by a space or, at
+// the end of an image row, a newline, as produced by appendPlainWords.  Words
+// are accumulated into lines according to the writer's layout.
+// Consequently, the output depends only on the sequence of words, not on how
+// the sequence is divided among calls to WriteWords.
+func (rw *rasterWriter) WriteWords(words []byte) error {
+	if rw.seps != nil {
+		return rw.writeSeparatedWords(words)
+	}
+	for len(words) > 0 {
+		// Split off the next word.
+		n := bytes.IndexAny(words, " \n")
+		if n == -1 {
+			panic("WriteWords was given an unterminated word")
+		}
+		word, endRow := words[:n], words[n] == '\n'
+		words = words[n+1:]
+		pad := rw.colWidth - len(word)
+		if pad < 0 {
+			pad = 0
+		}
+
+		// Start a new line if the word won't fit on the current line.
+		if len(rw.buf) > 0 {
+			if !rw.rowPerLine && len(rw.buf)+len(rw.sep)+pad+len(word)+1 > rw.lineWidth {
+				if err := rw.endLine(); err != nil {
+					return err
+				}
+			} else {
+				rw.buf = append(rw.buf, rw.sep...)
+			}
+		}
+
+		// Append the word to the current line.
+		for ; pad > 0; pad-- {
+			rw.buf = append(rw.buf, ' ')
+		}
+		rw.buf = append(rw.buf, word...)
+		if endRow && rw.rowPerLine {
+			if err := rw.endLine(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeSeparatedWords is WriteWords for a writer that precedes each word
+// with the next of a list of exact separators.
+func (rw *rasterWriter) writeSeparatedWords(words []byte) error {
+	for len(words) > 0 {
+		n := bytes.IndexAny(words, " \n")
+		if n == -1 {
+			panic("WriteWords was given an unterminated word")
+		}
+		rw.buf = append(rw.buf, rw.nextSeparator()...)
+		rw.buf = append(rw.buf, words[:n]...)
+		words = words[n+1:]
+		if len(rw.buf) >= 4096 {
+			if _, err := rw.w.Write(rw.buf); err != nil {
+				return err
+			}
+			rw.buf = rw.buf[:0]
+		}
+	}
+	return nil
+}
+
+// nextSeparator returns the next exact separator to write.
+func (rw *rasterWriter) nextSeparator() string {
+	for rw.nSep == 0 {
+		rw.seps = rw.seps[1:]
+		rw.nSep = rw.seps[0].Count
+	}
+	rw.nSep--
+	return rw.seps[0].Text
+}
+
+// endLine terminates and writes the current plain line.
+func (rw *rasterWriter) endLine() error {
+	rw.buf = append(rw.buf, '\n')
+	_, err := rw.w.Write(rw.buf)
+	rw.buf = rw.buf[:0]
+	return err
+}
+
+// WriteRow writes one row of width*depth samples.  PBM samples use the file's
+// convention of 0=white and 1=black.
+func (rw *rasterWriter) WriteRow(row []uint16) error {
+	row = row[:rw.width*rw.depth]
+	switch {
+	case rw.plain:
+		// Plain: Write base-10 numbers arranged into lines.
+		rw.words = appendPlainWords(rw.words[:0], row)
+		return rw.WriteWords(rw.words)
+
+	case rw.bits:
+		// Raw PBM: Pack 8 samples per byte.
+		for i := range rw.buf {
+			rw.buf[i] = 0
+		}
+		for i, s := range row {
+			rw.buf[i/8] |= uint8(s&1) << uint(7-i%8)
+		}
+
+	case rw.wd == 1:
+		// Raw 8-bit samples
+		for i, s := range row {
+			rw.buf[i] = uint8(s)
+		}
+
+	default:
+		// Raw 16-bit samples
+		for i, s := range row {
+			rw.buf[i*2] = uint8(s >> 8)
+			rw.buf[i*2+1] = uint8(s)
+		}
+	}
+	_, err := rw.w.Write(rw.buf)
+	return err
+}
+
+// Close completes the final line of a plain raster.  It does not flush the
+// underlying writer.
+func (rw *rasterWriter) Close() error {
+	if rw.seps != nil {
+		// Write the separator following the final sample.
+		rw.buf = append(rw.buf, rw.nextSeparator()...)
+		_, err := rw.w.Write(rw.buf)
+		rw.buf = rw.buf[:0]
+		return err
+	}
+	if !rw.plain || len(rw.buf) == 0 {
+		return nil
+	}
+	return rw.endLine()
+}
@@ -0,0 +1,152 @@
+// Test the layout of plain rasters.
+
+package netpbm
+
+import (
+	"bytes"
+	"image"
+	"strings"
+	"testing"
+
+	"github.com/spakin/netpbm/npcolor"
+)
+
+// TestPlainLayoutExact confirms that various plain layouts produce exactly
+// the expected rasters.
+func TestPlainLayoutExact(t *testing.T) {
+	gray := NewGrayM(image.Rect(0, 0, 3, 2), 100)
+	for i, y := range []uint8{1, 22, 100, 5, 0, 7} {
+		gray.SetGrayM(i%3, i/3, npcolor.GrayM{Y: y, M: 100})
+	}
+	bw := NewBW(image.Rect(0, 0, 3, 2))
+	copy(bw.Pix, []uint8{0, 1, 0, 1, 1, 0})
+	for _, tc := range []struct {
+		img    image.Image
+		layout PlainLayout
+		raster string
+	}{
+		{gray, PlainLayout{}, "1 22 100 5 0 7\n"},
+		{gray, PlainLayout{LineWidth: 9}, "1 22 100\n5 0 7\n"},
+		{gray, PlainLayout{LineWidth: 8}, "1 22\n100 5 0\n7\n"},
+		{gray, PlainLayout{RowPerLine: true}, "1 22 100\n5 0 7\n"},
+		{gray, PlainLayout{Align: true}, "  1  22 100   5   0   7\n"},
+		{gray, PlainLayout{Align: true, RowPerLine: true}, "  1  22 100\n  5   0   7\n"},
+		{gray, PlainLayout{Compact: true}, "1 22 100 5 0 7\n"},
+		{bw, PlainLayout{}, "0 1 0 1 1 0\n"},
+		{bw, PlainLayout{Compact: true}, "010110\n"},
+		{bw, PlainLayout{Compact: true, LineWidth: 5}, "0101\n10\n"},
+		{bw, PlainLayout{Compact: true, RowPerLine: true}, "010\n110\n"},
+	} {
+		var w bytes.Buffer
+		opts := &EncodeOptions{Plain: true, Layout: tc.layout}
+		if err := Encode(&w, tc.img, opts); err != nil {
+			t.Fatal(err)
+		}
+		header, err := DecodeHeader(bytes.NewReader(w.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if raster := string(w.Bytes()[header.RasterOffset:]); raster != tc.raster {
+			t.Fatalf("Expected %+v to produce %q but saw %q", tc.layout, tc.raster, raster)
+		}
+	}
+}
+
+// TestPlainLayoutRoundTrip confirms that every plain layout decodes to the
+// same image, whether or not rows are converted in parallel.
+func TestPlainLayoutRoundTrip(t *testing.T) {
+	layouts := []PlainLayout{
+		{LineWidth: 1},
+		{LineWidth: 20},
+		{LineWidth: 200},
+		{RowPerLine: true},
+		{Compact: true},
+		{Compact: true, RowPerLine: true},
+		{Align: true},
+		{Align: true, LineWidth: 33},
+		{Align: true, RowPerLine: true},
+	}
+	imgs := []Image{
+		imageFromString(t, pbmRaw, PBM).(Image),
+		imageFromString(t, pgmRaw, PGM).(Image),
+		imageFromString(t, ppmRaw, PPM).(Image),
+		pamImageFromString(t, pamRawColorAlpha),
+	}
+	for _, img := range imgs {
+		for _, layout := range layouts {
+			for _, workers := range []int{1, 3} {
+				// Encode the image.
+				var w bytes.Buffer
+				opts := &EncodeOptions{
+					Plain:         true,
+					AllowPlainPAM: true,
+					Layout:        layout,
+					Concurrency:   workers,
+				}
+				if img.HasAlpha() {
+					opts.Format = PAM
+				}
+				if err := Encode(&w, img, opts); err != nil {
+					t.Fatal(err)
+				}
+				header, err := DecodeHeader(bytes.NewReader(w.Bytes()))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// Check the line lengths.
+				lines := strings.Split(string(w.Bytes()[header.RasterOffset:]), "\n")
+				lines = lines[:len(lines)-1]
+				if layout.RowPerLine && len(lines) != header.Height {
+					t.Fatalf("Expected %d lines but saw %d", header.Height, len(lines))
+				}
+				if !layout.RowPerLine && layout.LineWidth > 1 {
+					for _, ln := range lines {
+						if len(ln)+1 > layout.LineWidth {
+							t.Fatalf("Line %q exceeds %d characters", ln, layout.LineWidth)
+						}
+					}
+				}
+
+				// Decode the image, and compare it to the original.
+				img2, err := Decode(&w, &DecodeOptions{Target: PAM, AllowPlainPAM: true})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(pixSlice(img), pixSlice(img2)) {
+					t.Fatalf("Layout %+v failed to round-trip a %s image", layout, img.Format())
+				}
+			}
+		}
+	}
+}
+
+// TestValidSeparators confirms that only separators that decode to the
+// samples they separate are accepted.
+func TestValidSeparators(t *testing.T) {
+	for _, tc := range []struct {
+		seps  []PlainSeparator
+		n     int
+		bits  bool
+		valid bool
+	}{
+		{[]PlainSeparator{{"", 1}, {" ", 2}, {"\n", 1}}, 3, false, true},
+		{[]PlainSeparator{{"00", 1}, {" # Comment\n 0", 2}, {"\n# Final", 1}}, 3, false, true},
+		{[]PlainSeparator{{"", 1}, {" ", 2}}, 3, false, false},
+		{[]PlainSeparator{{"", 1}, {" ", 4}}, 3, false, false},
+		{[]PlainSeparator{{"", 1}, {"", 2}, {"\n", 1}}, 3, false, false},
+		{[]PlainSeparator{{"", 1}, {" 0 ", 2}, {"\n", 1}}, 3, false, false},
+		{[]PlainSeparator{{"", 1}, {" #No newline", 2}, {"\n", 1}}, 3, false, false},
+		{[]PlainSeparator{{"", 1}, {" ", 2}, {"0", 1}}, 3, false, false},
+		{[]PlainSeparator{{"", 3}, {"\n", 1}}, 3, true, true},
+		{[]PlainSeparator{{"", 3}, {"\n# Final\n", 1}}, 3, true, true},
+		{[]PlainSeparator{{"", 1}, {"0", 2}, {"\n", 1}}, 3, true, false},
+		{[]PlainSeparator{{"", 1}, {"#\n", 2}, {"\n", 1}}, 3, true, false},
+		{[]PlainSeparator{{"\n", 1}}, 0, false, true},
+		{[]PlainSeparator{{"", 0}, {"", 1}, {" ", 2}, {"\n", 1}}, 3, false, false},
+	} {
+		if valid := validSeparators(tc.seps, tc.n, tc.bits); valid != tc.valid {
+			t.Fatalf("Expected validity of %v with %d samples to be %v", tc.seps, tc.n, tc.valid)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// This file provides decoding of a rectangular region of a Netpbm image.
+
+package netpbm
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+)
+
+// DecodeRegion reads only the pixels lying within rectangle rect of a Netpbm
+// image and returns them as an Image whose Bounds are rect.  DecodeRegion
+// skips over the rows preceding the region, seeking past them if r is an
+// io.Seeker and the file is raw (binary), and stops reading after the final
+// row of the region.  The region must lie entirely within the image.  opts
+// is interpreted as in Decode.
+func DecodeRegion(r io.Reader, rect image.Rectangle, opts *DecodeOptions) (Image, error) {
+	// Provide default options.
+	o, err := defaultDecodeOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// If we can seek, note our initial position.  Then parse the header.
+	seeker, canSeek := r.(io.Seeker)
+	var start int64
+	if canSeek {
+		if _, ok := r.(*bufio.Reader); ok {
+			canSeek = false // Seeking would bypass the buffered data.
+		} else if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			canSeek = false
+		}
+	}
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	nr := newNetpbmReader(br)
+	header, err := nr.GetHeader()
+	if err != nil {
+		return nil, err
+	}
+	if err = checkPlainPAM(header, o.AllowPlainPAM); err != nil {
+		return nil, err
+	}
+	bounds := image.Rect(0, 0, header.Width, header.Height)
+	if rect.Empty() || !rect.In(bounds) {
+		return nil, fmt.Errorf("Region %v does not lie within image bounds %v", rect, bounds)
+	}
+	rr, err := newRasterReader(nr, header)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prepare an image to hold the region.
+	img, err := imageFromHeader(header, rect, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Skip the rows preceding the region.
+	if canSeek && !rr.plain {
+		pos := start + header.RasterOffset + int64(rect.Min.Y)*int64(rr.rowBytes)
+		if _, err = seeker.Seek(pos, io.SeekStart); err != nil {
+			return nil, err
+		}
+		br.Reset(r)
+	} else if err = rr.SkipRows(rect.Min.Y); err != nil {
+		return nil, err
+	}
+
+	// Copy the required columns of each row of the region.
+	if err = readRegion(rr, img, rect); err != nil {
+		return nil, err
+	}
+	img, err = convertToTarget(img, &o)
+	if err != nil {
+		return nil, err
+	}
+	o.storeMetadata(header.Comments)
+	return img, nil
+}
+
+// readRegion reads the rows of a raster spanned by rect, which must begin at
+// the raster reader's current row, and stores the columns spanned by rect
+// into img.
+func readRegion(rr *rasterReader, img Image, rect image.Rectangle) error {
+	spp := rr.depth
+	x0, x1 := rect.Min.X, rect.Max.X
+	row := make([]uint16, rr.RowLen())
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		switch {
+		case rr.plain:
+			// Plain: Parse the entire row then keep only the
+			// samples we need.
+			if err := rr.ReadRow(row); err != nil {
+				return err
+			}
+			storeSamples(img, x0, y, row[x0*spp:x1*spp])
+
+		case rr.bits:
+			// Raw PBM: Extract bits from the row.
+			buf, err := rr.ReadRawRow()
+			if err != nil {
+				return err
+			}
+			bw := img.(*BW)
+			pix := bw.Pix[bw.PixOffset(x0, y):]
+			for x := x0; x < x1; x++ {
+				pix[x-x0] = (buf[x/8] >> uint(7-x%8)) & 1
+			}
+
+		default:
+			// Raw PGM, PPM, or PAM: Copy bytes from the row.
+			buf, err := rr.ReadRawRow()
+			if err != nil {
+				return err
+			}
+			bps := spp * rr.wd
+			i := img.PixOffset(x0, y)
+			copy(pixSlice(img)[i:], buf[x0*bps:x1*bps])
+		}
+	}
+	return nil
+}
+
+// pixSlice returns the Pix field of a Netpbm image.
+func pixSlice(img Image) []uint8 {
+	switch img := img.(type) {
+	case *BW:
+		return img.Pix
+	case *GrayM:
+		return img.Pix
+	case *GrayM32:
+		return img.Pix
+	case *GrayAM:
+		return img.Pix
+	case *GrayAM48:
+		return img.Pix
+	case *RGBM:
+		return img.Pix
+	case *RGBM64:
+		return img.Pix
+	case *RGBAM:
+		return img.Pix
+	case *RGBAM64:
+		return img.Pix
+	case *SpaceM:
+		return img.Pix
+	default:
+		panic(fmt.Sprintf("Unexpected image type %T", img))
+	}
+}
+
+// storeSamples stores a sequence of samples into a Netpbm image starting at
+// pixel (x, y).  Samples are stored as 8-bit or 16-bit values based on the
+// image's maximum value.
+func storeSamples(img Image, x, y int, samples []uint16) {
+	pix := pixSlice(img)[img.PixOffset(x, y):]
+	if img.MaxValue() < 256 {
+		for i, s := range samples {
+			pix[i] = uint8(s)
+		}
+		return
+	}
+	for i, s := range samples {
+		pix[i*2] = uint8(s >> 8)
+		pix[i*2+1] = uint8(s)
+	}
+}
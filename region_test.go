@@ -0,0 +1,88 @@
+// Test decoding of image regions.
+
+package netpbm
+
+import (
+	"bytes"
+	"compress/flate"
+	"image"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// decompressString decompresses a test image into a byte slice.
+func decompressString(t *testing.T, imgStr string) []byte {
+	r := flate.NewReader(bytes.NewBufferString(imgStr))
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// compareRegion confirms that decoding a region of an image produces the same
+// pixels as decoding the entire image.
+func compareRegion(t *testing.T, imgStr string, rect image.Rectangle) {
+	data := decompressString(t, imgStr)
+	full, err := Decode(bytes.NewReader(data), &DecodeOptions{Target: PAM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, seek := range []bool{false, true} {
+		// Decode the region either with or without seeking.
+		var part Image
+		if seek {
+			part, err = DecodeRegion(bytes.NewReader(data), rect, &DecodeOptions{Target: PAM})
+		} else {
+			part, err = DecodeRegion(bytes.NewBuffer(data), rect, &DecodeOptions{Target: PAM})
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Compare the region to the corresponding part of the full
+		// image.
+		if part.Bounds() != rect {
+			t.Fatalf("Expected bounds %v but received %v", rect, part.Bounds())
+		}
+		if !reflect.DeepEqual(part.ColorModel(), full.ColorModel()) {
+			t.Fatal("Color model mismatch")
+		}
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				if c0, c1 := full.At(x, y), part.At(x, y); c0 != c1 {
+					t.Fatalf("Expected %v at (%d, %d) but received %v", c0, x, y, c1)
+				}
+			}
+		}
+	}
+}
+
+// TestDecodeRegionRaw tests region decoding of raw images.
+func TestDecodeRegionRaw(t *testing.T) {
+	rect := image.Rect(5, 7, 37, 50)
+	compareRegion(t, pbmRaw, rect)
+	compareRegion(t, pgmRaw, rect)
+	compareRegion(t, ppmRaw, rect)
+	compareRegion(t, pamRawColorAlpha, rect)
+}
+
+// TestDecodeRegionPlain tests region decoding of plain images.
+func TestDecodeRegionPlain(t *testing.T) {
+	rect := image.Rect(9, 3, 62, 65)
+	compareRegion(t, pbmPlain, rect)
+	compareRegion(t, pgmPlain, rect)
+	compareRegion(t, ppmPlain, rect)
+}
+
+// TestDecodeRegionBounds confirms that regions extending past the image are
+// rejected.
+func TestDecodeRegionBounds(t *testing.T) {
+	data := decompressString(t, ppmRaw)
+	_, err := DecodeRegion(bytes.NewReader(data), image.Rect(60, 60, 70, 70), nil)
+	if err == nil {
+		t.Fatal("Decoding an out-of-bounds region unexpectedly succeeded")
+	}
+}
@@ -0,0 +1,131 @@
+// This file provides a means of modifying Netpbm headers without decoding
+// and re-encoding the raster.
+
+package netpbm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+)
+
+// rasterLayout categorizes a header by the way it lays out raster data.
+func rasterLayout(header Header) string {
+	switch {
+	case header.Magic == "P1":
+		return "plain PBM"
+	case header.Magic == "P4":
+		return "raw PBM"
+	case header.Magic == "P7" && header.Plain:
+		return "plain"
+	case header.Magic == "P7":
+		return "raw"
+	case header.Magic[1] <= '3':
+		return "plain"
+	default:
+		return "raw"
+	}
+}
+
+// checkHeaderEdit returns an error if a modified header describes a raster
+// laid out differently from the raster described by the original header.
+func checkHeaderEdit(orig, edited Header) error {
+	switch {
+	case len(edited.Magic) != 2 || edited.Magic[0] != 'P' || edited.Magic[1] < '1' || edited.Magic[1] > '7':
+		return fmt.Errorf("Invalid magic value %q", edited.Magic)
+	case rasterLayout(edited) != rasterLayout(orig):
+		return fmt.Errorf("Cannot change a %s raster to a %s raster", rasterLayout(orig), rasterLayout(edited))
+	case edited.Width != orig.Width:
+		return errors.New("Cannot change an image's width without re-encoding it")
+	case edited.Height != orig.Height:
+		return errors.New("Cannot change an image's height without re-encoding it")
+	case edited.Depth != orig.Depth:
+		return errors.New("Cannot change an image's depth without re-encoding it")
+	}
+	switch edited.Magic {
+	case "P1", "P4":
+		return nil // PBM has no maximum value.
+	case "P7":
+		// A new tuple type must be one Decode can read with the
+		// image's depth.  An existing tuple type is left alone.
+		if orig.Magic != "P7" || edited.TupleType != orig.TupleType {
+			if _, err := imageFromHeader(edited, image.Rectangle{}, nil); err != nil {
+				return err
+			}
+		}
+	case "P2", "P5":
+		if edited.Depth != 1 {
+			return fmt.Errorf("A %s image must have a depth of 1", edited.Magic)
+		}
+	case "P3", "P6":
+		if edited.Depth != 3 {
+			return fmt.Errorf("A %s image must have a depth of 3", edited.Magic)
+		}
+	}
+	switch {
+	case (edited.Maxval < 256) != (orig.Maxval < 256):
+		return errors.New("Cannot change an image's sample width without re-encoding it")
+	case edited.Maxval < orig.Maxval:
+		// Samples may exceed the new maximum value.
+		return errors.New("Cannot lower an image's maximum value without re-encoding it")
+	}
+	return nil
+}
+
+// RewriteHeader copies a stream of one or more Netpbm images from src to dst,
+// passing each image's header to edit before writing it.  The raster that
+// follows each header is copied unmodified.  edit may change any header field
+// that does not affect the raster's layout, such as Comments, TupleType, and
+// Maxval (within the same number of bytes per sample).  Maxval may be raised
+// but not lowered, as samples could then exceed it.  edit may also change
+// Magic, but only between formats with identical raster layouts (e.g., from
+// raw PGM to PAM).  A PAM header produced by changing Magic or TupleType must
+// have a tuple type that Decode supports and that agrees with Depth.
+// RewriteHeader returns an error if edit returns an error or alters the
+// raster layout.  The RasterOffset field is ignored.  Headers are written as
+// by EncodeHeader.  To read or modify metadata stored in the header comments
+// (see Metadata), edit can call ParseMetadata(h.Comments) and assign the
+// result's Comments back to h.Comments.
+func RewriteHeader(dst io.Writer, src io.Reader, edit func(*Header) error) error {
+	br, ok := src.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(src)
+	}
+	wb := bufio.NewWriter(dst)
+	nr := newNetpbmReader(br)
+	for {
+		// Stop at the end of the stream.
+		if _, err := br.Peek(1); err == io.EOF {
+			break
+		}
+
+		// Read and edit the header.
+		header, err := nr.GetHeader()
+		if err != nil {
+			return err
+		}
+		edited := header
+		edited.Comments = append([]string(nil), header.Comments...)
+		if err = edit(&edited); err != nil {
+			return err
+		}
+		if err = checkHeaderEdit(header, edited); err != nil {
+			return err
+		}
+		if err = EncodeHeader(wb, edited); err != nil {
+			return err
+		}
+
+		// Copy the raster.
+		rr, err := newRasterReader(nr, header)
+		if err != nil {
+			return err
+		}
+		if err = rr.CopyRaster(wb, header.Height); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
@@ -0,0 +1,220 @@
+// Test rewriting Netpbm headers.
+
+package netpbm
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"image"
+	"reflect"
+	"testing"
+)
+
+// TestRewriteHeaderStream confirms that RewriteHeader modifies each header in
+// a multi-image stream without modifying the rasters.
+func TestRewriteHeaderStream(t *testing.T) {
+	// Concatenate a variety of images into a single stream.
+	var stream bytes.Buffer
+	imgs := []Image{
+		imageFromString(t, pgmRaw, PGM).(Image),
+		imageFromString(t, ppmPlain, PPM).(Image),
+		imageFromString(t, pbmRaw, PBM).(Image),
+		imageFromString(t, pbmPlain, PBM).(Image),
+		pamImageFromString(t, pamRawGrayAlpha),
+	}
+	for i, img := range imgs {
+		opts := &EncodeOptions{Plain: i == 1 || i == 3}
+		if i == 4 {
+			opts.Format = PAM
+		}
+		if err := Encode(&stream, img, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	orig := append([]byte(nil), stream.Bytes()...)
+
+	// Add a comment to each header.
+	var out bytes.Buffer
+	n := 0
+	err := RewriteHeader(&out, &stream, func(h *Header) error {
+		n++
+		h.Comments = append(h.Comments, "Rewritten")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(imgs) {
+		t.Fatalf("Expected %d headers but saw %d", len(imgs), n)
+	}
+
+	// Confirm that each image is unchanged except for its comments.
+	br := bufio.NewReader(&out)
+	for i, img := range imgs {
+		// Skip the whitespace following a plain raster.
+		for c, err := br.ReadByte(); err == nil; c, err = br.ReadByte() {
+			if c == 'P' {
+				br.UnreadByte()
+				break
+			}
+		}
+		img2, info, err := DecodeWithInfo(br, &DecodeOptions{Target: PAM})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(info.Comments, []string{"Rewritten"}) {
+			t.Fatalf("Image %d: Expected comments [Rewritten] but saw %q", i, info.Comments)
+		}
+		if !bytes.Equal(pixSlice(img), pixSlice(img2)) {
+			t.Fatalf("Image %d: Pixels were modified", i)
+		}
+	}
+
+	// Confirm that a no-op edit reproduces the original stream.
+	out.Reset()
+	err = RewriteHeader(&out, bytes.NewReader(orig), func(h *Header) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(orig, out.Bytes()) {
+		t.Fatal("A no-op header rewrite modified the stream")
+	}
+}
+
+// TestRewriteHeaderTrailingComments confirms that RewriteHeader copies
+// comments that follow a plain raster.
+func TestRewriteHeaderTrailingComments(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(decompressString(t, pgmPlain))
+	stream.Write(decompressString(t, ppmPlain))
+	n := 0
+	var out bytes.Buffer
+	err := RewriteHeader(&out, &stream, func(h *Header) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("Expected 2 headers but saw %d", n)
+	}
+	if !bytes.HasSuffix(out.Bytes(), []byte("\n# Garbage at end\n")) {
+		t.Fatal("Trailing comments were not copied")
+	}
+}
+
+// TestRewriteHeaderMagic confirms that RewriteHeader can convert a raw PGM
+// file to a PAM file.
+func TestRewriteHeaderMagic(t *testing.T) {
+	data := decompressString(t, pgmRaw)
+	var out bytes.Buffer
+	err := RewriteHeader(&out, bytes.NewReader(data), func(h *Header) error {
+		h.Magic = "P7"
+		h.TupleType = "GRAYSCALE"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := Decode(&out, &DecodeOptions{Target: PGM, Exact: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pixSlice(img), pixSlice(imageFromString(t, pgmRaw, PGM).(Image))) {
+		t.Fatal("Pixels were modified")
+	}
+}
+
+// TestRewriteHeaderReject confirms that RewriteHeader rejects edits that
+// would invalidate the raster.
+func TestRewriteHeaderReject(t *testing.T) {
+	data := decompressString(t, ppmRaw)
+	myErr := errors.New("My error")
+	for i, edit := range []func(h *Header) error{
+		func(h *Header) error { h.Width++; return nil },
+		func(h *Header) error { h.Height--; return nil },
+		func(h *Header) error { h.Depth = 4; h.Magic = "P7"; return nil },
+		func(h *Header) error { h.Maxval = 256; return nil },
+		func(h *Header) error { h.Maxval = 100; return nil },
+		func(h *Header) error { h.Magic = "P3"; return nil },
+		func(h *Header) error { h.Magic = "P5"; return nil },
+		func(h *Header) error { return myErr },
+	} {
+		var out bytes.Buffer
+		if err := RewriteHeader(&out, bytes.NewReader(data), edit); err == nil {
+			t.Fatalf("Edit %d was unexpectedly accepted", i)
+		}
+	}
+}
+
+// TestRewriteHeaderMaxval confirms that RewriteHeader accepts a raised
+// maximum value.
+func TestRewriteHeaderMaxval(t *testing.T) {
+	var in, out bytes.Buffer
+	if err := Encode(&in, NewGrayM(image.Rect(0, 0, 3, 2), 100), nil); err != nil {
+		t.Fatal(err)
+	}
+	err := RewriteHeader(&out, &in, func(h *Header) error {
+		h.Maxval = 200
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := Decode(&out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := img.(*GrayM).MaxValue(); m != 200 {
+		t.Fatalf("Expected a maximum value of 200 but saw %d", m)
+	}
+}
+
+// TestRewriteHeaderTupleType confirms that RewriteHeader rejects PAM headers
+// whose tuple type Decode can't read but leaves an existing tuple type alone.
+func TestRewriteHeaderTupleType(t *testing.T) {
+	data := decompressString(t, pgmRaw)
+	for _, tt := range []string{"", "RGB", "BOGUS"} {
+		var out bytes.Buffer
+		err := RewriteHeader(&out, bytes.NewReader(data), func(h *Header) error {
+			h.Magic = "P7"
+			h.TupleType = tt
+			return nil
+		})
+		if err == nil {
+			t.Fatalf("Conversion to PAM with tuple type %q was unexpectedly accepted", tt)
+		}
+	}
+
+	// Comments may be added to a PAM file with an unfamiliar tuple type.
+	in := "P7\nWIDTH 1\nHEIGHT 1\nDEPTH 1\nMAXVAL 255\nTUPLTYPE CUSTOM\nENDHDR\n\x80"
+	var out bytes.Buffer
+	err := RewriteHeader(&out, bytes.NewReader([]byte(in)), func(h *Header) error {
+		h.Comments = append(h.Comments, "Still custom")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRewriteHeaderMetadata confirms that an edit function can modify an
+// image's metadata.
+func TestRewriteHeaderMetadata(t *testing.T) {
+	in := "P5\n# Scan\n# device: old\n1 1 255\n\x80"
+	var out bytes.Buffer
+	err := RewriteHeader(&out, bytes.NewReader([]byte(in)), func(h *Header) error {
+		md := ParseMetadata(h.Comments)
+		md.Set("device", "new")
+		h.Comments = md.Comments()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := "P5\n# Scan\n# device: new\n1 1\n255\n\x80"; out.String() != e {
+		t.Fatalf("Expected %q but saw %q", e, out.String())
+	}
+}
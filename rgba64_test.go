@@ -0,0 +1,150 @@
+// Test the RGBA64At and SetRGBA64 fast paths.
+
+package netpbm
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+
+	"github.com/spakin/netpbm/npcolor"
+)
+
+// Every image type should implement draw.RGBA64Image.
+var (
+	_ draw.RGBA64Image = (*BW)(nil)
+	_ draw.RGBA64Image = (*PackedBW)(nil)
+	_ draw.RGBA64Image = (*GrayM)(nil)
+	_ draw.RGBA64Image = (*GrayM32)(nil)
+	_ draw.RGBA64Image = (*RGBM)(nil)
+	_ draw.RGBA64Image = (*RGBM64)(nil)
+	_ draw.RGBA64Image = (*GrayAM)(nil)
+	_ draw.RGBA64Image = (*GrayAM48)(nil)
+	_ draw.RGBA64Image = (*RGBAM)(nil)
+	_ draw.RGBA64Image = (*RGBAM64)(nil)
+	_ draw.RGBA64Image = (*GrayF32)(nil)
+	_ draw.RGBA64Image = (*RGBF32)(nil)
+	_ draw.RGBA64Image = (*SpaceM)(nil)
+)
+
+// A slowImage hides an image's RGBA64 methods, forcing image/draw to take
+// the generic, per-pixel path.
+type slowImage struct {
+	draw.Image
+}
+
+// rgba64Images returns a fresh image of each type with bounds r, using a
+// variety of maximum values.
+func rgba64Images(r image.Rectangle) []draw.Image {
+	gray := NewGrayM(r, 200)
+	gray.Model.Luma = npcolor.LumaBT709
+	return []draw.Image{
+		NewBW(r),
+		NewPackedBW(r),
+		gray,
+		NewGrayM(r, 255),
+		NewGrayM32(r, 1000),
+		NewRGBM(r, 7),
+		NewRGBM64(r, 65535),
+		NewGrayAM(r, 100),
+		NewGrayAM48(r, 4095),
+		NewRGBAM(r, 255),
+		NewRGBAM(r, 31),
+		NewRGBAM64(r, 65535),
+		NewRGBAM64(r, 300),
+		NewGrayF32(r),
+		NewRGBF32(r),
+		NewSpaceM(r, npcolor.SpaceHSV, 255),
+	}
+}
+
+// randomRGBA64 returns a random alpha-premultiplied color, which is opaque or
+// fully transparent about a quarter of the time each.
+func randomRGBA64(rng *rand.Rand) color.RGBA64 {
+	var a uint32
+	switch rng.Intn(4) {
+	case 0:
+		a = 0xffff
+	case 1:
+		a = 0
+	default:
+		a = uint32(rng.Intn(0x10000))
+	}
+	ch := func() uint16 { return uint16(rng.Uint32() % (a + 1)) }
+	return color.RGBA64{ch(), ch(), ch(), uint16(a)}
+}
+
+// samePixels reports the first pixel at which two images differ.
+func samePixels(t *testing.T, img1, img2 image.Image) {
+	t.Helper()
+	r := img1.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if c1, c2 := img1.At(x, y), img2.At(x, y); c1 != c2 {
+				t.Fatalf("%T: expected %v at (%d, %d) but saw %v", img1, c2, x, y, c1)
+			}
+		}
+	}
+}
+
+// TestSetRGBA64 confirms that SetRGBA64 stores exactly what Set stores and
+// that RGBA64At returns exactly what At returns.
+func TestSetRGBA64(t *testing.T) {
+	r := image.Rect(-2, 1, 30, 9)
+	rng := rand.New(rand.NewSource(50))
+	imgs1, imgs2 := rgba64Images(r), rgba64Images(r)
+	for i, img1 := range imgs1 {
+		fast := img1.(draw.RGBA64Image)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				c := randomRGBA64(rng)
+				fast.SetRGBA64(x, y, c)
+				imgs2[i].Set(x, y, c)
+			}
+		}
+		samePixels(t, img1, imgs2[i])
+
+		// RGBA64At should agree with At, including out of bounds.
+		for y := r.Min.Y - 1; y <= r.Max.Y; y++ {
+			for x := r.Min.X - 1; x <= r.Max.X; x++ {
+				cr, cg, cb, ca := img1.At(x, y).RGBA()
+				e := color.RGBA64{uint16(cr), uint16(cg), uint16(cb), uint16(ca)}
+				if a := fast.RGBA64At(x, y); a != e {
+					t.Fatalf("%T: expected %v at (%d, %d) but saw %v", img1, e, x, y, a)
+				}
+			}
+		}
+	}
+}
+
+// TestDrawRGBA64 confirms that compositing onto and from each image type
+// with image/draw produces the same result as the generic path.
+func TestDrawRGBA64(t *testing.T) {
+	r := image.Rect(0, 0, 23, 11)
+	rng := rand.New(rand.NewSource(51))
+	src := image.NewRGBA64(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			src.SetRGBA64(x, y, randomRGBA64(rng))
+		}
+	}
+	imgs1, imgs2 := rgba64Images(r), rgba64Images(r)
+	for i, img1 := range imgs1 {
+		// Start from an opaque background, then composite the source
+		// image over it.
+		draw.Draw(img1, r, image.NewUniform(color.NRGBA{30, 150, 220, 255}), image.Point{}, draw.Src)
+		draw.Draw(slowImage{imgs2[i]}, r, image.NewUniform(color.NRGBA{30, 150, 220, 255}), image.Point{}, draw.Src)
+		draw.Draw(img1, r, src, image.Point{}, draw.Over)
+		draw.Draw(slowImage{imgs2[i]}, r, src, image.Point{}, draw.Over)
+		samePixels(t, img1, imgs2[i])
+
+		// Drawing from the image should likewise match drawing from
+		// a version with its RGBA64 methods hidden.
+		dst1, dst2 := image.NewNRGBA64(r), image.NewNRGBA64(r)
+		draw.Draw(dst1, r, img1, r.Min, draw.Src)
+		draw.Draw(dst2, r, slowImage{img1}, r.Min, draw.Src)
+		samePixels(t, dst1, dst2)
+	}
+}
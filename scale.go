@@ -0,0 +1,169 @@
+// This file provides decoding of Netpbm images at reduced resolution.
+
+package netpbm
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+
+	"github.com/spakin/netpbm/npcolor"
+)
+
+// decodeScaledWithComments reads a Netpbm image of any format, raw or plain,
+// and reduces its width and height by a factor of opts.Scale while reading
+// it.  Each output pixel is the average of the corresponding block of input
+// pixels.  Averaging converts PBM images to PGM unless opts.Target is PBM, in
+// which case each output pixel is instead taken from the upper-left corner
+// of its block.  If opts.Blend is LinearBlend, color channels are averaged
+// in linear light, which is not supported for color-space (SpaceM) images.
+// Only one band of opts.Scale rows is processed at a time.
+func decodeScaledWithComments(br *bufio.Reader, opts *DecodeOptions) (Image, []string, error) {
+	// Parse the header.
+	nr := newNetpbmReader(br)
+	header, err := nr.GetHeader()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = checkPlainPAM(header, opts.AllowPlainPAM); err != nil {
+		return nil, nil, err
+	}
+	rr, err := newRasterReader(nr, header)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Determine the format of the input image, and reject it if it
+	// doesn't match the requested format.
+	proto, err := imageFromHeader(header, image.Rect(0, 0, 1, 1), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.Exact && proto.Format() != opts.Target {
+		return nil, nil, fmt.Errorf("%s rejected by Decode options", proto.Format())
+	}
+	if _, ok := proto.(*SpaceM); ok && opts.Blend == LinearBlend {
+		return nil, nil, fmt.Errorf("Cannot average %s coordinates in linear light", header.TupleType)
+	}
+
+	// Prepare an output image.
+	s := opts.Scale
+	sw := (header.Width + s - 1) / s
+	sh := (header.Height + s - 1) / s
+	isPBM := proto.Format() == PBM
+	subsample := isPBM && opts.Target == PBM
+	oHeader := header
+	if isPBM && !subsample {
+		oHeader = Header{Magic: "P5", Maxval: int(opts.PBMMaxValue)}
+	}
+	img, err := imageFromHeader(oHeader, image.Rect(0, 0, sw, sh), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Process one band of rows at a time.
+	depth := rr.depth
+	row := make([]uint16, rr.RowLen())
+	sums := make([]uint64, sw*depth)
+	out := make([]uint16, sw*depth)
+	m := uint64(opts.PBMMaxValue)
+
+	// When averaging in linear light, accumulate linear-light sums instead
+	// of sample sums.  Alpha channels are always averaged directly.
+	var toLinear, linSums []float64
+	var tf npcolor.Transfer
+	var top uint16 // Largest index into toLinear
+	nColor := depth
+	if proto.HasAlpha() {
+		nColor--
+	}
+	if opts.Blend == LinearBlend && !subsample {
+		tf = defaultTransfer(opts.Transfer, proto.Format())
+		inMax := header.Maxval
+		if isPBM {
+			inMax = 1
+		}
+		top = uint16(inMax)
+		toLinear = make([]float64, inMax+1)
+		for v := range toLinear {
+			toLinear[v] = tf.ToLinear(float64(v) / float64(inMax))
+		}
+		linSums = make([]float64, sw*depth)
+	}
+	for oy := 0; oy < sh; oy++ {
+		// Accumulate the sum of each block of samples.
+		for i := range sums {
+			sums[i] = 0
+		}
+		for i := range linSums {
+			linSums[i] = 0
+		}
+		nRows := header.Height - oy*s
+		if nRows > s {
+			nRows = s
+		}
+		for k := 0; k < nRows; k++ {
+			if err = rr.ReadRow(row); err != nil {
+				return nil, nil, err
+			}
+			switch {
+			case subsample:
+				if k == 0 {
+					for ox := range out {
+						out[ox] = row[ox*s]
+					}
+				}
+			case isPBM && toLinear != nil:
+				for x, v := range row {
+					linSums[x/s] += toLinear[1-v]
+				}
+			case isPBM:
+				for x, v := range row {
+					sums[x/s] += uint64(1 - v) // PBM defines 0=white, 1=black.
+				}
+			default:
+				for x := 0; x < header.Width; x++ {
+					ofs := x / s * depth
+					for c := 0; c < depth; c++ {
+						v := row[x*depth+c]
+						if toLinear != nil && c < nColor {
+							linSums[ofs+c] += toLinear[clampSample(v, top)]
+						} else {
+							sums[ofs+c] += uint64(v)
+						}
+					}
+				}
+			}
+		}
+
+		// Average each block of samples.
+		if !subsample {
+			for ox := 0; ox < sw; ox++ {
+				nCols := header.Width - ox*s
+				if nCols > s {
+					nCols = s
+				}
+				n := uint64(nRows * nCols)
+				for c := 0; c < depth; c++ {
+					if toLinear != nil && c < nColor {
+						oMax := float64(img.MaxValue())
+						l := linSums[ox*depth+c] / float64(n)
+						out[ox*depth+c] = uint16(tf.FromLinear(l)*oMax + 0.5)
+						continue
+					}
+					v := sums[ox*depth+c]
+					if isPBM {
+						v *= m
+					}
+					out[ox*depth+c] = uint16((v + n/2) / n)
+				}
+			}
+		}
+		storeSamples(img, 0, oy, out)
+	}
+	nimg, err := convertToTarget(img, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nimg, header.Comments, nil
+}
@@ -0,0 +1,98 @@
+// Test reduced-resolution decoding.
+
+package netpbm
+
+import (
+	"bytes"
+	"compress/flate"
+	"testing"
+)
+
+// compareScaled confirms that decoding an image at reduced resolution
+// produces the box average of the full-resolution image.
+func compareScaled(t *testing.T, imgStr string, scale int, iFmt Format) {
+	// Decode the image at both full and reduced resolution.
+	full := imageFromString(t, imgStr, iFmt)
+	r := flate.NewReader(bytes.NewBufferString(imgStr))
+	defer r.Close()
+	small, err := Decode(r, &DecodeOptions{Target: PAM, Scale: scale})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Check the reduced image's size and format.
+	fb, sb := full.Bounds(), small.Bounds()
+	if sb.Dx() != (fb.Dx()+scale-1)/scale || sb.Dy() != (fb.Dy()+scale-1)/scale {
+		t.Fatalf("Scaling %v by 1/%d produced %v", fb, scale, sb)
+	}
+	expFmt := iFmt
+	if iFmt == PBM {
+		expFmt = PGM
+	}
+	if small.Format() != expFmt {
+		t.Fatalf("Expected %s but received %s", expFmt, small.Format())
+	}
+
+	// Compare a few pixels to box averages of the full image.  Work with
+	// 16-bit color values, and allow for rounding error.
+	m := uint32(small.MaxValue())
+	for _, pt := range [][2]int{{0, 0}, {3, 2}, {sb.Dx() - 1, sb.Dy() - 1}} {
+		var sum [3]uint32
+		var n uint32
+		for y := pt[1] * scale; y < (pt[1]+1)*scale && y < fb.Dy(); y++ {
+			for x := pt[0] * scale; x < (pt[0]+1)*scale && x < fb.Dx(); x++ {
+				r, g, b, _ := full.At(x, y).RGBA()
+				sum[0] += r
+				sum[1] += g
+				sum[2] += b
+				n++
+			}
+		}
+		r, g, b, _ := small.At(pt[0], pt[1]).RGBA()
+		for i, v := range []uint32{r, g, b} {
+			avg := sum[i] / n
+			delta := int64(avg) - int64(v)
+			if delta < 0 {
+				delta = -delta
+			}
+			if delta > int64(0xffff/m+1) {
+				t.Fatalf("Expected channel %d of pixel %v to be near %d but received %d", i, pt, avg, v)
+			}
+		}
+	}
+}
+
+// TestDecodeScaled tests reduced-resolution decoding of all formats.
+func TestDecodeScaled(t *testing.T) {
+	compareScaled(t, pbmRaw, 2, PBM)
+	compareScaled(t, pbmPlain, 4, PBM)
+	compareScaled(t, pgmRaw, 8, PGM)
+	compareScaled(t, pgmPlain, 3, PGM)
+	compareScaled(t, ppmRaw, 4, PPM)
+	compareScaled(t, ppmPlain, 5, PPM)
+	compareScaled(t, pamRawColor, 2, PPM)
+}
+
+// TestDecodeScaledPBM confirms that a PBM image scaled with a PBM target
+// remains a PBM image.
+func TestDecodeScaledPBM(t *testing.T) {
+	r := flate.NewReader(bytes.NewBufferString(pbmRaw))
+	defer r.Close()
+	img, err := Decode(r, &DecodeOptions{Target: PBM, Exact: true, Scale: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Format() != PBM {
+		t.Fatalf("Expected PBM but received %s", img.Format())
+	}
+	if b := img.Bounds(); b.Dx() != 16 || b.Dy() != 16 {
+		t.Fatalf("Expected a 16x16 image but received %dx%d", b.Dx(), b.Dy())
+	}
+}
+
+// TestDecodeScaledNegative confirms that a negative scale factor is rejected.
+func TestDecodeScaledNegative(t *testing.T) {
+	if _, err := Decode(bytes.NewReader([]byte("P5 2 2 255\n\x00\x01\x02\x03")), &DecodeOptions{Scale: -3}); err == nil {
+		t.Fatal("Decoding with a negative scale factor unexpectedly succeeded")
+	}
+}
@@ -0,0 +1,210 @@
+// This file provides fast conversion of standard-library images to Netpbm
+// samples.
+
+package netpbm
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/spakin/netpbm/npcolor"
+)
+
+// A sampleTarget describes the samples Encode writes for each pixel.  Its
+// conversions produce exactly the same samples as the npcolor models used by
+// the generic encoders.
+type sampleTarget struct {
+	depth int          // Number of samples per pixel
+	bits  bool         // true=PBM color indexes; false=samples scaled to m
+	m     uint32       // Maximum sample value
+	luma  npcolor.Luma // Formula for computing grayscale samples
+}
+
+// alpha reports whether the target's samples include an alpha channel.  Such
+// samples are computed from non-alpha-premultiplied colors.  All others are
+// computed from alpha-premultiplied colors.
+func (t sampleTarget) alpha() bool {
+	return !t.bits && (t.depth == 2 || t.depth == 4)
+}
+
+// scale scales a 16-bit color channel to a sample.
+func (t sampleTarget) scale(v uint32) uint16 {
+	return uint16((v*t.m + 0xffff/2) / 0xffff)
+}
+
+// store stores the samples representing a 16-bit color into s.  The color
+// must be non-alpha-premultiplied if t.alpha() and alpha-premultiplied
+// otherwise.
+func (t sampleTarget) store(s []uint16, r, g, b, a uint32) {
+	switch {
+	case t.bits:
+		bw := color.RGBA64{R: uint16(r), G: uint16(g), B: uint16(b), A: uint16(a)}
+		s[0] = uint16(bwPalette.Index(bw))
+	case t.depth == 1:
+		s[0] = t.scale(t.luma.Y(r, g, b))
+	case t.depth == 2:
+		s[0] = t.scale(t.luma.Y(r, g, b))
+		s[1] = t.scale(a)
+	case t.depth == 3:
+		s[0] = t.scale(r)
+		s[1] = t.scale(g)
+		s[2] = t.scale(b)
+	default:
+		s[0] = t.scale(r)
+		s[1] = t.scale(g)
+		s[2] = t.scale(b)
+		s[3] = t.scale(a)
+	}
+}
+
+// storeColor stores the samples representing an arbitrary color into s by
+// way of the same npcolor models the generic encoders use.
+func (t sampleTarget) storeColor(s []uint16, c color.Color) {
+	m := uint16(t.m)
+	switch {
+	case t.bits:
+		s[0] = uint16(bwPalette.Index(c))
+	case t.depth == 1:
+		g := npcolor.GrayM32Model{M: m, Luma: t.luma}.Convert(c).(npcolor.GrayM32)
+		s[0] = g.Y
+	case t.depth == 2:
+		ga := npcolor.GrayAM48Model{M: m, Luma: t.luma}.Convert(c).(npcolor.GrayAM48)
+		s[0], s[1] = ga.Y, ga.A
+	case t.depth == 3:
+		rgb := npcolor.RGBM64Model{M: m}.Convert(c).(npcolor.RGBM64)
+		s[0], s[1], s[2] = rgb.R, rgb.G, rgb.B
+	default:
+		rgba := npcolor.RGBAM64Model{M: m}.Convert(c).(npcolor.RGBAM64)
+		s[0], s[1], s[2], s[3] = rgba.R, rgba.G, rgba.B, rgba.A
+	}
+}
+
+// table returns the samples representing each of a list of colors, stored
+// consecutively.
+func (t sampleTarget) table(colors []color.Color) []uint16 {
+	tbl := make([]uint16, len(colors)*t.depth)
+	for i, c := range colors {
+		t.storeColor(tbl[i*t.depth:], c)
+	}
+	return tbl
+}
+
+// bwPalette is the color model of a BW image.
+var bwPalette = NewBW(image.ZR).ColorModel().(color.Palette)
+
+// gray8Colors lists every color.Gray value.
+var gray8Colors = func() []color.Color {
+	colors := make([]color.Color, 256)
+	for i := range colors {
+		colors[i] = color.Gray{Y: uint8(i)}
+	}
+	return colors
+}()
+
+// stdRowSampler returns a rowSampler that reads samples directly from the
+// pixel buffer of an *image.RGBA, *image.NRGBA, *image.Gray, *image.Gray16,
+// *image.YCbCr, or *image.Paletted, bypassing the per-pixel color.Color
+// interface.  It returns nil for any other type of image.  depth, bits, and
+// maxVal are as in writeRaster, and luma computes grayscale samples.  The
+// samples are identical to those produced by the generic encoders.
+func stdRowSampler(img image.Image, depth int, bits bool, maxVal uint16, luma npcolor.Luma) rowSampler {
+	t := sampleTarget{depth: depth, bits: bits, m: uint32(maxVal), luma: luma}
+	rect := img.Bounds()
+	switch img := img.(type) {
+	case *image.Gray:
+		// Look up each gray level's samples.
+		tbl := t.table(gray8Colors)
+		return func(y int, row []uint16) {
+			pix := img.Pix[img.PixOffset(rect.Min.X, y):]
+			for x := range row[:rect.Dx()] {
+				copy(row[x*depth:(x+1)*depth], tbl[int(pix[x])*depth:])
+			}
+		}
+
+	case *image.Paletted:
+		// Look up each color index's samples.
+		if len(img.Palette) == 0 {
+			return nil
+		}
+		tbl := t.table(img.Palette)
+		return func(y int, row []uint16) {
+			pix := img.Pix[img.PixOffset(rect.Min.X, y):]
+			for x := range row[:rect.Dx()] {
+				copy(row[x*depth:(x+1)*depth], tbl[int(pix[x])*depth:])
+			}
+		}
+
+	case *image.RGBA:
+		// Opaque pixels need no arithmetic beyond scaling.
+		var scale8 [256]uint16
+		for i := range scale8 {
+			scale8[i] = t.scale(uint32(i) * 0x101)
+		}
+		return func(y int, row []uint16) {
+			pix := img.Pix[img.PixOffset(rect.Min.X, y):]
+			for x := range row[:rect.Dx()] {
+				p := pix[x*4 : x*4+4]
+				s := row[x*depth : (x+1)*depth]
+				switch {
+				case depth == 3:
+					s[0], s[1], s[2] = scale8[p[0]], scale8[p[1]], scale8[p[2]]
+				case depth == 4 && p[3] == 0xff:
+					s[0], s[1], s[2], s[3] = scale8[p[0]], scale8[p[1]], scale8[p[2]], scale8[0xff]
+				case t.alpha():
+					c := color.NRGBA64Model.Convert(color.RGBA{R: p[0], G: p[1], B: p[2], A: p[3]}).(color.NRGBA64)
+					t.store(s, uint32(c.R), uint32(c.G), uint32(c.B), uint32(c.A))
+				default:
+					r, g, b, a := color.RGBA{R: p[0], G: p[1], B: p[2], A: p[3]}.RGBA()
+					t.store(s, r, g, b, a)
+				}
+			}
+		}
+
+	case *image.NRGBA:
+		// Non-alpha-premultiplied pixels need no arithmetic beyond
+		// scaling when the target has an alpha channel.
+		var scale8 [256]uint16
+		for i := range scale8 {
+			scale8[i] = t.scale(uint32(i) * 0x101)
+		}
+		return func(y int, row []uint16) {
+			pix := img.Pix[img.PixOffset(rect.Min.X, y):]
+			for x := range row[:rect.Dx()] {
+				p := pix[x*4 : x*4+4]
+				s := row[x*depth : (x+1)*depth]
+				switch {
+				case depth == 4:
+					s[0], s[1], s[2], s[3] = scale8[p[0]], scale8[p[1]], scale8[p[2]], scale8[p[3]]
+				case depth == 3 && p[3] == 0xff:
+					s[0], s[1], s[2] = scale8[p[0]], scale8[p[1]], scale8[p[2]]
+				case t.alpha():
+					t.store(s, uint32(p[0])*0x101, uint32(p[1])*0x101, uint32(p[2])*0x101, uint32(p[3])*0x101)
+				default:
+					r, g, b, a := color.NRGBA{R: p[0], G: p[1], B: p[2], A: p[3]}.RGBA()
+					t.store(s, r, g, b, a)
+				}
+			}
+		}
+
+	case *image.Gray16:
+		// Gray16 pixels are always opaque.
+		return func(y int, row []uint16) {
+			pix := img.Pix[img.PixOffset(rect.Min.X, y):]
+			for x := range row[:rect.Dx()] {
+				v := uint32(pix[x*2])<<8 | uint32(pix[x*2+1])
+				t.store(row[x*depth:], v, v, v, 0xffff)
+			}
+		}
+
+	case *image.YCbCr:
+		// YCbCr pixels are always opaque.
+		return func(y int, row []uint16) {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				yi, ci := img.YOffset(x, y), img.COffset(x, y)
+				r, g, b, a := color.YCbCr{Y: img.Y[yi], Cb: img.Cb[ci], Cr: img.Cr[ci]}.RGBA()
+				t.store(row[(x-rect.Min.X)*depth:], r, g, b, a)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+// Test fast encoding of standard-library images.
+
+package netpbm
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// An opaqueImage hides an image's concrete type, forcing Encode to take the
+// generic, per-pixel path.
+type opaqueImage struct {
+	image.Image
+}
+
+// randomStdImages returns one of each standard-library image type that
+// Encode handles specially, each filled with random pixels and each a
+// subimage of a larger image.
+func randomStdImages() []image.Image {
+	rng := rand.New(rand.NewSource(38))
+	big := image.Rect(0, 0, 40, 30)
+	sub := image.Rect(3, 5, 37, 27)
+	rgba := image.NewRGBA(big)
+	rng.Read(rgba.Pix)
+	for i := 3; i < len(rgba.Pix); i += 4 {
+		// Premultiplied colors must not exceed alpha.  Make
+		// some pixels opaque and some transparent.
+		switch i % 3 {
+		case 0:
+			rgba.Pix[i] = 0xff
+		case 1:
+			rgba.Pix[i] = 0
+		}
+		for c := i - 3; c < i; c++ {
+			if rgba.Pix[c] > rgba.Pix[i] {
+				rgba.Pix[c] = rgba.Pix[i]
+			}
+		}
+	}
+	nrgba := image.NewNRGBA(big)
+	rng.Read(nrgba.Pix)
+	for i := 3; i < len(nrgba.Pix); i += 12 {
+		nrgba.Pix[i] = 0xff
+	}
+	gray := image.NewGray(big)
+	rng.Read(gray.Pix)
+	gray16 := image.NewGray16(big)
+	rng.Read(gray16.Pix)
+	pal := image.NewPaletted(big, palette.Plan9)
+	rng.Read(pal.Pix)
+	pal2 := image.NewPaletted(big, color.Palette{
+		color.NRGBA{R: 10, G: 200, B: 30, A: 40},
+		color.RGBA{R: 5, G: 6, B: 7, A: 8},
+		color.Gray16{Y: 12345},
+		color.White,
+	})
+	for i := range pal2.Pix {
+		pal2.Pix[i] = uint8(rng.Intn(len(pal2.Palette)))
+	}
+	imgs := []image.Image{
+		rgba.SubImage(sub),
+		nrgba.SubImage(sub),
+		gray.SubImage(sub),
+		gray16.SubImage(sub),
+		pal.SubImage(sub),
+		pal2.SubImage(sub),
+	}
+	for _, ratio := range []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio444,
+		image.YCbCrSubsampleRatio422,
+		image.YCbCrSubsampleRatio420,
+	} {
+		ycc := image.NewYCbCr(big, ratio)
+		rng.Read(ycc.Y)
+		rng.Read(ycc.Cb)
+		rng.Read(ycc.Cr)
+		imgs = append(imgs, ycc.SubImage(sub))
+	}
+	return imgs
+}
+
+// TestEncodeStdlib confirms that encoding a standard-library image produces
+// the same output as encoding it by way of the generic path.
+func TestEncodeStdlib(t *testing.T) {
+	type target struct {
+		format Format
+		ttype  string
+	}
+	targets := []target{
+		{PBM, ""},
+		{PGM, ""},
+		{PPM, ""},
+		{PAM, "BLACKANDWHITE"},
+		{PAM, "BLACKANDWHITE_ALPHA"},
+		{PAM, "GRAYSCALE"},
+		{PAM, "GRAYSCALE_ALPHA"},
+		{PAM, "RGB"},
+		{PAM, "RGB_ALPHA"},
+	}
+	for _, img := range randomStdImages() {
+		for _, tgt := range targets {
+			for _, maxVal := range []uint16{0, 1, 7, 255, 1000, 65535} {
+				opts := &EncodeOptions{
+					Format:    tgt.format,
+					MaxValue:  maxVal,
+					TupleType: tgt.ttype,
+				}
+				var fast, slow bytes.Buffer
+				if err := Encode(&fast, img, opts); err != nil {
+					t.Fatal(err)
+				}
+				if err := Encode(&slow, opaqueImage{img}, opts); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(fast.Bytes(), slow.Bytes()) {
+					t.Fatalf("Fast and generic encodings of a %T as %s %s with maximum value %d differ",
+						img, tgt.format, tgt.ttype, maxVal)
+				}
+			}
+		}
+	}
+}
+
+// TestEncodeStdlibUnpremult confirms that encoding an *image.NRGBA as PAM
+// preserves its non-alpha-premultiplied color channels.
+func TestEncodeStdlibUnpremult(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 200, G: 100, B: 50, A: 3})
+	img.SetNRGBA(1, 0, color.NRGBA{R: 1, G: 2, B: 3, A: 254})
+	var w bytes.Buffer
+	if err := Encode(&w, img, &EncodeOptions{Format: PAM}); err != nil {
+		t.Fatal(err)
+	}
+	img2, err := Decode(&w, &DecodeOptions{Target: PAM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x := 0; x < 2; x++ {
+		c1 := img.NRGBAAt(x, 0)
+		c2 := img2.(*RGBAM).RGBAMAt(x, 0)
+		if c1.R != c2.R || c1.G != c2.G || c1.B != c2.B || c1.A != c2.A {
+			t.Fatalf("Expected %v at (%d, 0) but saw %v", c1, x, c2)
+		}
+	}
+}
+
+// BenchmarkEncodeStdlib measures the time to encode an *image.RGBA as a PPM
+// file by way of the fast and generic paths.
+func BenchmarkEncodeStdlib(b *testing.B) {
+	img := image.NewRGBA(image.Rect(0, 0, 1024, 1024))
+	rand.New(rand.NewSource(38)).Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xff
+	}
+	for _, path := range []struct {
+		name string
+		img  image.Image
+	}{
+		{"fast", img},
+		{"generic", opaqueImage{img}},
+	} {
+		b.Run(fmt.Sprintf("path=%s", path.name), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := Encode(ioutil.Discard, path.img, &EncodeOptions{Format: PPM}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
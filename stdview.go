@@ -0,0 +1,74 @@
+// This file provides zero-copy views between Netpbm images and the
+// standard-library images that share their pixel layout.
+
+package netpbm
+
+import (
+	"image"
+
+	"github.com/spakin/netpbm/npcolor"
+)
+
+// AsStd returns an *image.Gray that shares pixels with p and a success code.
+// The conversion succeeds only if p's maximum value is 255, which gives both
+// images the same layout.
+func (p *GrayM) AsStd() (*image.Gray, bool) {
+	if p.Model.M != 255 {
+		return nil, false
+	}
+	return &image.Gray{Pix: p.Pix, Stride: p.Stride, Rect: p.Rect}, true
+}
+
+// WrapGray returns a GrayM with a maximum value of 255 that shares pixels
+// with img.
+func WrapGray(img *image.Gray) *GrayM {
+	return &GrayM{img.Pix, img.Stride, img.Rect, npcolor.GrayMModel{M: 255}}
+}
+
+// AsStd returns an *image.Gray16 that shares pixels with p and a success
+// code.  The conversion succeeds only if p's maximum value is 65535, which
+// gives both images the same layout.
+func (p *GrayM32) AsStd() (*image.Gray16, bool) {
+	if p.Model.M != 65535 {
+		return nil, false
+	}
+	return &image.Gray16{Pix: p.Pix, Stride: p.Stride, Rect: p.Rect}, true
+}
+
+// WrapGray16 returns a GrayM32 with a maximum value of 65535 that shares
+// pixels with img.
+func WrapGray16(img *image.Gray16) *GrayM32 {
+	return &GrayM32{img.Pix, img.Stride, img.Rect, npcolor.GrayM32Model{M: 65535}}
+}
+
+// AsStd returns an *image.NRGBA that shares pixels with p and a success code.
+// The conversion succeeds only if p's maximum value is 255, which gives both
+// images the same layout.
+func (p *RGBAM) AsStd() (*image.NRGBA, bool) {
+	if p.Model.M != 255 {
+		return nil, false
+	}
+	return &image.NRGBA{Pix: p.Pix, Stride: p.Stride, Rect: p.Rect}, true
+}
+
+// WrapNRGBA returns an RGBAM with a maximum value of 255 that shares pixels
+// with img.
+func WrapNRGBA(img *image.NRGBA) *RGBAM {
+	return &RGBAM{img.Pix, img.Stride, img.Rect, npcolor.RGBAMModel{M: 255}}
+}
+
+// AsStd returns an *image.NRGBA64 that shares pixels with p and a success
+// code.  The conversion succeeds only if p's maximum value is 65535, which
+// gives both images the same layout.
+func (p *RGBAM64) AsStd() (*image.NRGBA64, bool) {
+	if p.Model.M != 65535 {
+		return nil, false
+	}
+	return &image.NRGBA64{Pix: p.Pix, Stride: p.Stride, Rect: p.Rect}, true
+}
+
+// WrapNRGBA64 returns an RGBAM64 with a maximum value of 65535 that shares
+// pixels with img.
+func WrapNRGBA64(img *image.NRGBA64) *RGBAM64 {
+	return &RGBAM64{img.Pix, img.Stride, img.Rect, npcolor.RGBAM64Model{M: 65535}}
+}
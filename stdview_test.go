@@ -0,0 +1,118 @@
+// Test zero-copy views between Netpbm and standard-library images.
+
+package netpbm
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// TestAsStd confirms that AsStd views share pixels with the original image
+// and are refused for incompatible maximum values.
+func TestAsStd(t *testing.T) {
+	r := image.Rect(1, 2, 5, 4)
+	c := color.NRGBA{R: 200, G: 100, B: 50, A: 128}
+
+	// Confirm that each view aliases its image's pixels.
+	gray := NewGrayM(r, 255)
+	if v, ok := gray.AsStd(); !ok || &v.Pix[0] != &gray.Pix[0] || v.Rect != r {
+		t.Fatal("Failed to view a GrayM as an image.Gray")
+	} else {
+		v.Set(2, 3, c)
+		if gray.GrayMAt(2, 3).Y != v.GrayAt(2, 3).Y {
+			t.Fatalf("Expected %v but saw %v", v.GrayAt(2, 3), gray.GrayMAt(2, 3))
+		}
+	}
+	gray16 := NewGrayM32(r, 65535)
+	if v, ok := gray16.AsStd(); !ok || &v.Pix[0] != &gray16.Pix[0] || v.Rect != r {
+		t.Fatal("Failed to view a GrayM32 as an image.Gray16")
+	}
+	rgba := NewRGBAM(r, 255)
+	if v, ok := rgba.AsStd(); !ok || &v.Pix[0] != &rgba.Pix[0] || v.Rect != r {
+		t.Fatal("Failed to view an RGBAM as an image.NRGBA")
+	} else {
+		v.Set(2, 3, c)
+		if e, a := c, rgba.RGBAMAt(2, 3); a.R != e.R || a.G != e.G || a.B != e.B || a.A != e.A {
+			t.Fatalf("Expected %v but saw %v", e, a)
+		}
+	}
+	rgba64 := NewRGBAM64(r, 65535)
+	if v, ok := rgba64.AsStd(); !ok || &v.Pix[0] != &rgba64.Pix[0] || v.Rect != r {
+		t.Fatal("Failed to view an RGBAM64 as an image.NRGBA64")
+	}
+
+	// Views of subimages should cover only the subimage.
+	sub := rgba.SubImage(image.Rect(2, 3, 4, 4)).(*RGBAM)
+	if v, ok := sub.AsStd(); !ok || v.NRGBAAt(2, 3) != c {
+		t.Fatalf("Expected %v in subimage view", c)
+	}
+
+	// Other maximum values should be refused.
+	if _, ok := NewGrayM(r, 100).AsStd(); ok {
+		t.Fatal("Unexpectedly viewed a GrayM with maxval 100 as an image.Gray")
+	}
+	if _, ok := NewGrayM32(r, 1023).AsStd(); ok {
+		t.Fatal("Unexpectedly viewed a GrayM32 with maxval 1023 as an image.Gray16")
+	}
+	if _, ok := NewRGBAM(r, 15).AsStd(); ok {
+		t.Fatal("Unexpectedly viewed an RGBAM with maxval 15 as an image.NRGBA")
+	}
+	if _, ok := NewRGBAM64(r, 4095).AsStd(); ok {
+		t.Fatal("Unexpectedly viewed an RGBAM64 with maxval 4095 as an image.NRGBA64")
+	}
+}
+
+// TestWrapStd confirms that wrapped standard-library images share pixels
+// with the original and encode identically.
+func TestWrapStd(t *testing.T) {
+	r := image.Rect(0, 0, 5, 3)
+	nrgba := image.NewNRGBA(r)
+	nrgba64 := image.NewNRGBA64(r)
+	gray := image.NewGray(r)
+	gray16 := image.NewGray16(r)
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 5; x++ {
+			c := color.NRGBA{uint8(x * 50), uint8(y * 100), 77, uint8(255 - x*20)}
+			nrgba.Set(x, y, c)
+			nrgba64.Set(x, y, c)
+			gray.Set(x, y, c)
+			gray16.Set(x, y, c)
+		}
+	}
+	for _, tc := range []struct {
+		std  image.Image
+		wrap Image
+	}{
+		{gray, WrapGray(gray)},
+		{gray16, WrapGray16(gray16)},
+		{nrgba, WrapNRGBA(nrgba)},
+		{nrgba64, WrapNRGBA64(nrgba64)},
+	} {
+		// Encoding the wrapped image should produce the same file as
+		// encoding the original.
+		var w1, w2 bytes.Buffer
+		opts := &EncodeOptions{Format: PAM, MaxValue: tc.wrap.MaxValue()}
+		if err := Encode(&w1, tc.std, opts); err != nil {
+			t.Fatal(err)
+		}
+		if err := Encode(&w2, tc.wrap, opts); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(w1.Bytes(), w2.Bytes()) {
+			t.Fatalf("%T: expected %v but saw %v", tc.std, w1.Bytes(), w2.Bytes())
+		}
+	}
+
+	// A wrapped image's view should be the original image, which
+	// image/png can then encode.
+	v, ok := WrapNRGBA(nrgba).AsStd()
+	if !ok || &v.Pix[0] != &nrgba.Pix[0] {
+		t.Fatal("Failed to round-trip an image.NRGBA")
+	}
+	if err := png.Encode(&bytes.Buffer{}, v); err != nil {
+		t.Fatal(err)
+	}
+}
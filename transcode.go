@@ -0,0 +1,199 @@
+// This file provides streaming conversion of Netpbm files among the raw,
+// plain, and PAM encodings.
+
+package netpbm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"unicode"
+)
+
+// TranscodeOptions represents a list of options for transcoding a Netpbm
+// stream.
+type TranscodeOptions struct {
+	Format        Format      // Output format (PNM=same as the input; PBM, PGM, or PPM=equivalent PNM format; PAM=equivalent PAM tuple type)
+	MaxValue      uint16      // Maximum value for each output channel (0=same as the input; ignored for PBM)
+	Plain         bool        // true="plain" (ASCII) output; false="raw" (binary) output
+	AllowPlainPAM bool        // true=read and write nonstandard "plain" (ASCII) PAM files; false=reject them
+	Layout        PlainLayout // Arrangement of samples in plain output
+	Metadata      *Metadata   // Key/value pairs to set in each image's header comments and ordinary comments to append to them
+}
+
+// headerFormat returns the format described by a header, mapping PAM files
+// with a PBM, PGM, or PPM tuple type to the equivalent PNM format.
+func headerFormat(header Header) Format {
+	switch header.Magic {
+	case "P1", "P4":
+		return PBM
+	case "P2", "P5":
+		return PGM
+	case "P3", "P6":
+		return PPM
+	}
+	return tupleTypeToFormat(header.TupleType)
+}
+
+// transcodeHeader returns the header of a transcoded image.
+func transcodeHeader(in Header, opts *TranscodeOptions) (Header, error) {
+	// Determine the output format.
+	inFmt := headerFormat(in)
+	outFmt := opts.Format
+	switch {
+	case outFmt == PNM && in.Magic == "P7":
+		outFmt = PAM
+	case outFmt == PNM:
+		outFmt = inFmt
+	case outFmt != PAM && outFmt != inFmt:
+		return Header{}, fmt.Errorf("Cannot transcode %s to %s", inFmt, outFmt)
+	}
+
+	// Prepare the output header.
+	out := Header{
+		Width:    in.Width,
+		Height:   in.Height,
+		Depth:    in.Depth,
+		Maxval:   in.Maxval,
+		Comments: in.Comments,
+		Plain:    opts.Plain,
+	}
+	if opts.MaxValue != 0 {
+		out.Maxval = int(opts.MaxValue)
+	}
+	if opts.Metadata != nil {
+		out.Comments = opts.Metadata.mergeComments(in.Comments)
+	}
+	switch outFmt {
+	case PBM:
+		out.Magic = "P4"
+		out.Maxval = 1
+	case PGM:
+		out.Magic = "P5"
+	case PPM:
+		out.Magic = "P6"
+	case PAM:
+		if opts.Plain && !opts.AllowPlainPAM {
+			return Header{}, errors.New("Plain PAM is not a standard Netpbm format; set AllowPlainPAM to write it anyway")
+		}
+		out.Magic = "P7"
+		out.TupleType = in.TupleType
+		switch in.Magic {
+		case "P1", "P4":
+			out.TupleType = "BLACKANDWHITE"
+		case "P2", "P5":
+			out.TupleType = "GRAYSCALE"
+		case "P3", "P6":
+			out.TupleType = "RGB"
+		}
+		if out.TupleType == "BLACKANDWHITE" || out.TupleType == "BLACKANDWHITE_ALPHA" {
+			out.Maxval = 1
+		}
+	default:
+		return Header{}, fmt.Errorf("Invalid Netpbm format specified (%s)", outFmt)
+	}
+	if opts.Plain && out.Magic != "P7" {
+		out.Magic = string([]byte{'P', out.Magic[1] - 3})
+	}
+	return out, nil
+}
+
+// Transcode copies a stream of one or more Netpbm images from src to dst,
+// converting each between raw and plain encodings, between maximum values,
+// and between PNM formats and the equivalent PAM tuple types (BLACKANDWHITE,
+// GRAYSCALE, and RGB).  Images are processed one row at a time, so memory
+// usage is independent of image size.  Header comments are preserved, except
+// that opts.Metadata's key/value pairs replace any with the same keys (see
+// Metadata.Set).  Changing the maximum value scales each sample
+// proportionally, rounding to the nearest integer.  Given a nil opts,
+// Transcode converts each image to its raw encoding.
+func Transcode(dst io.Writer, src io.Reader, opts *TranscodeOptions) error {
+	var o TranscodeOptions
+	if opts != nil {
+		o = *opts
+	}
+	br, ok := src.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(src)
+	}
+	wb := bufio.NewWriter(dst)
+	nr := newNetpbmReader(br)
+	for {
+		// Skip the whitespace and comments that may follow a plain
+		// raster, and stop at the end of the stream.
+		c, err := br.ReadByte()
+		for err == nil && (unicode.IsSpace(rune(c)) || c == '#') {
+			if c == '#' {
+				_, err = br.ReadString('\n')
+				if err != nil {
+					break
+				}
+			}
+			c, err = br.ReadByte()
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		br.UnreadByte()
+
+		// Read the input header and write the output header.
+		in, err := nr.GetHeader()
+		if err != nil {
+			return err
+		}
+		if err = checkPlainPAM(in, o.AllowPlainPAM); err != nil {
+			return err
+		}
+		out, err := transcodeHeader(in, &o)
+		if err != nil {
+			return err
+		}
+		if err = EncodeHeader(wb, out); err != nil {
+			return err
+		}
+
+		// Transcode the raster one row at a time.
+		rr, err := newRasterReader(nr, in)
+		if err != nil {
+			return err
+		}
+		rw := newRasterWriter(wb, out, o.Layout)
+		invert := (in.Magic == "P1" || in.Magic == "P4") != (out.Magic == "P1" || out.Magic == "P4")
+		inMax, outMax := uint32(in.Maxval), uint32(out.Maxval)
+		row := make([]uint16, rr.RowLen())
+		for y := 0; y < in.Height; y++ {
+			if err = rr.ReadRow(row); err != nil {
+				return err
+			}
+			switch {
+			case invert:
+				// PBM uses 1=black; PAM uses 0=black.
+				for i, s := range row {
+					row[i] = 1 - s
+				}
+			case inMax != outMax:
+				// Raw samples may exceed the input's maximum
+				// value and must be clamped before scaling.
+				for i, s := range row {
+					s = clampSample(s, uint16(inMax))
+					row[i] = uint16((uint32(s)*outMax + inMax/2) / inMax)
+				}
+			default:
+				for i, s := range row {
+					row[i] = clampSample(s, uint16(inMax))
+				}
+			}
+			if err = rw.WriteRow(row); err != nil {
+				return err
+			}
+		}
+		if err = rw.Close(); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
@@ -0,0 +1,161 @@
+// Test streaming conversion of Netpbm files.
+
+package netpbm
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// TestTranscodeMatchesEncode confirms that transcoding an image produces the
+// same bytes as decoding the image and re-encoding it.
+func TestTranscodeMatchesEncode(t *testing.T) {
+	for _, imgStr := range []string{pbmRaw, pbmPlain, pgmRaw, pgmPlain, ppmRaw, ppmPlain, pamRawColorAlpha} {
+		data := decompressString(t, imgStr)
+		for _, plain := range []bool{false, true} {
+			for _, format := range []Format{PNM, PAM} {
+				for _, layout := range []PlainLayout{{}, {Compact: true, RowPerLine: true}, {Align: true, LineWidth: 40}} {
+					opts := &TranscodeOptions{Format: format, Plain: plain, Layout: layout}
+					if plain && (format == PAM || imgStr == pamRawColorAlpha) {
+						continue
+					}
+
+					// Transcode the image.
+					var tw bytes.Buffer
+					if err := Transcode(&tw, bytes.NewReader(data), opts); err != nil {
+						t.Fatal(err)
+					}
+
+					// Decode and re-encode the image.
+					img, info, err := DecodeWithInfo(bytes.NewReader(data), &DecodeOptions{Target: PAM})
+					if err != nil {
+						t.Fatal(err)
+					}
+					// Transcode always formats headers with
+					// EncodeHeader.
+					info.Plain = plain
+					info.Layout = layout
+					info.HeaderText = ""
+					if format == PAM {
+						info.Format = PAM
+					}
+					var ew bytes.Buffer
+					if err = Encode(&ew, img, &info); err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(tw.Bytes(), ew.Bytes()) {
+						t.Fatalf("Transcoding %s with %+v produced different output from re-encoding", info.Format, *opts)
+					}
+				}
+			}
+		}
+	}
+}
+
+// transcodeBytes is a helper function that transcodes a byte slice.
+func transcodeBytes(t *testing.T, data []byte, opts *TranscodeOptions) []byte {
+	var w bytes.Buffer
+	if err := Transcode(&w, bytes.NewReader(data), opts); err != nil {
+		t.Fatal(err)
+	}
+	return w.Bytes()
+}
+
+// TestTranscodeStream confirms that a multi-image stream survives round trips
+// from raw to plain and from PNM to PAM.
+func TestTranscodeStream(t *testing.T) {
+	// Round-trip a raw PNM stream through plain.
+	var stream bytes.Buffer
+	for _, imgStr := range []string{pgmRaw, pbmRaw, ppmRaw} {
+		stream.Write(decompressString(t, imgStr))
+	}
+	plain := transcodeBytes(t, stream.Bytes(), &TranscodeOptions{Plain: true})
+	if raw := transcodeBytes(t, plain, nil); !bytes.Equal(stream.Bytes(), raw) {
+		t.Fatal("Raw to plain to raw transcoding altered the stream")
+	}
+
+	// Round-trip a raw PPM stream through PAM.
+	stream.Reset()
+	stream.Write(decompressString(t, ppmRaw))
+	stream.Write(decompressString(t, ppmRaw))
+	pam := transcodeBytes(t, stream.Bytes(), &TranscodeOptions{Format: PAM})
+	if raw := transcodeBytes(t, pam, &TranscodeOptions{Format: PPM}); !bytes.Equal(stream.Bytes(), raw) {
+		t.Fatal("PPM to PAM to PPM transcoding altered the stream")
+	}
+
+	// Ensure that images lacking a PNM equivalent are rejected.
+	alpha := decompressString(t, pamRawColorAlpha)
+	for _, opts := range []*TranscodeOptions{{Plain: true}, {Format: PPM}} {
+		var w bytes.Buffer
+		if err := Transcode(&w, bytes.NewReader(alpha), opts); err == nil {
+			t.Fatalf("Transcoding RGB_ALPHA with %+v unexpectedly succeeded", *opts)
+		}
+	}
+}
+
+// TestTranscodeMaxValue confirms that transcoding to a different maximum
+// value scales each sample.
+func TestTranscodeMaxValue(t *testing.T) {
+	img0 := imageFromString(t, pgmRaw, PGM).(*GrayM)
+	data := transcodeBytes(t, decompressString(t, pgmRaw), &TranscodeOptions{MaxValue: 1000})
+	img, err := Decode(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img1, ok := img.(*GrayM32)
+	if !ok || img1.MaxValue() != 1000 {
+		t.Fatalf("Expected a GrayM32 with maximum value 1000 but received a %T with maximum value %d", img, img.MaxValue())
+	}
+	b := img0.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			y0 := uint32(img0.GrayMAt(x, y).Y)
+			want := uint16((y0*1000 + 127) / 255)
+			if got := img1.GrayM32At(x, y).Y; got != want {
+				t.Fatalf("Expected %d at (%d, %d) but saw %d", want, x, y, got)
+			}
+		}
+	}
+}
+
+// TestTranscodeOutOfRange confirms that samples exceeding a raw file's maximum
+// value are transcoded as the maximum value.
+func TestTranscodeOutOfRange(t *testing.T) {
+	// Scale 16-bit samples to a new maximum value.
+	data := transcodeBytes(t, []byte("P5 4 1 1000\n\x13\x88\x04\xb0\x03\xe8\x01\xf4"), &TranscodeOptions{MaxValue: 65535})
+	if e := "P5\n4 1\n65535\n\xff\xff\xff\xff\xff\xff\x80\x00"; string(data) != e {
+		t.Fatalf("Expected %q but saw %q", e, data)
+	}
+
+	// Write 8-bit samples in plain format with the same maximum value.
+	data = transcodeBytes(t, []byte("P5 2 1 100\n\xc8\x32"), &TranscodeOptions{Plain: true})
+	if e := "P2\n2 1\n100\n100 50\n"; string(data) != e {
+		t.Fatalf("Expected %q but saw %q", e, data)
+	}
+}
+
+// TestTranscodeMetadata confirms that transcoding updates each image's
+// metadata while preserving its other comments.
+func TestTranscodeMetadata(t *testing.T) {
+	in := "P2 # Original\n# software: old\n# exposure: 1/60\n1 1 9 3\nP5\n1 1 255\n\x80"
+	var md Metadata
+	md.Set("software", "transcoder")
+	md.Set("notes", "Line 1\nLine 2")
+	md.Fields = append(md.Fields, MetadataField{Value: "Transcoded"})
+	data := transcodeBytes(t, []byte(in), &TranscodeOptions{Metadata: &md})
+	r := bufio.NewReader(bytes.NewReader(data))
+	for i, e := range [][]string{
+		{"Original", "software: transcoder", "exposure: 1/60", `notes: Line 1\nLine 2`, "Transcoded"},
+		{"software: transcoder", `notes: Line 1\nLine 2`, "Transcoded"},
+	} {
+		_, cmts, err := DecodeWithComments(r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(cmts, e) {
+			t.Fatalf("Image %d: expected %q but saw %q", i+1, e, cmts)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// This file provides conversion of images between transfer functions.
+
+package netpbm
+
+import (
+	"image"
+	"reflect"
+	"strings"
+
+	"github.com/spakin/netpbm/npcolor"
+)
+
+// defaultTransfer returns tf if it is non-nil or, if not, the transfer
+// function that a file of the given format is assumed to use: linear light
+// for PFM and BT.709, as the Netpbm specification prescribes, otherwise.
+func defaultTransfer(tf npcolor.Transfer, f Format) npcolor.Transfer {
+	switch {
+	case tf != nil:
+		return tf
+	case f == PFM:
+		return npcolor.Linear
+	default:
+		return npcolor.BT709
+	}
+}
+
+// sameTransfer reports whether two transfer functions are known to be
+// identical.
+func sameTransfer(a, b npcolor.Transfer) bool {
+	t := reflect.TypeOf(a)
+	return t == reflect.TypeOf(b) && t.Comparable() && a == b
+}
+
+// transferTable returns a table that maps each sample in [0, m] from one
+// transfer function to another.
+func transferTable(m uint16, from, to npcolor.Transfer) []uint16 {
+	mf := float64(m)
+	tbl := make([]uint16, int(m)+1)
+	for v := range tbl {
+		s := to.FromLinear(from.ToLinear(float64(v)/mf))*mf + 0.5
+		switch {
+		case s >= mf:
+			tbl[v] = m
+		case s > 0:
+			tbl[v] = uint16(s)
+		}
+	}
+	return tbl
+}
+
+// clampSample returns v or, if v exceeds m, m.  Raw files may contain samples
+// greater than their maximum value, which must not be scaled or used to index
+// a table of [0, m] entries.
+func clampSample(v, m uint16) uint16 {
+	if v > m {
+		return m
+	}
+	return v
+}
+
+// ConvertTransfer returns a copy of img whose color channels, which are
+// encoded with transfer function from, are re-encoded with transfer function
+// to.  Alpha channels are copied unmodified.  If img is a Netpbm image, the
+// result has the same maximum value and the 8-bit or 16-bit type that value
+// calls for.  Otherwise, the result is a GrayM32, GrayAM48, RGBM64, or
+// RGBAM64 with a maximum value of 65535.  Black-and-white images are
+// unaffected by any transfer function.  SpaceM images are converted to RGB,
+// re-encoded, and converted back to their color space.
+func ConvertTransfer(img image.Image, from, to npcolor.Transfer) Image {
+	rect := img.Bounds()
+
+	// Floating-point images are converted directly, and black-and-white
+	// images are merely copied.
+	switch img := img.(type) {
+	case *GrayF32:
+		fimg := NewGrayF32(rect)
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			copy(fimg.Pix[fimg.PixOffset(rect.Min.X, y):fimg.PixOffset(rect.Max.X, y)],
+				img.Pix[img.PixOffset(rect.Min.X, y):])
+		}
+		convertFloatTransfer(fimg.Pix, from, to)
+		return fimg
+	case *RGBF32:
+		fimg := NewRGBF32(rect)
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			copy(fimg.Pix[fimg.PixOffset(rect.Min.X, y):fimg.PixOffset(rect.Max.X, y)],
+				img.Pix[img.PixOffset(rect.Min.X, y):])
+		}
+		convertFloatTransfer(fimg.Pix, from, to)
+		return fimg
+	case *BW:
+		bw := NewBW(rect)
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			copy(bw.Pix[bw.PixOffset(rect.Min.X, y):bw.PixOffset(rect.Max.X, y)],
+				img.Pix[img.PixOffset(rect.Min.X, y):])
+		}
+		return bw
+	case *PackedBW:
+		bw := NewPackedBW(rect)
+		bw.Palette = img.Palette
+		bw.Or(img)
+		return bw
+	case *SpaceM:
+		// Color-space coordinates are not encoded with a transfer
+		// function, so re-encode the colors they represent.
+		rgb := NewRGBM64(rect, 0xffff)
+		copyPixels(rgb, img)
+		sp := NewSpaceM(rect, img.Model.Space, img.Model.M)
+		copyPixels(sp, ConvertTransfer(rgb, from, to))
+		return sp
+	}
+
+	// Allocate an integer image to hold the result.
+	tt := strings.Replace(inferTupleType(img.ColorModel()), "BLACKANDWHITE", "GRAYSCALE", 1)
+	var maxVal uint16 = 0xffff
+	nimg, isNetpbm := img.(Image)
+	if isNetpbm {
+		maxVal = nimg.MaxValue()
+	}
+	model := npcolor.NewModel(tt, maxVal)
+	dst := imageFromModel(rect, model)
+
+	// Copy the image.
+	dpix := pixSlice(dst)
+	if isNetpbm && reflect.TypeOf(dst) == reflect.TypeOf(img) {
+		spix := pixSlice(nimg)
+		n := rect.Dx() * model.Channels() * model.BytesPerSample()
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			i := dst.PixOffset(rect.Min.X, y)
+			copy(dpix[i:i+n], spix[nimg.PixOffset(rect.Min.X, y):])
+		}
+	} else {
+		copyPixels(dst, img)
+	}
+
+	// Re-encode every color channel.
+	depth := model.Channels()
+	nColor := depth
+	if model.HasAlpha() {
+		nColor--
+	}
+	tbl := transferTable(maxVal, from, to)
+	if model.BytesPerSample() == 1 {
+		for i := range dpix {
+			if i%depth < nColor {
+				dpix[i] = uint8(tbl[clampSample(uint16(dpix[i]), maxVal)])
+			}
+		}
+	} else {
+		for i := 0; i < len(dpix); i += 2 {
+			if i/2%depth < nColor {
+				v := tbl[clampSample(uint16(dpix[i])<<8|uint16(dpix[i+1]), maxVal)]
+				dpix[i] = uint8(v >> 8)
+				dpix[i+1] = uint8(v)
+			}
+		}
+	}
+	return dst
+}
+
+// convertFloatTransfer re-encodes floating-point samples from one transfer
+// function to another.
+func convertFloatTransfer(pix []float32, from, to npcolor.Transfer) {
+	for i, v := range pix {
+		pix[i] = float32(to.FromLinear(from.ToLinear(float64(v))))
+	}
+}
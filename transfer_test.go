@@ -0,0 +1,265 @@
+// Test conversion between transfer functions.
+
+package netpbm
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spakin/netpbm/npcolor"
+)
+
+// transferImage returns a small color image with an alpha channel.
+func transferImage() *RGBAM {
+	img := NewRGBAM(image.Rect(0, 0, 3, 1), 255)
+	img.SetRGBAM(0, 0, npcolor.RGBAM{R: 0, G: 128, B: 255, A: 77, M: 255})
+	img.SetRGBAM(1, 0, npcolor.RGBAM{R: 10, G: 20, B: 30, A: 255, M: 255})
+	img.SetRGBAM(2, 0, npcolor.RGBAM{R: 200, G: 100, B: 50, A: 0, M: 255})
+	return img
+}
+
+// TestConvertTransfer confirms that ConvertTransfer re-encodes color
+// channels but not alpha channels.
+func TestConvertTransfer(t *testing.T) {
+	// Convert a Netpbm image.
+	img := transferImage()
+	lin, ok := ConvertTransfer(img, npcolor.BT709, npcolor.Linear).(*RGBAM)
+	if !ok {
+		t.Fatalf("Expected an *RGBAM but saw %T", lin)
+	}
+	for x := 0; x < 3; x++ {
+		c, lc := img.RGBAMAt(x, 0), lin.RGBAMAt(x, 0)
+		for i, v := range []uint8{c.R, c.G, c.B} {
+			e := uint8(math.Round(npcolor.BT709.ToLinear(float64(v)/255) * 255))
+			if lv := []uint8{lc.R, lc.G, lc.B}[i]; lv != e {
+				t.Fatalf("Expected channel %d of %v to map to %d but saw %d", i, c, e, lv)
+			}
+		}
+		if lc.A != c.A {
+			t.Fatalf("Expected alpha %d but saw %d", c.A, lc.A)
+		}
+	}
+
+	// Converting a subimage should convert only the corresponding pixels.
+	sub := ConvertTransfer(img.SubImage(image.Rect(1, 0, 3, 1)), npcolor.BT709, npcolor.Linear)
+	if !reflect.DeepEqual(sub.(*RGBAM).Pix, lin.Pix[4:]) {
+		t.Fatalf("Expected %v but saw %v", lin.Pix[4:], sub.(*RGBAM).Pix)
+	}
+
+	// Convert a 16-bit grayscale image and back.
+	gray := NewGrayM32(image.Rect(0, 0, 1001, 1), 1000)
+	for x := 0; x <= 1000; x++ {
+		gray.SetGrayM32(x, 0, npcolor.GrayM32{Y: uint16(x), M: 1000})
+	}
+	g2 := ConvertTransfer(gray, npcolor.SRGB, npcolor.Gamma(2.2))
+	g2 = ConvertTransfer(g2, npcolor.Gamma(2.2), npcolor.SRGB)
+	for x := 0; x <= 1000; x++ {
+		if y := int(g2.(*GrayM32).GrayM32At(x, 0).Y); y < x-2 || y > x+2 {
+			t.Fatalf("Expected %d to round-trip but saw %d", x, y)
+		}
+	}
+
+	// Convert an image from the standard library.
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	nrgba.SetNRGBA(0, 0, color.NRGBA{R: 255, G: 128, B: 0, A: 128})
+	n64, ok := ConvertTransfer(nrgba, npcolor.Linear, npcolor.SRGB).(*RGBAM64)
+	if !ok {
+		t.Fatalf("Expected an *RGBAM64 but saw %T", n64)
+	}
+	e := npcolor.RGBAM64{R: 0xffff, G: uint16(math.Round(npcolor.SRGB.FromLinear(128.0/255) * 0xffff)), B: 0, A: 0x8080, M: 0xffff}
+	if c := n64.RGBAM64At(0, 0); c != e {
+		t.Fatalf("Expected %v but saw %v", e, c)
+	}
+
+	// Convert a floating-point image.
+	fimg := NewGrayF32(image.Rect(0, 0, 2, 1))
+	copy(fimg.Pix, []float32{0.25, 4.0})
+	f2 := ConvertTransfer(fimg, npcolor.Linear, npcolor.Gamma(2.0)).(*GrayF32)
+	if f2.Pix[0] != 0.5 || f2.Pix[1] != 2.0 || fimg.Pix[0] != 0.25 {
+		t.Fatalf("Unexpected conversion of %v to %v", fimg.Pix, f2.Pix)
+	}
+}
+
+// TestDecodeTransfer confirms that Decode converts samples from the file's
+// transfer function to the requested one.
+func TestDecodeTransfer(t *testing.T) {
+	// Decoding with a different ImageTransfer should convert the image.
+	img := transferImage()
+	var w bytes.Buffer
+	if err := Encode(&w, img, &EncodeOptions{Format: PAM}); err != nil {
+		t.Fatal(err)
+	}
+	pam := w.Bytes()
+	for _, tc := range []struct {
+		file, image npcolor.Transfer
+		exp         Image
+	}{
+		{nil, nil, img},
+		{nil, npcolor.BT709, img},
+		{npcolor.SRGB, npcolor.SRGB, img},
+		{nil, npcolor.Linear, ConvertTransfer(img, npcolor.BT709, npcolor.Linear)},
+		{npcolor.Linear, npcolor.SRGB, ConvertTransfer(img, npcolor.Linear, npcolor.SRGB)},
+	} {
+		opts := &DecodeOptions{Target: PAM, Transfer: tc.file, ImageTransfer: tc.image}
+		img2, err := Decode(bytes.NewReader(pam), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(img2, tc.exp) {
+			t.Fatalf("Expected %v but saw %v", tc.exp, img2)
+		}
+	}
+
+	// PFM files are assumed to be linear.
+	w.Reset()
+	fimg := NewGrayF32(image.Rect(0, 0, 1, 1))
+	fimg.Pix[0] = 0.5
+	if err := Encode(&w, fimg, nil); err != nil {
+		t.Fatal(err)
+	}
+	img2, err := Decode(&w, &DecodeOptions{ImageTransfer: npcolor.Gamma(2.0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, v := float32(math.Sqrt(0.5)), img2.(*GrayF32).Pix[0]; v != e {
+		t.Fatalf("Expected %v but saw %v", e, v)
+	}
+}
+
+// TestEncodeTransfer confirms that Encode converts samples from the image's
+// transfer function to the file's.
+func TestEncodeTransfer(t *testing.T) {
+	img := transferImage()
+	for _, tf := range []npcolor.Transfer{npcolor.Linear, npcolor.SRGB} {
+		var w bytes.Buffer
+		if err := Encode(&w, img, &EncodeOptions{Format: PAM, ImageTransfer: tf}); err != nil {
+			t.Fatal(err)
+		}
+		img2, err := Decode(&w, &DecodeOptions{Target: PAM})
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp := ConvertTransfer(img, tf, npcolor.BT709)
+		if !reflect.DeepEqual(img2, exp) {
+			t.Fatalf("Expected %v but saw %v", exp, img2)
+		}
+	}
+}
+
+// TestScaleLinear confirms that reduced-resolution decoding can average
+// pixels in linear light.
+func TestScaleLinear(t *testing.T) {
+	// Prepare a grayscale image and a black-and-white image, each
+	// containing one black and one white pixel.
+	gray := NewGrayM(image.Rect(0, 0, 2, 1), 255)
+	gray.SetGrayM(1, 0, npcolor.GrayM{Y: 255, M: 255})
+	bw := NewBW(image.Rect(0, 0, 2, 1))
+	bw.Set(0, 0, color.Black)
+	half := uint8(math.Round(npcolor.BT709.FromLinear(0.5) * 255))
+	for _, img := range []Image{gray, bw} {
+		var w bytes.Buffer
+		if err := Encode(&w, img, nil); err != nil {
+			t.Fatal(err)
+		}
+		data := w.Bytes()
+		for _, tc := range []struct {
+			blend BlendSpace
+			tf    npcolor.Transfer
+			exp   uint8
+		}{
+			{GammaBlend, nil, 128},
+			{LinearBlend, nil, half},
+			{LinearBlend, npcolor.Linear, 128},
+		} {
+			opts := &DecodeOptions{Target: PGM, Scale: 2, Blend: tc.blend, Transfer: tc.tf}
+			img2, err := Decode(bytes.NewReader(data), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if y := img2.(*GrayM).GrayMAt(0, 0).Y; y != tc.exp {
+				t.Fatalf("Expected %s averaging %+v to produce %d but saw %d", img.Format(), tc, tc.exp, y)
+			}
+		}
+	}
+}
+
+// TestTransferOutOfRange confirms that samples exceeding a raw file's maximum
+// value are treated as the maximum value when converting between transfer
+// functions.
+func TestTransferOutOfRange(t *testing.T) {
+	for _, tc := range []struct {
+		data string
+		opts DecodeOptions
+	}{
+		{"P5 4 2 100\n" + strings.Repeat("\xc8", 8), DecodeOptions{Scale: 2, Blend: LinearBlend}},
+		{"P5 4 2 100\n" + strings.Repeat("\xc8", 8), DecodeOptions{ImageTransfer: npcolor.Linear}},
+		{"P5 2 1 1000\n" + strings.Repeat("\xff", 4), DecodeOptions{Scale: 2, Blend: LinearBlend}},
+		{"P5 2 1 1000\n" + strings.Repeat("\xff", 4), DecodeOptions{ImageTransfer: npcolor.Linear}},
+	} {
+		img, err := Decode(strings.NewReader(tc.data), &tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := img.MaxValue()
+		r := img.Bounds()
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				c := npcolor.GrayM32Model{M: m}.Convert(img.At(x, y)).(npcolor.GrayM32)
+				if c.Y != m {
+					t.Fatalf("Expected %d at (%d, %d) of %q with %+v but saw %d", m, x, y, tc.data, tc.opts, c.Y)
+				}
+			}
+		}
+	}
+}
+
+// TestTransferSpaceM confirms that transfer-function conversions of
+// color-space images re-encode the colors the coordinates represent rather
+// than the coordinates themselves.
+func TestTransferSpaceM(t *testing.T) {
+	rgb := NewRGBM64(image.Rect(0, 0, 2, 2), 0xffff)
+	copy(rgb.Pix, []uint8{
+		0x80, 0x00, 0x40, 0x00, 0xff, 0xff,
+		0xff, 0xff, 0x20, 0x00, 0x00, 0x00,
+		0x10, 0x00, 0xc0, 0x00, 0x60, 0x00,
+		0x33, 0x33, 0x33, 0x33, 0x33, 0x33,
+	})
+	hsv := ConvertToSpace(rgb, npcolor.SpaceHSV)
+	lin, ok := ConvertTransfer(hsv, npcolor.BT709, npcolor.Linear).(*SpaceM)
+	if !ok {
+		t.Fatalf("Expected a *SpaceM but saw %T", lin)
+	}
+	linRGB := ConvertTransfer(rgb, npcolor.BT709, npcolor.Linear)
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			r1, g1, b1, _ := lin.At(x, y).RGBA()
+			r2, g2, b2, _ := linRGB.At(x, y).RGBA()
+			c1 := color.RGBA64{uint16(r1), uint16(g1), uint16(b1), 0xffff}
+			c2 := color.RGBA64{uint16(r2), uint16(g2), uint16(b2), 0xffff}
+			for i, v := range []int{int(r1) - int(r2), int(g1) - int(g2), int(b1) - int(b2)} {
+				if v < -2 || v > 2 {
+					t.Fatalf("Expected channel %d of (%d, %d) to be near %v but saw %v", i, x, y, c2, c1)
+				}
+			}
+		}
+	}
+
+	// Reduced-resolution decoding should refuse to average color-space
+	// coordinates in linear light.
+	var w bytes.Buffer
+	if err := Encode(&w, hsv, &EncodeOptions{Format: PAM, TupleType: "HSV"}); err != nil {
+		t.Fatal(err)
+	}
+	data := w.Bytes()
+	if _, err := Decode(bytes.NewReader(data), &DecodeOptions{Target: PAM, Scale: 2, Blend: LinearBlend}); err == nil {
+		t.Fatal("Decode averaged HSV coordinates in linear light")
+	}
+	if _, err := Decode(bytes.NewReader(data), &DecodeOptions{Target: PAM, Scale: 2}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,347 @@
+// This file provides in-place modification of the pixels in an existing raw
+// Netpbm file.
+
+package netpbm
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/spakin/netpbm/npcolor"
+)
+
+// maxPending is the number of bytes of modified rows a FileImage buffers
+// before automatically flushing them to the file.
+const maxPending = 1 << 20
+
+// A dirtyRow holds a copy of one row of a raster that has been modified but
+// not yet written to the file.
+type dirtyRow struct {
+	data   []byte // Entire row, read from the file and then modified
+	lo, hi int    // Range [lo, hi) of data that has been modified
+}
+
+// A fileRaster represents the raster portion of a raw Netpbm file.  It is
+// shared by a FileImage and all of its subimages.
+type fileRaster struct {
+	f        *os.File            // File containing the image
+	header   Header              // Parsed image header
+	offset   int64               // Byte offset of the raster within the file
+	stride   int64               // Bytes per row of the raster
+	bpp      int                 // Bytes per pixel (0 for PBM)
+	proto    Image               // 1x1 image describing the pixel format
+	pending  map[int64]*dirtyRow // Modified rows not yet written, keyed by row number
+	nPending int64               // Number of bytes held in pending
+	err      error               // Sticky error state
+}
+
+// A FileImage is an Image whose pixels reside in a raw Netpbm file rather
+// than in memory.  At reads pixels from the file, and Set buffers modified
+// pixels, which are written to the file by Flush.  Because the Image
+// interface provides no means for At and Set to report I/O errors, the first
+// such error is retained and returned by Flush.
+type FileImage struct {
+	raster *fileRaster     // Data shared with all subimages
+	Rect   image.Rectangle // The image's bounds
+}
+
+// OpenForUpdate parses the header of a raw (binary) PBM, PGM, PPM, or PAM file
+// and returns an image whose pixels can be read and modified in place.  The
+// file must be open for both reading and writing.  Plain (ASCII) files are
+// rejected because modifying a sample can change its length.  In PBM files,
+// each modification rewrites only the bit belonging to the modified pixel;
+// row padding is left untouched.  Callers must invoke Flush to ensure that
+// all modifications have been written to the file.
+func OpenForUpdate(f *os.File) (*FileImage, error) {
+	// Parse the header.
+	sr := io.NewSectionReader(f, 0, 1<<63-1)
+	nr := newNetpbmReader(bufio.NewReader(sr))
+	header, err := nr.GetHeader()
+	if err != nil {
+		return nil, err
+	}
+	fr := &fileRaster{
+		f:       f,
+		header:  header,
+		offset:  header.RasterOffset,
+		pending: make(map[int64]*dirtyRow),
+	}
+
+	// Determine the raster layout and pixel format.
+	if header.Plain {
+		return nil, errors.New("Plain Netpbm files can't be updated in place")
+	}
+	switch header.Magic {
+	case "P4":
+		fr.stride = int64(header.Width+7) / 8
+		fr.proto = NewBW(image.Rect(0, 0, 1, 1))
+	default:
+		h := header
+		h.Width, h.Height = 1, 1
+		fr.proto, err = rawImageFromHeader(h, make([]uint8, 8))
+		if err != nil {
+			return nil, err
+		}
+		fr.bpp = fr.proto.PixOffset(1, 0)
+		fr.stride = int64(header.Width) * int64(fr.bpp)
+	}
+
+	// Ensure the file is large enough to contain the entire raster.
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < fr.offset+fr.stride*int64(header.Height) {
+		return nil, errors.New("Netpbm file is truncated")
+	}
+	return &FileImage{
+		raster: fr,
+		Rect:   image.Rect(0, 0, header.Width, header.Height),
+	}, nil
+}
+
+// pixelImage returns a 1x1 image whose Pix field aliases the given bytes,
+// which represent a single non-PBM pixel.
+func (fr *fileRaster) pixelImage(buf []byte) Image {
+	h := fr.header
+	h.Width, h.Height = 1, 1
+	img, err := rawImageFromHeader(h, buf)
+	if err != nil {
+		panic(err) // Already validated by OpenForUpdate
+	}
+	return img
+}
+
+// readBytes reads len(buf) bytes starting at a given raster offset, taking
+// into account modifications that have not yet been flushed.
+func (fr *fileRaster) readBytes(ofs int64, buf []byte) error {
+	_, err := fr.f.ReadAt(buf, fr.offset+ofs)
+	if err != nil {
+		return err
+	}
+	for len(buf) > 0 {
+		row, col := ofs/fr.stride, int(ofs%fr.stride)
+		n := len(buf)
+		if rem := int(fr.stride) - col; n > rem {
+			n = rem
+		}
+		if dr, ok := fr.pending[row]; ok {
+			copy(buf[:n], dr.data[col:])
+		}
+		buf = buf[n:]
+		ofs += int64(n)
+	}
+	return nil
+}
+
+// writeBytes buffers len(buf) bytes to write starting at a given raster
+// offset, flushing the buffer if it grows too large.
+func (fr *fileRaster) writeBytes(ofs int64, buf []byte) error {
+	for len(buf) > 0 {
+		// Buffer the row containing ofs, reading it from the file the
+		// first time it is modified.
+		row, col := ofs/fr.stride, int(ofs%fr.stride)
+		dr, ok := fr.pending[row]
+		if !ok {
+			dr = &dirtyRow{data: make([]byte, fr.stride), lo: col, hi: col}
+			if _, err := fr.f.ReadAt(dr.data, fr.offset+row*fr.stride); err != nil {
+				return err
+			}
+			fr.pending[row] = dr
+			fr.nPending += fr.stride
+		}
+
+		// Modify the row and extend its modified range.
+		n := copy(dr.data[col:], buf)
+		if col < dr.lo {
+			dr.lo = col
+		}
+		if col+n > dr.hi {
+			dr.hi = col + n
+		}
+		buf = buf[n:]
+		ofs += int64(n)
+	}
+	if fr.nPending >= maxPending {
+		return fr.flush()
+	}
+	return nil
+}
+
+// flush writes the modified portion of each buffered row to the file in
+// order of increasing offset.
+func (fr *fileRaster) flush() error {
+	// Sort the numbers of all modified rows.
+	if len(fr.pending) == 0 {
+		return nil
+	}
+	rows := make([]int64, 0, len(fr.pending))
+	for row := range fr.pending {
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i] < rows[j] })
+
+	// Write the modified range of each row.
+	for _, row := range rows {
+		dr := fr.pending[row]
+		ofs := fr.offset + row*fr.stride + int64(dr.lo)
+		if _, err := fr.f.WriteAt(dr.data[dr.lo:dr.hi], ofs); err != nil {
+			return err
+		}
+	}
+	fr.pending = make(map[int64]*dirtyRow)
+	fr.nPending = 0
+	return nil
+}
+
+// ColorModel returns the FileImage's color model.
+func (p *FileImage) ColorModel() color.Model { return p.raster.proto.ColorModel() }
+
+// Bounds returns the domain for which At can return non-zero color.  The
+// bounds do not necessarily contain the point (0, 0).
+func (p *FileImage) Bounds() image.Rectangle { return p.Rect }
+
+// PixOffset returns the offset from the start of the raster of the first
+// byte that corresponds to the pixel at (x, y).  For PBM files, this is the
+// byte containing the pixel's bit.
+func (p *FileImage) PixOffset(x, y int) int {
+	fr := p.raster
+	if fr.bpp == 0 {
+		return y*int(fr.stride) + x/8
+	}
+	return y*int(fr.stride) + x*fr.bpp
+}
+
+// At returns the color of the pixel at (x, y) as a color.Color.
+// At(Bounds().Min.X, Bounds().Min.Y) returns the upper-left pixel of the grid.
+// At(Bounds().Max.X-1, Bounds().Max.Y-1) returns the lower-right one.
+func (p *FileImage) At(x, y int) color.Color {
+	fr := p.raster
+	if !(image.Point{x, y}.In(p.Rect)) || fr.err != nil {
+		return fr.proto.ColorModel().Convert(color.Transparent)
+	}
+	ofs := int64(p.PixOffset(x, y))
+	if fr.bpp == 0 {
+		// PBM: Extract a single bit.
+		var b [1]byte
+		if fr.err = fr.readBytes(ofs, b[:]); fr.err != nil {
+			return p.At(x, y)
+		}
+		bit := (b[0] >> uint(7-x%8)) & 1
+		return fr.proto.(*BW).Palette[bit]
+	}
+
+	// Other formats: Wrap the pixel's bytes in a 1x1 image.
+	buf := make([]byte, fr.bpp)
+	if fr.err = fr.readBytes(ofs, buf); fr.err != nil {
+		return p.At(x, y)
+	}
+	return fr.pixelImage(buf).At(0, 0)
+}
+
+// Set sets the pixel at (x, y) to a given color, expressed as a color.Color.
+func (p *FileImage) Set(x, y int, c color.Color) {
+	fr := p.raster
+	if !(image.Point{x, y}.In(p.Rect)) || fr.err != nil {
+		return
+	}
+	ofs := int64(p.PixOffset(x, y))
+	if fr.bpp == 0 {
+		// PBM: Modify a single bit.
+		var b [1]byte
+		if fr.err = fr.readBytes(ofs, b[:]); fr.err != nil {
+			return
+		}
+		bit := byte(fr.proto.(*BW).Palette.Index(c))
+		shift := uint(7 - x%8)
+		b[0] = b[0]&^(1<<shift) | bit<<shift
+		fr.err = fr.writeBytes(ofs, b[:])
+		return
+	}
+
+	// Other formats: Set the pixel in a 1x1 image, and write its bytes.
+	buf := make([]byte, fr.bpp)
+	fr.pixelImage(buf).Set(0, 0, c)
+	fr.err = fr.writeBytes(ofs, buf)
+}
+
+// SetGrayM sets the pixel at (x, y) to a given color, expressed as an
+// npcolor.GrayM.
+func (p *FileImage) SetGrayM(x, y int, c npcolor.GrayM) {
+	p.Set(x, y, c)
+}
+
+// SetGrayM32 sets the pixel at (x, y) to a given color, expressed as an
+// npcolor.GrayM32.
+func (p *FileImage) SetGrayM32(x, y int, c npcolor.GrayM32) {
+	p.Set(x, y, c)
+}
+
+// SetRGBM sets the pixel at (x, y) to a given color, expressed as an
+// npcolor.RGBM.
+func (p *FileImage) SetRGBM(x, y int, c npcolor.RGBM) {
+	p.Set(x, y, c)
+}
+
+// SetRGBM64 sets the pixel at (x, y) to a given color, expressed as an
+// npcolor.RGBM64.
+func (p *FileImage) SetRGBM64(x, y int, c npcolor.RGBM64) {
+	p.Set(x, y, c)
+}
+
+// Flush writes all modified pixels to the file.  It returns the first error
+// encountered by Flush, At, or Set.
+func (p *FileImage) Flush() error {
+	fr := p.raster
+	if fr.err != nil {
+		return fr.err
+	}
+	fr.err = fr.flush()
+	return fr.err
+}
+
+// SubImage returns an image representing the portion of the image p visible
+// through r.  The returned value shares the file and its buffered
+// modifications with the original image.
+func (p *FileImage) SubImage(r image.Rectangle) image.Image {
+	return &FileImage{
+		raster: p.raster,
+		Rect:   r.Intersect(p.Rect),
+	}
+}
+
+// Opaque scans the entire image and reports whether it is fully opaque.
+func (p *FileImage) Opaque() bool {
+	if !p.HasAlpha() {
+		return true
+	}
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		for x := p.Rect.Min.X; x < p.Rect.Max.X; x++ {
+			if _, _, _, a := p.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// MaxValue returns the maximum value allowed on any color channel.
+func (p *FileImage) MaxValue() uint16 {
+	return p.raster.proto.MaxValue()
+}
+
+// Format identifies the image as a PBM, PGM, or PPM image, using the same
+// rules as Decode.
+func (p *FileImage) Format() Format {
+	return p.raster.proto.Format()
+}
+
+// HasAlpha indicates whether the image has an alpha channel.
+func (p *FileImage) HasAlpha() bool {
+	return p.raster.proto.HasAlpha()
+}
@@ -0,0 +1,146 @@
+// Test in-place modification of Netpbm files.
+
+package netpbm
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/spakin/netpbm/npcolor"
+)
+
+// encodeToFile encodes an image to a temporary file and returns the file,
+// open for reading and writing.  The caller should pass the file to
+// removeFile when finished with it.
+func encodeToFile(t *testing.T, img image.Image, opts *EncodeOptions) *os.File {
+	f, err := ioutil.TempFile("", "netpbm-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Encode(f, img, opts); err != nil {
+		removeFile(f)
+		t.Fatal(err)
+	}
+	return f
+}
+
+// removeFile closes and deletes a temporary file.
+func removeFile(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// decodeFromFile decodes an image from the beginning of a file.
+func decodeFromFile(t *testing.T, f *os.File) Image {
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	img, err := Decode(f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// TestUpdatePPM confirms that modifying pixels in a PPM file leaves the
+// remaining pixels intact.
+func TestUpdatePPM(t *testing.T) {
+	img0 := imageFromString(t, ppmRaw, PPM).(*RGBM)
+	f := encodeToFile(t, img0, &EncodeOptions{Comments: []string{"Updated in place"}})
+	defer removeFile(f)
+	fimg, err := OpenForUpdate(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	red := npcolor.RGBM{R: 255, M: 255}
+	for x := 10; x < 20; x++ {
+		fimg.SetRGBM(x, 7, red)
+	}
+	if c := fimg.At(12, 7); c != red {
+		t.Fatalf("Expected %v before flushing but read %v", red, c)
+	}
+	if err = fimg.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	img1 := decodeFromFile(t, f).(*RGBM)
+	for x := 10; x < 20; x++ {
+		img0.SetRGBM(x, 7, red)
+	}
+	if !bytes.Equal(img0.Pix, img1.Pix) {
+		t.Fatal("File contents differ from expected contents")
+	}
+}
+
+// TestUpdateManyRows confirms that modifications spanning more rows than can
+// be buffered at once are flushed correctly and remain visible before and
+// after flushing.
+func TestUpdateManyRows(t *testing.T) {
+	img0 := NewRGBM(image.Rect(0, 0, 1000, 400), 255)
+	f := encodeToFile(t, img0, nil)
+	defer removeFile(f)
+	fimg, err := OpenForUpdate(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < 400; y++ {
+		c := npcolor.RGBM{R: uint8(y), G: uint8(y >> 8), B: 1, M: 255}
+		fimg.SetRGBM(y, y, c)
+		fimg.SetRGBM(999-y, y, c)
+		img0.SetRGBM(y, y, c)
+		img0.SetRGBM(999-y, y, c)
+	}
+	for y := 0; y < 400; y++ {
+		if e, a := img0.RGBMAt(y, y), fimg.At(y, y); a != e {
+			t.Fatalf("Expected %v at (%d, %d) before flushing but read %v", e, y, y, a)
+		}
+	}
+	if err = fimg.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	img1 := decodeFromFile(t, f).(*RGBM)
+	if !bytes.Equal(img0.Pix, img1.Pix) {
+		t.Fatal("File contents differ from expected contents")
+	}
+}
+
+// TestUpdatePBM confirms that modifying pixels in a PBM file modifies only
+// the targeted bits.
+func TestUpdatePBM(t *testing.T) {
+	bw := NewBW(image.Rect(0, 0, 13, 3))
+	f := encodeToFile(t, bw, nil)
+	defer removeFile(f)
+	fimg, err := OpenForUpdate(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fimg.Set(9, 1, color.Black)
+	fimg.Set(12, 2, color.Black)
+	if err = fimg.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	img := decodeFromFile(t, f).(*BW)
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 13; x++ {
+			want := uint8(0)
+			if (x == 9 && y == 1) || (x == 12 && y == 2) {
+				want = 1
+			}
+			if got := img.ColorIndexAt(x, y); got != want {
+				t.Fatalf("Expected %d at (%d, %d) but read %d", want, x, y, got)
+			}
+		}
+	}
+}
+
+// TestUpdatePlain confirms that plain files are rejected.
+func TestUpdatePlain(t *testing.T) {
+	f := encodeToFile(t, NewGrayM(image.Rect(0, 0, 4, 4), 255), &EncodeOptions{Plain: true})
+	defer removeFile(f)
+	if _, err := OpenForUpdate(f); err == nil {
+		t.Fatal("Opening a plain file for update unexpectedly succeeded")
+	}
+}